@@ -34,11 +34,27 @@ const (
 
 const (
 	RandomString SecretType = iota
+	Password
+	RSAKeyPair
+	Ed25519KeyPair
+	UUID
+	HMACKey
+	TLSCertificate
 )
 
 type SecretRef struct {
 	Name string
 	Type SecretType
+	// Rotate marks secrets that `xlrte secret rotate` may regenerate. Values that identify
+	// something, such as user names, must not be rotated.
+	Rotate bool
+	// Length is the number of characters of strings and passwords, the bytes of HMAC keys
+	// and the bits of RSA keys. Zero uses the default of the type.
+	Length int
+	// Charset is the character set of strings and passwords, one of secrets.Charsets.
+	Charset string
+	// Hosts are the DNS names of TLS certificates, which need at least one.
+	Hosts []string
 }
 
 type DeploymentConfig struct {
@@ -56,8 +72,9 @@ type Runtime interface {
 	InitEnvironment(ctx context.Context, env, project, region string) error
 	//Init initialises for a plan or apply
 	Init(env EnvContext) error
-	//InitSecrets initialises the secrets system.
-	InitSecrets(env EnvContext, secrets []*secrets.Secret) error
+	//InitSecrets initialises the secrets system with the decrypted secrets of the deployment, and the names of all
+	//secrets of the environment, which must be kept even though nothing references them.
+	InitSecrets(env EnvContext, secrets []*secrets.Secret, stored []string) error
 	Resources() []ResourceLoader
 	Services() []ServiceLoader
 	Apply(ctx context.Context) error
@@ -72,6 +89,8 @@ type ResourceDefinition struct {
 	ServiceConfig      []byte
 	ResourceConfig     *[]byte
 	unclaimedResources map[string]interface{}
+	// RootDir is the configuration directory relative paths in the definition are resolved against.
+	RootDir string
 }
 
 type ResourceLoader interface {
@@ -89,8 +108,25 @@ type Resource interface {
 	Configure() error
 }
 
+// CanMigrate is implemented by Resources that need to run migrations (such as database schema changes)
+// after the resources they target exist, but before the rest of the deployment is applied.
 type CanMigrate interface {
+	//MigrationTargets are the resources that must be applied before Migrate is run.
+	MigrationTargets() []ResourceIdentity
 	Migrate(ctx context.Context) error
+	//PendingMigrations lists the migrations that Migrate would run, without running them.
+	PendingMigrations(ctx context.Context) ([]string, error)
+}
+
+// CanValidateEnvironments is implemented by Runtimes with settings that must be consistent across environments,
+// such as network ranges that must not overlap between environments in the same project.
+type CanValidateEnvironments interface {
+	ValidateEnvironments(envs []Environment) error
+}
+
+// CanApplyTargets is implemented by Runtimes that can apply a subset of their resources ahead of a full apply.
+type CanApplyTargets interface {
+	ApplyTargets(ctx context.Context, targets []ResourceIdentity) error
 }
 
 // ResourceIdentity is something that uniquely identifies instances of a Resource, for instance Type: "cloudsql", ID: "the-database-name"
@@ -104,6 +140,9 @@ type DependencyBinding struct {
 	Identity     ResourceIdentity
 	Config       DependencyVisitor
 	SecretRefs   []SecretRef
+	// Declared requires the resource it binds to be created by some service of the deployment,
+	// such as the topic a bucket publishes notifications to, rather than to exist outside of it.
+	Declared bool
 }
 
 type DependencyVisitor interface {
@@ -200,8 +239,3 @@ func (rd *ResourceDefinition) GetConfig(name string, readInto interface{}) error
 	err = yaml.Unmarshal(bytes, readInto)
 	return err
 }
-
-func (secretRef *SecretRef) Generate() *secrets.Secret {
-	sec := &secrets.Secret{Name: secretRef.Name, Value: secrets.RandStringBytes()}
-	return sec
-}
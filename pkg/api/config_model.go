@@ -1,8 +1,10 @@
 package api
 
+import "gopkg.in/yaml.v2"
+
 type Environment struct {
 	Context    string `yaml:"context" validate:"required"`
-	RepoBase   string `docker_repo:"context"`
+	RepoBase   string `yaml:"docker_repo"` // defaults to gcr.io of the context
 	Region     string `yaml:"region" validate:"required"`
 	StateStore string `yaml:"state_store" validate:"required"`
 	EnvName    string `yaml:"-" validate:"required"`
@@ -20,6 +22,9 @@ type EnvContext struct {
 	StateStore string
 	EnvName    string
 	Version    func(string) (string, error)
+	//Config reads the environment-level resource with the given name, such as `http`, into readInto.
+	//It leaves readInto untouched if the environment does not configure the resource.
+	Config func(name string, readInto interface{}) error
 }
 
 type Service struct {
@@ -28,6 +33,7 @@ type Service struct {
 	Spec      interface{}            `yaml:"spec" validate:"required"`
 	DependsOn map[string]interface{} `yaml:"depends_on"`
 	Env       EnvVars                `yaml:"env"`
+	Secrets   map[string]SecretSpec  `yaml:"secrets"`
 }
 
 type EnvVars struct {
@@ -80,31 +86,40 @@ func (env *Environment) ctx() EnvContext {
 		StateStore: env.StateStore,
 		Version:    env.Resolver.Version,
 		RepoBase:   env.RepoBase,
+		Config:     env.resourceConfig,
+	}
+}
+
+func (env *Environment) resourceConfig(name string, readInto interface{}) error {
+	if env.Resources[name] == nil {
+		return nil
+	}
+	bytes, err := yaml.Marshal(env.Resources[name])
+	if err != nil {
+		return err
 	}
+	return yaml.Unmarshal(bytes, readInto)
 }
 
 func (output *EnvVars) Merge(secondOutput EnvVars) {
-	if output.Vars == nil {
-		output.Vars = secondOutput.Vars
-	} else if secondOutput.Vars != nil {
-		for k, v := range secondOutput.Vars {
-			output.Vars[k] = v
-		}
+	if output.Vars == nil && secondOutput.Vars != nil {
+		output.Vars = make(map[string]string)
+	}
+	for k, v := range secondOutput.Vars {
+		output.Vars[k] = v
 	}
 
-	if output.Refs == nil {
-		output.Refs = secondOutput.Refs
-	} else if secondOutput.Refs != nil {
-		for k, v := range secondOutput.Refs {
-			output.Refs[k] = v
-		}
+	if output.Refs == nil && secondOutput.Refs != nil {
+		output.Refs = make(map[string]string)
+	}
+	for k, v := range secondOutput.Refs {
+		output.Refs[k] = v
 	}
 
-	if output.Secrets == nil {
-		output.Secrets = secondOutput.Secrets
-	} else if secondOutput.Secrets != nil {
-		for k, v := range secondOutput.Secrets {
-			output.Secrets[k] = v
-		}
+	if output.Secrets == nil && secondOutput.Secrets != nil {
+		output.Secrets = make(map[string]string)
+	}
+	for k, v := range secondOutput.Secrets {
+		output.Secrets[k] = v
 	}
 }
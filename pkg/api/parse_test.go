@@ -15,6 +15,10 @@ func Test_Read_Service_Definition(t *testing.T) {
 	assert.Equal(t, "cloudrun-srv", service.Name())
 	assert.Equal(t, "cloudrun", service.Runtime)
 	assert.NotNil(t, service.Spec)
+	assert.Equal(t, map[string]SecretSpec{
+		"signing-key": {Type: "ed25519"},
+		"api-token":   {Type: "password", Length: 40, Charset: "ascii", Rotate: true},
+	}, service.Secrets)
 }
 
 func Test_Invalid_Service(t *testing.T) {
@@ -60,6 +64,18 @@ func Test_Read_Env_Definition(t *testing.T) {
 	assert.Equal(t, len(envs), 1)
 	assert.Equal(t, envs[0].EnvName, "prod")
 	assert.Equal(t, envs[0].Region, "europe-west6")
+	assert.Equal(t, "europe-west6-docker.pkg.dev/chaordic/images/", envs[0].RepoBase)
+
+	var http struct {
+		Domain string `yaml:"domain"`
+	}
+	envs[0].Resolver = &selector
+	ctx := envs[0].ctx()
+	assert.NoError(t, ctx.Config("http", &http))
+	assert.Equal(t, "cde.app", http.Domain)
+	var missing []string
+	assert.NoError(t, ctx.Config("not-configured", &missing))
+	assert.Nil(t, missing)
 }
 
 func Test_Merge_EnvVars(t *testing.T) {
@@ -2,9 +2,11 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 
 	"github.com/xlrte/core/pkg/api/secrets"
 	"gopkg.in/yaml.v2"
@@ -18,6 +20,7 @@ const (
 	Export
 	Apply
 	Delete
+	Migrate
 )
 
 func InitEnvironment(ctx context.Context, baseDir, env, context, region string, runtime Runtime) error {
@@ -59,10 +62,16 @@ func Prepare(rootDir string, selector EnvResolver, runtimes *Runtimes) ([]*Deplo
 }
 
 func Execute(ctx context.Context, cmd Command, rootDir string, selector EnvResolver, runtimes *Runtimes) error {
-	configs, _, err := Prepare(rootDir, selector, runtimes)
+	configs, preApply, err := Prepare(rootDir, selector, runtimes)
 	if err != nil {
 		return err
 	}
+	if cmd == Apply || cmd == Migrate {
+		err = migrate(ctx, configs, preApply)
+		if err != nil || cmd == Migrate {
+			return err
+		}
+	}
 	for _, config := range configs {
 		err := exec(ctx, cmd, config.Runtime)
 		if err != nil {
@@ -72,6 +81,109 @@ func Execute(ctx context.Context, cmd Command, rootDir string, selector EnvResol
 	return nil
 }
 
+// PendingMigrations returns the migrations that have not yet been applied, keyed by the resource they belong to.
+func PendingMigrations(ctx context.Context, rootDir string, selector EnvResolver, runtimes *Runtimes) (map[string][]string, error) {
+	configs, _, err := Prepare(rootDir, selector, runtimes)
+	if err != nil {
+		return nil, err
+	}
+	pending := make(map[string][]string)
+	for _, config := range configs {
+		for _, migration := range config.migrations() {
+			names, e := migration.PendingMigrations(ctx)
+			if e != nil {
+				return nil, e
+			}
+			if len(names) > 0 {
+				pending[migration.(Resource).Identity().String()] = names
+			}
+		}
+	}
+	return pending, nil
+}
+
+// RotateSecrets regenerates the generated secrets of an environment that may be rotated, or only those
+// in names if given. The new values are encrypted like any other secret and deployed by the next apply.
+func RotateSecrets(rootDir string, selector EnvResolver, runtimes *Runtimes, names []string) ([]string, error) {
+	configs, err := parseDeploymentConfig(rootDir, selector, runtimes)
+	if err != nil {
+		return nil, err
+	}
+	rotatable := make(map[string]SecretRef)
+	for _, config := range configs {
+		_, bindings, e := loadResources(config)
+		if e != nil {
+			return nil, e
+		}
+		refs, e := deploymentSecretRefs(config.Services, bindings)
+		if e != nil {
+			return nil, e
+		}
+		for _, ref := range refs {
+			if ref.Rotate {
+				rotatable[ref.Name] = ref
+			}
+		}
+	}
+	if len(names) == 0 {
+		for name := range rotatable {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		ref, found := rotatable[name]
+		if !found {
+			return nil, fmt.Errorf("secret %s is not a generated secret that can be rotated", name)
+		}
+		newSecrets, e := ref.Generate()
+		if e != nil {
+			return nil, e
+		}
+		for _, newSecret := range newSecrets {
+			err = secrets.WriteSecret(rootDir, selector.Env(), newSecret.Name, newSecret.Value)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return names, nil
+}
+
+// migrate applies the resources that migrations depend on, then runs the migrations,
+// so that dependent services are only updated once their migrations have been applied.
+func migrate(ctx context.Context, configs []*DeploymentConfig, preApply preApplyFn) error {
+	for _, config := range configs {
+		targets := []ResourceIdentity{}
+		for _, migration := range config.migrations() {
+			targets = append(targets, migration.MigrationTargets()...)
+		}
+		if len(targets) == 0 {
+			continue
+		}
+		rte, ok := config.Runtime.(CanApplyTargets)
+		if !ok {
+			return fmt.Errorf("runtime %s does not support migrations", config.Runtime.Name())
+		}
+		err := rte.ApplyTargets(ctx, targets)
+		if err != nil {
+			return err
+		}
+	}
+	return preApply(ctx)
+}
+
+func (config *DeploymentConfig) migrations() []CanMigrate {
+	migrations := []CanMigrate{}
+	for _, resource := range config.underlyingResources {
+		migration, ok := resource.(CanMigrate)
+		if ok {
+			migrations = append(migrations, migration)
+		}
+	}
+	return migrations
+}
+
 func exec(ctx context.Context, cmd Command, rte Runtime) error {
 	switch cmd {
 	case Plan:
@@ -91,24 +203,16 @@ func configureDeployment(baseDir string, deployments []*DeploymentConfig) (preAp
 	toApply := []preApplyFn{}
 	var err error
 	dependencyDefinitions := []DependencyBinding{}
+	deploymentBindings := make(map[*DeploymentConfig][]DependencyBinding)
 	resources := []Resource{}
 
 	for _, deployment := range deployments {
-		tmpResources := []Resource{}
-
-		for _, loader := range deployment.Runtime.Resources() {
-			for _, defs := range deployment.Resources {
-
-				if defs.Name == loader.Name() {
-					rs, bindings, e := loader.Load(defs)
-					if e != nil {
-						return nil, e
-					}
-					dependencyDefinitions = append(dependencyDefinitions, bindings...)
-					tmpResources = append(tmpResources, rs...)
-				}
-			}
+		tmpResources, bindings, e := loadResources(deployment)
+		if e != nil {
+			return nil, e
 		}
+		dependencyDefinitions = append(dependencyDefinitions, bindings...)
+		deploymentBindings[deployment] = bindings
 
 		added := make(map[ResourceIdentity]string)
 		for _, r := range tmpResources {
@@ -119,14 +223,11 @@ func configureDeployment(baseDir string, deployments []*DeploymentConfig) (preAp
 			}
 		}
 		deployment.underlyingResources = resources
-		for _, resource := range resources {
-			migrate, ok := resource.(CanMigrate)
-			if ok {
-				toApply = append(toApply, migrate.Migrate)
-			}
-			if err != nil {
-				return nil, err
-			}
+	}
+	for _, resource := range resources {
+		migration, ok := resource.(CanMigrate)
+		if ok {
+			toApply = append(toApply, migration.Migrate)
 		}
 	}
 	for _, deployment := range deployments {
@@ -170,43 +271,25 @@ func configureDeployment(baseDir string, deployments []*DeploymentConfig) (preAp
 				resources = append(resources, resource)
 			}
 		}
-		homeDir, e := os.UserHomeDir()
+		refs, e := deploymentSecretRefs(deployment.Services, deploymentBindings[deployment])
 		if e != nil {
 			return nil, e
 		}
-		allSecrets, e := secrets.GetAllSecrets(homeDir, baseDir, envCtx.EnvName)
+		deploymentSecrets, stored, e := loadSecrets(baseDir, envCtx.EnvName, deployment.Services, refs)
 		if e != nil {
 			return nil, e
 		}
-		for _, dep := range dependencyDefinitions {
-			if dep.SecretRefs != nil && len(dep.SecretRefs) > 0 {
-				for _, ref := range dep.SecretRefs {
-					ref.Name = fmt.Sprintf("%s_%s", dep.Identity.String(), ref.Name)
-					foundSecret := false
-					for _, secret := range allSecrets {
-						if secret.Name == ref.Name {
-							foundSecret = true
-							break
-						}
-					}
-					if !foundSecret {
-						newSecret := ref.Generate()
-						allSecrets = append(allSecrets, newSecret)
-						err = secrets.WriteSecret(baseDir, envCtx.EnvName, newSecret.Name, newSecret.Value)
-						if err != nil {
-							return nil, err
-						}
-					}
-				}
-			}
-		}
 
-		err = deployment.Runtime.InitSecrets(envCtx, allSecrets)
+		err = deployment.Runtime.InitSecrets(envCtx, deploymentSecrets, stored)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	err = validateDeclaredBindings(resources, dependencyDefinitions)
+	if err != nil {
+		return nil, err
+	}
 	for _, resource := range resources {
 		for _, dep := range dependencyDefinitions {
 			if resource.Identity() == dep.DependedOnBy && dep.Config != nil {
@@ -233,6 +316,116 @@ func configureDeployment(baseDir string, deployments []*DeploymentConfig) (preAp
 	}, nil
 }
 
+// loadSecrets returns the secrets the services of a deployment and their dependencies reference, generating those
+// that do not exist yet, and the names of all secrets of the environment. Only referenced secrets are decrypted,
+// the runtime only learns the names of the others, so that it keeps them without their values.
+func loadSecrets(baseDir, env string, services []*Service, refs []SecretRef) ([]*secrets.Secret, []string, error) {
+	users := secretUsers(services)
+	referenced := []string{}
+	for name := range users {
+		referenced = append(referenced, name)
+	}
+	loaded := []*secrets.Secret{}
+	stored, err := secrets.ListSecrets(baseDir, env)
+	var noSecrets *secrets.NoSecretsError
+	if errors.As(err, &noSecrets) && len(referenced) == 0 && len(refs) == 0 {
+		return loaded, []string{}, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	exists := make(map[string]bool)
+	names := []string{}
+	for _, info := range stored {
+		exists[info.Name] = true
+		names = append(names, info.Name)
+	}
+	generated := make(map[string]bool)
+	for _, ref := range refs {
+		for _, name := range ref.storedNames() {
+			generated[name] = true
+		}
+	}
+	err = checkReferencedSecrets(env, users, exists, generated)
+	if err != nil {
+		return nil, nil, err
+	}
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		if exists[ref.Name] {
+			referenced = append(referenced, ref.storedNames()...)
+			continue
+		}
+		if seen[ref.Name] {
+			continue
+		}
+		newSecrets, e := ref.Generate()
+		if e != nil {
+			return nil, nil, e
+		}
+		for _, secret := range newSecrets {
+			err = secrets.WriteSecret(baseDir, env, secret.Name, secret.Value)
+			if err != nil {
+				return nil, nil, err
+			}
+			seen[secret.Name] = true
+			loaded = append(loaded, secret)
+			names = append(names, secret.Name)
+		}
+	}
+	toDecrypt := []string{}
+	for _, name := range referenced {
+		if exists[name] && !seen[name] {
+			seen[name] = true
+			toDecrypt = append(toDecrypt, name)
+		}
+	}
+	sort.Strings(toDecrypt)
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	decrypted, err := secrets.GetSecrets(homeDir, baseDir, env, toDecrypt)
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(names)
+	return append(loaded, decrypted...), names, nil
+}
+
+func loadResources(deployment *DeploymentConfig) ([]Resource, []DependencyBinding, error) {
+	resources := []Resource{}
+	dependencyDefinitions := []DependencyBinding{}
+	for _, loader := range deployment.Runtime.Resources() {
+		for _, defs := range deployment.Resources {
+
+			if defs.Name == loader.Name() {
+				rs, bindings, e := loader.Load(defs)
+				if e != nil {
+					return nil, nil, e
+				}
+				dependencyDefinitions = append(dependencyDefinitions, bindings...)
+				resources = append(resources, rs...)
+			}
+		}
+	}
+	return resources, dependencyDefinitions, nil
+}
+
+// validateDeclaredBindings checks that the resources of bindings that must be declared are created by the deployment.
+func validateDeclaredBindings(resources []Resource, bindings []DependencyBinding) error {
+	declared := make(map[ResourceIdentity]bool)
+	for _, resource := range resources {
+		declared[resource.Identity()] = true
+	}
+	for _, binding := range bindings {
+		if binding.Declared && !declared[binding.Identity] {
+			return fmt.Errorf("%s depends on %s, which is not declared by any service", binding.DependedOnBy.String(), binding.Identity.String())
+		}
+	}
+	return nil
+}
+
 func parseDeploymentConfig(rootDir string, selector EnvResolver, runtimes *Runtimes) ([]*DeploymentConfig, error) {
 	if _, err := os.Stat(rootDir); os.IsNotExist(err) {
 		return nil, fmt.Errorf("The directory " + rootDir + " does not exist")
@@ -294,10 +487,10 @@ func parseDeploymentConfig(rootDir string, selector EnvResolver, runtimes *Runti
 				if err != nil {
 					return nil, err
 				}
-				resource := ResourceDefinition{DependedOnBy: svc.ToIdentity(), ServiceConfig: bytes, ResourceConfig: &resourceBytes, Name: k}
+				resource := ResourceDefinition{DependedOnBy: svc.ToIdentity(), ServiceConfig: bytes, ResourceConfig: &resourceBytes, Name: k, RootDir: rootDir}
 				conf.Resources = append(conf.Resources, &resource)
 			} else {
-				resource := ResourceDefinition{DependedOnBy: svc.ToIdentity(), ServiceConfig: bytes, ResourceConfig: nil, Name: k}
+				resource := ResourceDefinition{DependedOnBy: svc.ToIdentity(), ServiceConfig: bytes, ResourceConfig: nil, Name: k, RootDir: rootDir}
 				conf.Resources = append(conf.Resources, &resource)
 			}
 		}
@@ -321,6 +514,13 @@ func parseDeploymentConfig(rootDir string, selector EnvResolver, runtimes *Runti
 
 	configs := []*DeploymentConfig{}
 	for _, v := range runtimeMap {
+		validator, ok := v.Runtime.(CanValidateEnvironments)
+		if ok {
+			err = validator.ValidateEnvironments(envs)
+			if err != nil {
+				return nil, err
+			}
+		}
 		configs = append(configs, v)
 	}
 	return configs, nil
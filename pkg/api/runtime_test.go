@@ -35,11 +35,18 @@ type dummyRuntime struct {
 	serviceConfigured int
 	resourceLoaders   []ResourceLoader
 	secretsInited     bool
+	initedSecrets     []string
+	storedSecrets     []string
 	secretsInServices map[string]string
+	appliedTargets    []ResourceIdentity
+	applied           bool
+	events            *[]string
+	validatedEnvs     []string
 }
 
 type dummyResource struct {
 	resourceName string
+	events       *[]string
 }
 
 type cloudSql struct {
@@ -48,6 +55,7 @@ type cloudSql struct {
 	Size       string `yaml:"size"`
 	isCorrect  bool
 	isMigrated bool
+	events     *[]string
 }
 type cloudSqlConfig struct {
 	ResourceIdentity ResourceIdentity
@@ -107,6 +115,7 @@ func Test_Valid_Environment(t *testing.T) {
 	assert.Len(t, defs[0].Services, 2)
 	assert.Len(t, defs[0].Services[0].Env.Vars, 1)
 	assert.Len(t, defs[0].Services[1].Env.Vars, 1)
+	assert.Equal(t, []string{"prod"}, runtimes.Runtimes[0].(*dummyRuntime).validatedEnvs)
 
 }
 
@@ -127,17 +136,28 @@ func Test_Valid_Deploy(t *testing.T) {
 		assert.NoError(t, err)
 	}()
 
+	rootDir := filepath.Join("testdata", "valid-env")
+	writeReferencedSecrets(t, rootDir)
+	assert.NoError(t, secrets.WriteSecret(rootDir, "prod", "unused", "not referenced"))
+
 	runtimes := Runtimes{
 		Runtimes: []Runtime{&dummyRuntime{
 			ResourceTypes: []string{"cloudsql", "pubsub", "gcs"},
 		}},
 	}
 
-	configs, preApply, err := Prepare(filepath.Join("testdata", "valid-env"), &selector, &runtimes)
+	configs, preApply, err := Prepare(rootDir, &selector, &runtimes)
 	assert.NoError(t, err)
 	assert.NotNil(t, configs)
 	assert.NotNil(t, preApply)
 	assert.True(t, runtimes.Runtimes[0].(*dummyRuntime).secretsInited)
+	assert.ElementsMatch(t, []string{
+		"cloudsql-my-pg-db_USERNAME", "cloudsql-my-pg-db_PASSWORD",
+		"cloudsql-another-db_USERNAME", "cloudsql-another-db_PASSWORD",
+		"here", "theSecret",
+	}, runtimes.Runtimes[0].(*dummyRuntime).initedSecrets, "only referenced secrets are decrypted")
+	assert.Contains(t, runtimes.Runtimes[0].(*dummyRuntime).storedSecrets, "unused", "unreferenced secrets are kept by name")
+	assert.Len(t, runtimes.Runtimes[0].(*dummyRuntime).storedSecrets, 7)
 	assert.NoError(t, preApply(context.Background()))
 	assert.Greater(t, len(configs), 0)
 	for _, conf := range configs {
@@ -186,7 +206,233 @@ func Test_Valid_Deploy(t *testing.T) {
 		return nil
 	})
 	assert.NoError(t, err)
-	assert.Equal(t, 2, secretFiles)
+	assert.Equal(t, 7, secretFiles)
+}
+
+func Test_Apply_Runs_Migrations_Before_Apply(t *testing.T) {
+	secretsDir := filepath.Join("testdata", "valid-env", "environments", "prod", "secrets")
+	err := os.RemoveAll(secretsDir)
+	assert.NoError(t, err)
+	err = os.MkdirAll(secretsDir, 0750)
+	assert.NoError(t, err)
+	err = os.Setenv("XLRTE_PRIVATE_KEY", privateKey)
+	assert.NoError(t, err)
+	err = os.Setenv("XLRTE_PASSPHRASE", "pass")
+	assert.NoError(t, err)
+	defer func() {
+		err = os.Setenv("XLRTE_PRIVATE_KEY", "")
+		assert.NoError(t, err)
+		err = os.Setenv("XLRTE_PASSPHRASE", "")
+		assert.NoError(t, err)
+	}()
+
+	writeReferencedSecrets(t, filepath.Join("testdata", "valid-env"))
+
+	events := []string{}
+	rte := &dummyRuntime{
+		ResourceTypes: []string{"cloudsql", "pubsub", "gcs"},
+		events:        &events,
+	}
+	runtimes := Runtimes{Runtimes: []Runtime{rte}}
+
+	pending, err := PendingMigrations(context.Background(), filepath.Join("testdata", "valid-env"), &selector, &runtimes)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"cloudsql-my-pg-db":   {"0001_init.sql"},
+		"cloudsql-another-db": {"0001_init.sql"},
+	}, pending)
+	assert.False(t, rte.applied)
+	assert.Len(t, rte.appliedTargets, 0)
+
+	events = events[:0]
+	rte.secretsInServices = nil
+	err = Execute(context.Background(), Migrate, filepath.Join("testdata", "valid-env"), &selector, &runtimes)
+	assert.NoError(t, err)
+	assert.False(t, rte.applied)
+
+	events = events[:0]
+	rte.secretsInServices = nil
+	rte.appliedTargets = nil
+	err = Execute(context.Background(), Apply, filepath.Join("testdata", "valid-env"), &selector, &runtimes)
+	assert.NoError(t, err)
+	assert.True(t, rte.applied)
+	assert.ElementsMatch(t, []ResourceIdentity{{Type: "cloudsql", ID: "my-pg-db"}, {Type: "cloudsql", ID: "another-db"}}, rte.appliedTargets)
+	assert.Equal(t, "apply-targets", events[0])
+	assert.ElementsMatch(t, []string{"migrate-my-pg-db", "migrate-another-db"}, events[1:3])
+	assert.Equal(t, "apply", events[3])
+}
+
+func Test_Rotate_Secrets(t *testing.T) {
+	secretsDir := filepath.Join("testdata", "valid-env", "environments", "prod", "secrets")
+	err := os.RemoveAll(secretsDir)
+	assert.NoError(t, err)
+	err = os.MkdirAll(secretsDir, 0750)
+	assert.NoError(t, err)
+	err = os.Setenv("XLRTE_PRIVATE_KEY", privateKey)
+	assert.NoError(t, err)
+	err = os.Setenv("XLRTE_PASSPHRASE", "pass")
+	assert.NoError(t, err)
+	defer func() {
+		err = os.Setenv("XLRTE_PRIVATE_KEY", "")
+		assert.NoError(t, err)
+		err = os.Setenv("XLRTE_PASSPHRASE", "")
+		assert.NoError(t, err)
+	}()
+
+	runtimes := Runtimes{Runtimes: []Runtime{&dummyRuntime{ResourceTypes: []string{"cloudsql", "pubsub", "gcs"}}}}
+	rootDir := filepath.Join("testdata", "valid-env")
+	writeReferencedSecrets(t, rootDir)
+	_, _, err = Prepare(rootDir, &selector, &runtimes)
+	assert.NoError(t, err)
+	before := secretValues(t, rootDir)
+
+	rotated, err := RotateSecrets(rootDir, &selector, &runtimes, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cloudsql-another-db_PASSWORD", "cloudsql-my-pg-db_PASSWORD"}, rotated)
+	after := secretValues(t, rootDir)
+	assert.NotEqual(t, before["cloudsql-my-pg-db_PASSWORD"], after["cloudsql-my-pg-db_PASSWORD"])
+	assert.NotEqual(t, before["cloudsql-another-db_PASSWORD"], after["cloudsql-another-db_PASSWORD"])
+	assert.Equal(t, before["cloudsql-my-pg-db_USERNAME"], after["cloudsql-my-pg-db_USERNAME"])
+
+	rotated, err = RotateSecrets(rootDir, &selector, &runtimes, []string{"cloudsql-my-pg-db_PASSWORD"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cloudsql-my-pg-db_PASSWORD"}, rotated)
+	assert.Equal(t, after["cloudsql-another-db_PASSWORD"], secretValues(t, rootDir)["cloudsql-another-db_PASSWORD"])
+
+	_, err = RotateSecrets(rootDir, &selector, &runtimes, []string{"cloudsql-my-pg-db_USERNAME"})
+	assert.EqualError(t, err, "secret cloudsql-my-pg-db_USERNAME is not a generated secret that can be rotated")
+}
+
+func Test_Missing_Secrets(t *testing.T) {
+	secretsDir := filepath.Join("testdata", "valid-env", "environments", "prod", "secrets")
+	err := os.RemoveAll(secretsDir)
+	assert.NoError(t, err)
+	err = os.MkdirAll(secretsDir, 0750)
+	assert.NoError(t, err)
+	err = os.Setenv("XLRTE_PRIVATE_KEY", privateKey)
+	assert.NoError(t, err)
+	err = os.Setenv("XLRTE_PASSPHRASE", "pass")
+	assert.NoError(t, err)
+	defer func() {
+		err = os.Setenv("XLRTE_PRIVATE_KEY", "")
+		assert.NoError(t, err)
+		err = os.Setenv("XLRTE_PASSPHRASE", "")
+		assert.NoError(t, err)
+	}()
+
+	rootDir := filepath.Join("testdata", "valid-env")
+	assert.NoError(t, secrets.WriteSecret(rootDir, "prod", "here", "referenced"))
+	assert.NoError(t, secrets.WriteSecret(rootDir, "prod", "unused", "not referenced"))
+	runtimes := Runtimes{Runtimes: []Runtime{&dummyRuntime{ResourceTypes: []string{"cloudsql", "pubsub", "gcs"}}}}
+
+	report, err := CheckSecrets(rootDir, &selector, &runtimes)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"theSecret": {"cloudrun-srv2"}}, report.Missing)
+	assert.Equal(t, []string{"unused"}, report.Unused)
+
+	_, _, err = Prepare(rootDir, &selector, &runtimes)
+	assert.EqualError(t, err, "secrets referenced by services do not exist in environment prod: theSecret (used by cloudrun-srv2), add them with `xlrte secret add -e prod -n <name>`")
+	assert.Nil(t, runtimes.Runtimes[0].(*dummyRuntime).initedSecrets, "nothing is decrypted")
+
+	assert.NoError(t, secrets.WriteSecret(rootDir, "prod", "theSecret", "referenced"))
+	runtimes = Runtimes{Runtimes: []Runtime{&dummyRuntime{ResourceTypes: []string{"cloudsql", "pubsub", "gcs"}}}}
+	_, _, err = Prepare(rootDir, &selector, &runtimes)
+	assert.NoError(t, err)
+
+	// an environment whose secrets have not been initialised lacks all secrets, except those generated on deploy
+	stagingDir := filepath.Join(rootDir, "environments", "staging")
+	assert.NoError(t, os.MkdirAll(stagingDir, 0750))
+	defer func() {
+		assert.NoError(t, os.RemoveAll(stagingDir))
+	}()
+	resources, err := ioutil.ReadFile(filepath.Join(rootDir, "environments", "prod", "resources.yaml"))
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(stagingDir, "resources.yaml"), resources, 0600))
+	runtimes = Runtimes{Runtimes: []Runtime{&dummyRuntime{ResourceTypes: []string{"cloudsql", "pubsub", "gcs"}}}}
+	report, err = CheckSecrets(rootDir, &selector, &runtimes)
+	assert.NoError(t, err)
+	assert.Len(t, report.Missing, 0)
+	assert.Equal(t, []string{"unused"}, report.Unused, "generated secrets are used")
+	assert.Equal(t, map[string][]string{"staging": {"here", "theSecret", "unused"}}, report.MissingInEnvironments)
+}
+
+func Test_Generated_Secrets_Can_Be_Referenced(t *testing.T) {
+	secretsDir := filepath.Join("testdata", "valid-env", "environments", "prod", "secrets")
+	err := os.RemoveAll(secretsDir)
+	assert.NoError(t, err)
+	err = os.MkdirAll(secretsDir, 0750)
+	assert.NoError(t, err)
+	err = os.Setenv("XLRTE_PRIVATE_KEY", privateKey)
+	assert.NoError(t, err)
+	err = os.Setenv("XLRTE_PASSPHRASE", "pass")
+	assert.NoError(t, err)
+	defer func() {
+		err = os.Setenv("XLRTE_PRIVATE_KEY", "")
+		assert.NoError(t, err)
+		err = os.Setenv("XLRTE_PASSPHRASE", "")
+		assert.NoError(t, err)
+	}()
+
+	services := []*Service{{
+		SVCName: "signer",
+		Env:     EnvVars{Secrets: map[string]string{"SIGNING_KEY": "signing", "ADMIN_PASSWORD": "admin", "SIGNING_PUBLIC_KEY": "signing_PUBLIC"}},
+		Secrets: map[string]SecretSpec{"signing": {Type: "ed25519"}, "admin": {Type: "password"}},
+	}}
+	refs, err := deploymentSecretRefs(services, nil)
+	assert.NoError(t, err)
+	loaded, _, err := loadSecrets(filepath.Join("testdata", "valid-env"), "prod", services, refs)
+	assert.NoError(t, err, "declared secrets are generated on the first deploy")
+	names := []string{}
+	for _, secret := range loaded {
+		names = append(names, secret.Name)
+	}
+	assert.ElementsMatch(t, []string{"admin", "signing", "signing_PUBLIC"}, names)
+
+	users := secretUsers(services)
+	assert.NoError(t, checkReferencedSecrets("prod", users, map[string]bool{}, map[string]bool{"admin": true, "signing": true, "signing_PUBLIC": true}))
+	assert.Error(t, checkReferencedSecrets("prod", users, map[string]bool{}, map[string]bool{"admin": true}))
+}
+
+// writeReferencedSecrets writes the secrets the services of valid-env reference.
+func writeReferencedSecrets(t *testing.T, rootDir string) {
+	assert.NoError(t, secrets.WriteSecret(rootDir, "prod", "here", "referenced"))
+	assert.NoError(t, secrets.WriteSecret(rootDir, "prod", "theSecret", "referenced"))
+}
+
+func secretValues(t *testing.T, rootDir string) map[string]string {
+	homeDir, err := os.UserHomeDir()
+	assert.NoError(t, err)
+	all, err := secrets.GetAllSecrets(homeDir, rootDir, "prod")
+	assert.NoError(t, err)
+	values := make(map[string]string)
+	for _, secret := range all {
+		values[secret.Name] = secret.Value
+	}
+	return values
+}
+
+func Test_Declared_Bindings_Must_Exist(t *testing.T) {
+	db := &cloudSql{Name: "my-db"}
+	bindings := []DependencyBinding{{
+		DependedOnBy: ResourceIdentity{Type: "cloudrun", ID: "srv"},
+		Identity:     db.Identity(),
+		Declared:     true,
+	}}
+	assert.NoError(t, validateDeclaredBindings([]Resource{db}, bindings))
+
+	err := validateDeclaredBindings([]Resource{}, bindings)
+	assert.EqualError(t, err, "cloudrun-srv depends on cloudsql-my-db, which is not declared by any service")
+
+	bindings[0].Declared = false
+	assert.NoError(t, validateDeclaredBindings([]Resource{}, bindings))
+}
+
+func (rt *dummyRuntime) ValidateEnvironments(envs []Environment) error {
+	rt.validatedEnvs = []string{}
+	for _, env := range envs {
+		rt.validatedEnvs = append(rt.validatedEnvs, env.EnvName)
+	}
+	return nil
 }
 
 func (rt *dummyRuntime) Name() string {
@@ -207,7 +453,7 @@ func (rt *dummyRuntime) Resources() []ResourceLoader {
 	}
 	loaders := []ResourceLoader{}
 	for _, resource := range rt.ResourceTypes {
-		loaders = append(loaders, &dummyResource{resourceName: resource})
+		loaders = append(loaders, &dummyResource{resourceName: resource, events: rt.events})
 	}
 	return loaders
 }
@@ -218,8 +464,13 @@ func (rt *dummyRuntime) Services() []ServiceLoader {
 	}
 }
 
-func (rt *dummyRuntime) InitSecrets(env EnvContext, secrets []*secrets.Secret) error {
+func (rt *dummyRuntime) InitSecrets(env EnvContext, secrets []*secrets.Secret, stored []string) error {
 	rt.secretsInited = true
+	rt.storedSecrets = stored
+	rt.initedSecrets = []string{}
+	for _, secret := range secrets {
+		rt.initedSecrets = append(rt.initedSecrets, secret.Name)
+	}
 	return nil
 }
 
@@ -261,6 +512,18 @@ func (cr *dummyCloudRun) Configure() error {
 }
 
 func (rt *dummyRuntime) Apply(ctx context.Context) error {
+	rt.applied = true
+	if rt.events != nil {
+		*rt.events = append(*rt.events, "apply")
+	}
+	return nil
+}
+
+func (rt *dummyRuntime) ApplyTargets(ctx context.Context, targets []ResourceIdentity) error {
+	rt.appliedTargets = append(rt.appliedTargets, targets...)
+	if rt.events != nil {
+		*rt.events = append(*rt.events, "apply-targets")
+	}
 	return nil
 }
 func (rt *dummyRuntime) Plan(ctx context.Context) error {
@@ -308,8 +571,9 @@ func (rt *dummyResource) Load(d *ResourceDefinition) ([]Resource, []DependencyBi
 			Privileges:   Owner,
 			Identity:     ResourceIdentity{Type: "cloudsql", ID: db.Name},
 			Config:       &cloudSqlConfig{ResourceIdentity{Type: "cloudsql", ID: db.Name}},
-			SecretRefs:   []SecretRef{{Name: "USERNAME", Type: RandomString}},
+			SecretRefs:   []SecretRef{{Name: "USERNAME", Type: RandomString}, {Name: "PASSWORD", Type: RandomString, Rotate: true}},
 		})
+		db.events = rt.events
 		rs = append(rs, db)
 	}
 
@@ -333,9 +597,23 @@ func (r *cloudSqlConfig) ConfigureResource(resource Resource) error {
 
 func (r *cloudSql) Migrate(ctx context.Context) error {
 	r.isMigrated = true
+	if r.events != nil {
+		*r.events = append(*r.events, "migrate-"+r.Name)
+	}
 	return nil
 }
 
+func (r *cloudSql) MigrationTargets() []ResourceIdentity {
+	return []ResourceIdentity{r.Identity()}
+}
+
+func (r *cloudSql) PendingMigrations(ctx context.Context) ([]string, error) {
+	if r.isMigrated {
+		return []string{}, nil
+	}
+	return []string{"0001_init.sql"}, nil
+}
+
 func (r *cloudSql) Identity() ResourceIdentity {
 	return ResourceIdentity{Type: "cloudsql", ID: r.Name}
 }
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xlrte/core/pkg/api/secrets"
+)
+
+// SecretReport describes how the secrets of an environment are used by the services deployed to it,
+// and which secrets other environments have that it does not.
+type SecretReport struct {
+	Env string
+	// Missing are the secrets services reference in `env.secrets`, but which do not exist and are not generated,
+	// by the services using them.
+	Missing map[string][]string
+	// Unused are the secrets of the environment no service references and that are not generated for a resource.
+	Unused []string
+	// MissingInEnvironments are the secrets some environments have, but others do not, by the environments lacking them.
+	// Generated secrets are left out, as they are created by the first deployment to an environment.
+	MissingInEnvironments map[string][]string
+}
+
+// CheckSecrets reports the secrets services of the selected environment reference that do not exist, the secrets
+// nothing uses and the secrets environments lack compared to others. Secrets are never decrypted.
+func CheckSecrets(rootDir string, selector EnvResolver, runtimes *Runtimes) (*SecretReport, error) {
+	configs, err := parseDeploymentConfig(rootDir, selector, runtimes)
+	if err != nil {
+		return nil, err
+	}
+	report := &SecretReport{Env: selector.Env(), Missing: make(map[string][]string), Unused: []string{}, MissingInEnvironments: make(map[string][]string)}
+	users := make(map[string][]string)
+	generated := make(map[string]bool)
+	for _, config := range configs {
+		for name, services := range secretUsers(config.Services) {
+			users[name] = append(users[name], services...)
+		}
+		_, bindings, e := loadResources(config)
+		if e != nil {
+			return nil, e
+		}
+		refs, e := deploymentSecretRefs(config.Services, bindings)
+		if e != nil {
+			return nil, e
+		}
+		for _, ref := range refs {
+			for _, name := range ref.storedNames() {
+				generated[name] = true
+			}
+		}
+	}
+	envs, err := ReadAllEnvironments(filepath.Join(rootDir, "environments"))
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[string]map[string]bool)
+	all := make(map[string]bool)
+	for _, env := range envs {
+		names, e := storedSecrets(rootDir, env.EnvName)
+		if e != nil {
+			return nil, e
+		}
+		stored[env.EnvName] = names
+		for name := range names {
+			if !generated[name] {
+				all[name] = true
+			}
+		}
+	}
+	for name, services := range users {
+		if !stored[report.Env][name] && !generated[name] {
+			sort.Strings(services)
+			report.Missing[name] = services
+		}
+	}
+	for name := range stored[report.Env] {
+		if len(users[name]) == 0 && !generated[name] {
+			report.Unused = append(report.Unused, name)
+		}
+	}
+	sort.Strings(report.Unused)
+	for env, names := range stored {
+		for name := range all {
+			if !names[name] {
+				report.MissingInEnvironments[env] = append(report.MissingInEnvironments[env], name)
+			}
+		}
+		sort.Strings(report.MissingInEnvironments[env])
+	}
+	return report, nil
+}
+
+// storedSecrets are the names of the secrets of an environment, none if its secrets have not been initialised.
+func storedSecrets(rootDir, env string) (map[string]bool, error) {
+	names := make(map[string]bool)
+	infos, err := secrets.ListSecrets(rootDir, env)
+	var noSecrets *secrets.NoSecretsError
+	if errors.As(err, &noSecrets) {
+		return names, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		names[info.Name] = true
+	}
+	return names, nil
+}
+
+// secretUsers are the secrets services reference in `env.secrets`, by the names of the services referencing them.
+func secretUsers(services []*Service) map[string][]string {
+	users := make(map[string][]string)
+	for _, service := range services {
+		for _, name := range service.Env.Secrets {
+			users[name] = append(users[name], service.Name())
+		}
+	}
+	return users
+}
+
+// checkReferencedSecrets fails for referenced secrets that do not exist, before terraform fails on their missing modules.
+// Generated secrets are created by the deployment if they do not exist yet.
+func checkReferencedSecrets(env string, users map[string][]string, exists, generated map[string]bool) error {
+	missing := []string{}
+	for name, services := range users {
+		if !exists[name] && !generated[name] {
+			sort.Strings(services)
+			missing = append(missing, fmt.Sprintf("%s (used by %s)", name, strings.Join(services, ", ")))
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return fmt.Errorf("secrets referenced by services do not exist in environment %s: %s, add them with `xlrte secret add -e %s -n <name>`", env, strings.Join(missing, "; "), env)
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/xlrte/core/pkg/api/secrets"
+)
+
+// secretTypes are the names of secret types in the `secrets` of a service.
+var secretTypes = map[string]SecretType{
+	"random_string": RandomString,
+	"password":      Password,
+	"rsa":           RSAKeyPair,
+	"ed25519":       Ed25519KeyPair,
+	"uuid":          UUID,
+	"hmac":          HMACKey,
+	"tls":           TLSCertificate,
+}
+
+var secretName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// maxSecretLength and maxRSABits bound the length of secrets, so a typo does not make a deployment hang generating them.
+const (
+	maxSecretLength = 1024
+	maxRSABits      = 8192
+)
+
+// SecretSpec declares a secret in the `secrets` of a service, which is generated when it does not exist yet.
+// Services read it like any other secret through `env.secrets`.
+type SecretSpec struct {
+	Type    string   `yaml:"type"`
+	Length  int      `yaml:"length"`
+	Charset string   `yaml:"charset"`
+	Hosts   []string `yaml:"hosts"`
+	Rotate  bool     `yaml:"rotate"`
+}
+
+func (spec *SecretSpec) toRef(name string) (SecretRef, error) {
+	ref := SecretRef{Name: name, Length: spec.Length, Charset: spec.Charset, Hosts: spec.Hosts, Rotate: spec.Rotate}
+	if !secretName.MatchString(name) {
+		return ref, fmt.Errorf("invalid secret name '%s', names may only contain letters, digits, '-' and '_'", name)
+	}
+	secretType, found := secretTypes[spec.Type]
+	if !found {
+		supported := []string{}
+		for t := range secretTypes {
+			supported = append(supported, t)
+		}
+		sort.Strings(supported)
+		return ref, fmt.Errorf("secret %s: unsupported type '%s', supported types are %s", name, spec.Type, strings.Join(supported, ", "))
+	}
+	ref.Type = secretType
+	if spec.Length < 0 {
+		return ref, fmt.Errorf("secret %s: length can not be negative", name)
+	}
+	if _, found := secrets.Charsets[spec.Charset]; spec.Charset != "" && !found {
+		return ref, fmt.Errorf("secret %s: unknown charset '%s'", name, spec.Charset)
+	}
+	if ref.Type == RSAKeyPair && spec.Length != 0 && spec.Length < 2048 {
+		return ref, fmt.Errorf("secret %s: RSA keys need at least 2048 bits", name)
+	}
+	if ref.Type == RSAKeyPair && spec.Length > maxRSABits {
+		return ref, fmt.Errorf("secret %s: RSA keys can have at most %d bits", name, maxRSABits)
+	}
+	if ref.Type != RSAKeyPair && spec.Length > maxSecretLength {
+		return ref, fmt.Errorf("secret %s: length can be at most %d", name, maxSecretLength)
+	}
+	if ref.Type == TLSCertificate && len(spec.Hosts) == 0 {
+		return ref, fmt.Errorf("secret %s: tls certificates need hosts", name)
+	}
+	return ref, nil
+}
+
+// Generate creates the values of a secret. Key pairs and certificates are stored as two secrets,
+// the private key under the name of the secret and the public key or certificate suffixed with _PUBLIC or _CERT.
+func (secretRef *SecretRef) Generate() ([]*secrets.Secret, error) {
+	var value, public string
+	var err error
+	switch secretRef.Type {
+	case RandomString:
+		if secretRef.Length == 0 && secretRef.Charset == "" {
+			value = secrets.RandStringBytes()
+		} else {
+			value, err = secrets.RandomString(secretRef.lengthOr(30), secrets.Charsets[secretRef.charsetOr("url")])
+		}
+	case Password:
+		value, err = secrets.Password(secretRef.lengthOr(32), secretRef.charsetOr("alphanumeric"))
+	case UUID:
+		value, err = secrets.UUID()
+	case HMACKey:
+		value, err = secrets.HMACKey(secretRef.lengthOr(32))
+	case RSAKeyPair:
+		value, public, err = secrets.RSAKeyPair(secretRef.lengthOr(2048))
+	case Ed25519KeyPair:
+		value, public, err = secrets.Ed25519KeyPair()
+	case TLSCertificate:
+		value, public, err = secrets.SelfSignedCertificate(secretRef.Hosts, 365)
+	default:
+		return nil, fmt.Errorf("secret %s: unknown secret type %d", secretRef.Name, secretRef.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("secret %s: %w", secretRef.Name, err)
+	}
+	generated := []*secrets.Secret{{Name: secretRef.Name, Value: value}}
+	switch secretRef.Type {
+	case RSAKeyPair, Ed25519KeyPair:
+		generated = append(generated, &secrets.Secret{Name: secretRef.Name + "_PUBLIC", Value: public})
+	case TLSCertificate:
+		generated = append(generated, &secrets.Secret{Name: secretRef.Name + "_CERT", Value: public})
+	}
+	return generated, nil
+}
+
+// storedNames are the names Generate stores the secret under.
+func (secretRef *SecretRef) storedNames() []string {
+	switch secretRef.Type {
+	case RSAKeyPair, Ed25519KeyPair:
+		return []string{secretRef.Name, secretRef.Name + "_PUBLIC"}
+	case TLSCertificate:
+		return []string{secretRef.Name, secretRef.Name + "_CERT"}
+	}
+	return []string{secretRef.Name}
+}
+
+func (secretRef *SecretRef) lengthOr(defaultLength int) int {
+	if secretRef.Length == 0 {
+		return defaultLength
+	}
+	return secretRef.Length
+}
+
+func (secretRef *SecretRef) charsetOr(defaultCharset string) string {
+	if secretRef.Charset == "" {
+		return defaultCharset
+	}
+	return secretRef.Charset
+}
+
+// deploymentSecretRefs are the generated secrets of services and their dependencies, named as they are stored.
+// Secrets of dependencies are prefixed with the identity of the resource, such as `cloudsql-db_PASSWORD`.
+func deploymentSecretRefs(services []*Service, bindings []DependencyBinding) ([]SecretRef, error) {
+	refs := []SecretRef{}
+	for _, service := range services {
+		names := []string{}
+		for name := range service.Secrets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			spec := service.Secrets[name]
+			ref, err := spec.toRef(name)
+			if err != nil {
+				return nil, fmt.Errorf("service %s: %w", service.SVCName, err)
+			}
+			refs = append(refs, ref)
+		}
+	}
+	for _, dep := range bindings {
+		for _, ref := range dep.SecretRefs {
+			ref.Name = fmt.Sprintf("%s_%s", dep.Identity.String(), ref.Name)
+			refs = append(refs, ref)
+		}
+	}
+	return refs, nil
+}
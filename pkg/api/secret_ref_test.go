@@ -0,0 +1,77 @@
+package api
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlrte/core/pkg/api/secrets"
+)
+
+func Test_Generate_Secret_Types(t *testing.T) {
+	generated, err := (&SecretRef{Name: "pwd", Type: Password, Length: 40, Charset: "ascii"}).Generate()
+	assert.NoError(t, err)
+	assert.Len(t, generated, 1)
+	assert.Len(t, generated[0].Value, 40)
+
+	generated, err = (&SecretRef{Name: "id", Type: UUID}).Generate()
+	assert.NoError(t, err)
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, generated[0].Value)
+
+	generated, err = (&SecretRef{Name: "key", Type: Ed25519KeyPair}).Generate()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"key", "key_PUBLIC"}, secretNames(generated))
+	block, _ := pem.Decode([]byte(generated[1].Value))
+	_, err = x509.ParsePKIXPublicKey(block.Bytes)
+	assert.NoError(t, err)
+
+	generated, err = (&SecretRef{Name: "tls", Type: TLSCertificate, Hosts: []string{"api.example.com"}}).Generate()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tls", "tls_CERT"}, secretNames(generated))
+	block, _ = pem.Decode([]byte(generated[1].Value))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"api.example.com"}, cert.DNSNames)
+
+	generated, err = (&SecretRef{Name: "str", Type: RandomString}).Generate()
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, len(generated[0].Value), 10)
+	assert.LessOrEqual(t, len(generated[0].Value), 30)
+}
+
+func Test_Secret_Spec_Validation(t *testing.T) {
+	ref, err := (&SecretSpec{Type: "hmac", Length: 64, Rotate: true}).toRef("signing")
+	assert.NoError(t, err)
+	assert.Equal(t, SecretRef{Name: "signing", Type: HMACKey, Length: 64, Rotate: true}, ref)
+
+	_, err = (&SecretSpec{Type: "dsa"}).toRef("key")
+	assert.EqualError(t, err, "secret key: unsupported type 'dsa', supported types are ed25519, hmac, password, random_string, rsa, tls, uuid")
+	_, err = (&SecretSpec{Type: "password", Charset: "emoji"}).toRef("pwd")
+	assert.EqualError(t, err, "secret pwd: unknown charset 'emoji'")
+	_, err = (&SecretSpec{Type: "rsa", Length: 1024}).toRef("key")
+	assert.Error(t, err)
+	_, err = (&SecretSpec{Type: "rsa", Length: 65536}).toRef("key")
+	assert.EqualError(t, err, "secret key: RSA keys can have at most 8192 bits")
+	_, err = (&SecretSpec{Type: "password", Length: 4000000}).toRef("pwd")
+	assert.EqualError(t, err, "secret pwd: length can be at most 1024")
+	_, err = (&SecretSpec{Type: "random_string", Length: 1024}).toRef("token")
+	assert.NoError(t, err)
+	_, err = (&SecretSpec{Type: "tls"}).toRef("cert")
+	assert.Error(t, err)
+	_, err = (&SecretSpec{Type: "uuid"}).toRef("no.dots")
+	assert.Error(t, err)
+
+	refs, err := deploymentSecretRefs([]*Service{{SVCName: "srv", Secrets: map[string]SecretSpec{"id": {Type: "uuid"}}}},
+		[]DependencyBinding{{Identity: ResourceIdentity{Type: "cloudsql", ID: "db"}, SecretRefs: []SecretRef{{Name: "PASSWORD", Type: Password}}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []SecretRef{{Name: "id", Type: UUID}, {Name: "cloudsql-db_PASSWORD", Type: Password}}, refs)
+}
+
+func secretNames(generated []*secrets.Secret) []string {
+	names := []string{}
+	for _, secret := range generated {
+		names = append(names, secret.Name)
+	}
+	return names
+}
@@ -0,0 +1,190 @@
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+type ageConfig struct {
+	Recipients []string `yaml:"recipients"`
+}
+
+// ageStore keeps every secret in its own armored age file under `secrets/<name>.age`, encrypted to the
+// X25519 recipients listed in the resources.yaml of the environment.
+type ageStore struct {
+	baseDir    string
+	env        string
+	recipients []string
+	identity   func() (string, error)
+}
+
+// ageIdentity reads the age identities of the current user from XLRTE_AGE_KEY or $HOME/.xlrte/age-key.txt.
+func ageIdentity(homeDir string) (string, error) {
+	if os.Getenv("XLRTE_AGE_KEY") != "" {
+		return os.Getenv("XLRTE_AGE_KEY"), nil
+	}
+	data, err := ioutil.ReadFile(filepath.Clean(filepath.Join(homeDir, ".xlrte", "age-key.txt")))
+	if err != nil {
+		return "", fmt.Errorf("no age identity found, set XLRTE_AGE_KEY or create ~/.xlrte/age-key.txt with age-keygen: %w", err)
+	}
+	return string(data), nil
+}
+
+func (store *ageStore) path(name string) string {
+	return filepath.Join(store.baseDir, "environments", store.env, "secrets", fmt.Sprintf("%s.age", name))
+}
+
+func (store *ageStore) List() ([]*SecretInfo, error) {
+	secretsDir := filepath.Join(store.baseDir, "environments", store.env, "secrets")
+	files, err := ioutil.ReadDir(secretsDir)
+	if os.IsNotExist(err) {
+		return nil, &NoSecretsError{Env: store.env}
+	}
+	if err != nil {
+		return nil, err
+	}
+	// age headers do not name their recipients, so the configured ones are reported
+	recipients := append([]string{}, store.recipients...)
+	sort.Strings(recipients)
+	infos := []*SecretInfo{}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".age") {
+			continue
+		}
+		infos = append(infos, &SecretInfo{
+			Name:       strings.TrimSuffix(file.Name(), ".age"),
+			Recipients: recipients,
+			Modified:   file.ModTime(),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+func (store *ageStore) Read(name string) (string, error) {
+	identities, err := store.identities()
+	if err != nil {
+		return "", err
+	}
+	return store.decrypt(identities, name)
+}
+
+func (store *ageStore) ReadMany(names []string) ([]*Secret, error) {
+	identities, err := store.identities()
+	if err != nil {
+		return nil, err
+	}
+	secrets := []*Secret{}
+	for _, name := range names {
+		value, err := store.decrypt(identities, name)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, &Secret{Name: name, Value: value})
+	}
+	return secrets, nil
+}
+
+func (store *ageStore) ReadAll() ([]*Secret, error) {
+	identities, err := store.identities()
+	if err != nil {
+		return nil, err
+	}
+	infos, err := listStored(store)
+	if err != nil {
+		return nil, err
+	}
+	secrets := []*Secret{}
+	for _, info := range infos {
+		value, err := store.decrypt(identities, info.Name)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, &Secret{Name: info.Name, Value: value})
+	}
+	return secrets, nil
+}
+
+func (store *ageStore) Write(secrets ...*Secret) error {
+	if len(store.recipients) == 0 {
+		return fmt.Errorf("environment %s has no age recipients, add them to `secrets.age.recipients` in its resources.yaml", store.env)
+	}
+	recipients := []age.Recipient{}
+	for _, r := range store.recipients {
+		recipient, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			return fmt.Errorf("environment %s: %w", store.env, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+	err := os.MkdirAll(filepath.Join(store.baseDir, "environments", store.env, "secrets"), 0700)
+	if err != nil {
+		return err
+	}
+	for _, secret := range secrets {
+		buf := &bytes.Buffer{}
+		armored := armor.NewWriter(buf)
+		w, err := age.Encrypt(armored, recipients...)
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(w, secret.Value); err != nil {
+			return err
+		}
+		if err = w.Close(); err != nil {
+			return err
+		}
+		if err = armored.Close(); err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(store.path(secret.Name), buf.Bytes(), 0600)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *ageStore) Remove(name string) error {
+	path := store.path(name)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return fmt.Errorf("secret %s/%s does not exist", store.env, name)
+	}
+	return os.Remove(path)
+}
+
+func (store *ageStore) identities() ([]age.Identity, error) {
+	identity, err := store.identity()
+	if err != nil {
+		return nil, err
+	}
+	return age.ParseIdentities(strings.NewReader(identity))
+}
+
+func (store *ageStore) decrypt(identities []age.Identity, name string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Clean(store.path(name)))
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("secret %s/%s does not exist", store.env, name)
+	}
+	if err != nil {
+		return "", err
+	}
+	r, err := age.Decrypt(armor.NewReader(bytes.NewReader(data)), identities...)
+	if err != nil {
+		return "", fmt.Errorf("decrypting %s/%s failed: %w", store.env, name, err)
+	}
+	value, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
@@ -0,0 +1,227 @@
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+)
+
+// Agent keeps unlocked private keys in memory and decrypts secrets with them for clients on a Unix socket,
+// similar to gpg-agent, so that the passphrase is only entered once until the key expires.
+// Neither the passphrase nor the unlocked key are ever sent back to clients.
+type Agent struct {
+	ttl  time.Duration
+	mu   sync.Mutex
+	keys map[string]*agentKey // by fingerprint
+	now  func() time.Time
+}
+
+type agentKey struct {
+	keyRing *crypto.KeyRing
+	expires time.Time
+}
+
+type agentRequest struct {
+	Op          string `json:"op"` // unlock, decrypt or lock
+	Key         string `json:"key,omitempty"`
+	Passphrase  string `json:"passphrase,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Message     string `json:"message,omitempty"`
+}
+
+type agentResponse struct {
+	Plaintext string `json:"plaintext,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// NewAgent returns an agent that forgets keys once they have not been used for ttl.
+func NewAgent(ttl time.Duration) *Agent {
+	return &Agent{ttl: ttl, keys: make(map[string]*agentKey), now: time.Now}
+}
+
+// AgentSocket is the socket of the agent, XLRTE_AGENT_SOCK or $HOME/.xlrte/agent.sock.
+func AgentSocket() (string, error) {
+	if os.Getenv("XLRTE_AGENT_SOCK") != "" {
+		return os.Getenv("XLRTE_AGENT_SOCK"), nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".xlrte", "agent.sock"), nil
+}
+
+// Listen creates the socket of the agent, which only the current user can connect to.
+func Listen(socket string) (net.Listener, error) {
+	err := os.MkdirAll(filepath.Dir(socket), 0700)
+	if err != nil {
+		return nil, err
+	}
+	if conn, e := net.Dial("unix", socket); e == nil {
+		conn.Close() //nolint
+		return nil, fmt.Errorf("an agent is already running on %s", socket)
+	}
+	// a socket nobody listens on is left over from an agent that did not shut down
+	err = os.Remove(socket)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return listenPrivate(socket)
+}
+
+// Serve answers requests until the listener is closed.
+func (agent *Agent) Serve(listener net.Listener) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				agent.expire()
+			case <-done:
+				return
+			}
+		}
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			agent.lock()
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close() //nolint
+			_ = conn.SetDeadline(time.Now().Add(30 * time.Second))
+			var request agentRequest
+			err := json.NewDecoder(conn).Decode(&request)
+			if err != nil {
+				return
+			}
+			_ = json.NewEncoder(conn).Encode(agent.handle(&request))
+		}()
+	}
+}
+
+func (agent *Agent) handle(request *agentRequest) *agentResponse {
+	switch request.Op {
+	case "unlock":
+		key, err := crypto.NewKeyFromArmored(request.Key)
+		if err != nil {
+			return &agentResponse{Error: err.Error()}
+		}
+		unlocked, err := key.Unlock([]byte(request.Passphrase))
+		if err != nil {
+			return &agentResponse{Error: "wrong passphrase"}
+		}
+		keyRing, err := crypto.NewKeyRing(unlocked)
+		if err != nil {
+			return &agentResponse{Error: err.Error()}
+		}
+		agent.mu.Lock()
+		defer agent.mu.Unlock()
+		agent.keys[key.GetFingerprint()] = &agentKey{keyRing: keyRing, expires: agent.now().Add(agent.ttl)}
+		return &agentResponse{}
+	case "decrypt":
+		agent.mu.Lock()
+		defer agent.mu.Unlock()
+		key, found := agent.keys[request.Fingerprint]
+		if !found || agent.now().After(key.expires) {
+			return &agentResponse{Error: "locked"}
+		}
+		message, err := crypto.NewPGPMessageFromArmored(request.Message)
+		if err != nil {
+			return &agentResponse{Error: err.Error()}
+		}
+		plain, err := key.keyRing.Decrypt(message, nil, 0)
+		if err != nil {
+			return &agentResponse{Error: err.Error()}
+		}
+		key.expires = agent.now().Add(agent.ttl)
+		return &agentResponse{Plaintext: plain.GetString()}
+	case "lock":
+		agent.lock()
+		return &agentResponse{}
+	}
+	return &agentResponse{Error: fmt.Sprintf("unknown operation '%s'", request.Op)}
+}
+
+func (agent *Agent) expire() {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	for fingerprint, key := range agent.keys {
+		if agent.now().After(key.expires) {
+			key.keyRing.ClearPrivateParams()
+			delete(agent.keys, fingerprint)
+		}
+	}
+}
+
+func (agent *Agent) lock() {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	for fingerprint, key := range agent.keys {
+		key.keyRing.ClearPrivateParams()
+		delete(agent.keys, fingerprint)
+	}
+}
+
+// LockAgent makes a running agent forget all keys.
+func LockAgent() error {
+	response, err := callAgent(&agentRequest{Op: "lock"})
+	if err != nil {
+		return err
+	}
+	if response.Error != "" {
+		return fmt.Errorf("agent: %s", response.Error)
+	}
+	return nil
+}
+
+// agentDecrypt decrypts a message with the agent, if one is running and has the key unlocked.
+func agentDecrypt(fingerprint, message string) (string, bool) {
+	response, err := callAgent(&agentRequest{Op: "decrypt", Fingerprint: fingerprint, Message: message})
+	if err != nil || response.Error != "" {
+		return "", false
+	}
+	return response.Plaintext, true
+}
+
+// agentUnlock hands the passphrase to a running agent, so that later runs don't need to ask for it.
+func agentUnlock(armoredKey, passphrase string) {
+	_, _ = callAgent(&agentRequest{Op: "unlock", Key: armoredKey, Passphrase: passphrase})
+}
+
+func callAgent(request *agentRequest) (*agentResponse, error) {
+	socket, err := AgentSocket()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("unix", socket, time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("no agent is running on %s: %w", socket, err)
+	}
+	defer conn.Close() //nolint
+	_ = conn.SetDeadline(time.Now().Add(30 * time.Second))
+	err = json.NewEncoder(conn).Encode(request)
+	if err != nil {
+		return nil, err
+	}
+	response := &agentResponse{}
+	err = json.NewDecoder(conn).Decode(response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
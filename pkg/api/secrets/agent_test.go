@@ -0,0 +1,91 @@
+package secrets
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v2/helper"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Agent_Forgets_Keys(t *testing.T) {
+	now := time.Now()
+	agent := NewAgent(time.Minute)
+	agent.now = func() time.Time { return now }
+	publicKey, err := johnDoeKey.GetArmoredPublicKey()
+	assert.NoError(t, err)
+	message, err := helper.EncryptMessageArmored(publicKey, "foobar")
+	assert.NoError(t, err)
+	decrypt := &agentRequest{Op: "decrypt", Fingerprint: johnDoeKey.GetFingerprint(), Message: message}
+
+	assert.Equal(t, "locked", agent.handle(decrypt).Error)
+	assert.Equal(t, "wrong passphrase", agent.handle(&agentRequest{Op: "unlock", Key: johnArmored, Passphrase: "wrong"}).Error)
+	assert.Equal(t, "", agent.handle(&agentRequest{Op: "unlock", Key: johnArmored, Passphrase: "LongSecret"}).Error)
+	assert.Equal(t, "foobar", agent.handle(decrypt).Plaintext)
+
+	now = now.Add(50 * time.Second)
+	assert.Equal(t, "foobar", agent.handle(decrypt).Plaintext, "using a key extends its ttl")
+	now = now.Add(61 * time.Second)
+	assert.Equal(t, "locked", agent.handle(decrypt).Error)
+	agent.expire()
+	assert.Len(t, agent.keys, 0)
+
+	assert.Equal(t, "", agent.handle(&agentRequest{Op: "unlock", Key: johnArmored, Passphrase: "LongSecret"}).Error)
+	assert.Equal(t, "", agent.handle(&agentRequest{Op: "lock"}).Error)
+	assert.Equal(t, "locked", agent.handle(decrypt).Error)
+}
+
+func Test_Agent_Caches_Passphrase(t *testing.T) {
+	socketDir, err := ioutil.TempDir("", "xlrte")
+	assert.NoError(t, err)
+	envName := RandStringBytes()
+	assert.NoError(t, os.MkdirAll(filepath.Join("testdata", "environments", envName, "secrets"), 0750))
+	defer func() {
+		assert.NoError(t, os.RemoveAll(socketDir))
+		assert.NoError(t, os.RemoveAll(filepath.Join("testdata", "environments", envName)))
+		assert.NoError(t, os.Setenv("XLRTE_AGENT_SOCK", ""))
+		assert.NoError(t, os.Setenv("XLRTE_PASSPHRASE", ""))
+	}()
+	socket := filepath.Join(socketDir, "agent.sock")
+	assert.NoError(t, os.Setenv("XLRTE_AGENT_SOCK", socket))
+	listener, err := Listen(socket)
+	assert.NoError(t, err)
+	served := make(chan error)
+	go func() {
+		served <- NewAgent(time.Minute).Serve(listener)
+	}()
+	info, err := os.Stat(socket)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	_, err = Listen(socket)
+	assert.Error(t, err, "only one agent may run on a socket")
+
+	assert.NoError(t, writePubKey("testdata", envName, johnDoeKey))
+	assert.NoError(t, WriteSecret("testdata", envName, "FOO", "foobar"))
+	assert.NoError(t, os.Setenv("XLRTE_PASSPHRASE", "LongSecret"))
+	delete(agentUnlocked, johnDoeKey.GetFingerprint())
+	value, err := getSecretPrivate(johnArmored, "testdata", envName, "FOO")
+	assert.NoError(t, err)
+	assert.Equal(t, "foobar", value)
+
+	// the agent decrypts without the passphrase, which would otherwise be prompted for
+	assert.NoError(t, os.Setenv("XLRTE_PASSPHRASE", ""))
+	data, err := ioutil.ReadFile(secretPath("testdata", envName, "FOO"))
+	assert.NoError(t, err)
+	value, ok := agentDecrypt(johnDoeKey.GetFingerprint(), string(data))
+	assert.True(t, ok)
+	assert.Equal(t, "foobar", value)
+	value, err = getSecretPrivate(johnArmored, "testdata", envName, "FOO")
+	assert.NoError(t, err)
+	assert.Equal(t, "foobar", value)
+
+	assert.NoError(t, LockAgent())
+	_, ok = agentDecrypt(johnDoeKey.GetFingerprint(), string(data))
+	assert.False(t, ok)
+
+	assert.NoError(t, listener.Close())
+	assert.NoError(t, <-served)
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package secrets
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenPrivate creates the socket with permissions that only let the current user connect. The umask
+// restricts it as it is created, a chmod afterwards would leave a moment in which other users can connect.
+func listenPrivate(socket string) (net.Listener, error) {
+	umask := syscall.Umask(0177)
+	defer syscall.Umask(umask)
+	return net.Listen("unix", socket)
+}
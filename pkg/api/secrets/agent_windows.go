@@ -0,0 +1,8 @@
+package secrets
+
+import "net"
+
+// listenPrivate creates the socket, which inherits the ACL of its directory, private to the user in the home directory.
+func listenPrivate(socket string) (net.Listener, error) {
+	return net.Listen("unix", socket)
+}
@@ -0,0 +1,172 @@
+package secrets
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const lowerBytes = "abcdefghijklmnopqrstuvwxyz"
+const upperBytes = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+const symbolBytes = "!#$%()*+,-.:;<=>?@[]^_{|}~"
+
+// Charsets are the character sets of generated strings and passwords. Symbols exclude quotes,
+// `&`, `/` and `\`, which tend to need escaping in connection strings and shells.
+var Charsets = map[string]string{
+	"alphanumeric": lowerBytes + upperBytes + intBytes,
+	"url":          letterBytes,
+	"hex":          "0123456789abcdef",
+	"numeric":      intBytes,
+	"ascii":        lowerBytes + upperBytes + intBytes + symbolBytes,
+}
+
+// RandomString returns a string of length characters picked from charset with crypto/rand.
+func RandomString(length int, charset string) (string, error) {
+	if length <= 0 || charset == "" {
+		return "", fmt.Errorf("random strings need a positive length and a charset")
+	}
+	max := big.NewInt(int64(len(charset)))
+	b := make([]byte, length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = charset[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// Password returns a random string of the named charset that contains a lower case letter, an upper case
+// letter, a digit and a symbol, for each of those the charset includes, as database password policies require.
+func Password(length int, charsetName string) (string, error) {
+	charset, found := Charsets[charsetName]
+	if !found {
+		return "", fmt.Errorf("unknown charset '%s'", charsetName)
+	}
+	classes := []string{}
+	for _, class := range []string{lowerBytes, upperBytes, intBytes, symbolBytes} {
+		if strings.ContainsAny(charset, class) {
+			classes = append(classes, class)
+		}
+	}
+	if length < len(classes) {
+		return "", fmt.Errorf("passwords of charset %s need at least %d characters", charsetName, len(classes))
+	}
+	for {
+		password, err := RandomString(length, charset)
+		if err != nil {
+			return "", err
+		}
+		complete := true
+		for _, class := range classes {
+			if !strings.ContainsAny(password, class) {
+				complete = false
+				break
+			}
+		}
+		if complete {
+			return password, nil
+		}
+	}
+}
+
+// UUID returns a random (version 4) UUID.
+func UUID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// HMACKey returns a base64 encoded random key of size bytes.
+func HMACKey(size int) (string, error) {
+	b := make([]byte, size)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// RSAKeyPair returns the PKCS #8 private key and PKIX public key of a new RSA key of bits size, PEM encoded.
+func RSAKeyPair(bits int) (string, string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return "", "", err
+	}
+	return encodeKeyPair(key, &key.PublicKey)
+}
+
+// Ed25519KeyPair returns the PKCS #8 private key and PKIX public key of a new Ed25519 key, PEM encoded.
+func Ed25519KeyPair() (string, string, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return encodeKeyPair(private, public)
+}
+
+// SelfSignedCertificate returns the PEM encoded private key and a certificate for hosts, valid for validDays.
+// The first host is the common name of the certificate.
+func SelfSignedCertificate(hosts []string, validDays int) (string, string, error) {
+	if len(hosts) == 0 {
+		return "", "", fmt.Errorf("certificates need at least one host")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hosts[0]},
+		DNSNames:              hosts,
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(0, 0, validDays),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", err
+	}
+	privateKey, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateKey})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), nil
+}
+
+func encodeKeyPair(private, public interface{}) (string, string, error) {
+	privateKey, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", "", err
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateKey})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})), nil
+}
@@ -0,0 +1,48 @@
+package secrets
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Password_Contains_All_Classes(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		password, err := Password(8, "ascii")
+		assert.NoError(t, err)
+		assert.Len(t, password, 8)
+		for _, class := range []string{lowerBytes, upperBytes, intBytes, symbolBytes} {
+			assert.True(t, strings.ContainsAny(password, class), password)
+		}
+	}
+	password, err := Password(12, "hex")
+	assert.NoError(t, err)
+	assert.Regexp(t, `^[0-9a-f]{12}$`, password)
+
+	_, err = Password(3, "ascii")
+	assert.Error(t, err)
+	_, err = Password(12, "emoji")
+	assert.Error(t, err)
+}
+
+func Test_Key_Pairs(t *testing.T) {
+	private, public, err := RSAKeyPair(2048)
+	assert.NoError(t, err)
+	block, _ := pem.Decode([]byte(private))
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	assert.NoError(t, err)
+	assert.NotNil(t, key)
+	block, _ = pem.Decode([]byte(public))
+	_, err = x509.ParsePKIXPublicKey(block.Bytes)
+	assert.NoError(t, err)
+
+	key1, err := HMACKey(32)
+	assert.NoError(t, err)
+	key2, err := HMACKey(32)
+	assert.NoError(t, err)
+	assert.Len(t, key1, 44)
+	assert.NotEqual(t, key1, key2)
+}
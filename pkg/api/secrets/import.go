@@ -0,0 +1,95 @@
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ImportSecrets encrypts every entry of a dotenv file, or of a YAML map for files ending in .yaml or .yml,
+// as a secret of the environment. Nothing is written if a secret exists already, unless force is set.
+func ImportSecrets(baseDir, env, file string, force bool) ([]string, error) {
+	data, err := ioutil.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	var entries map[string]string
+	if strings.HasSuffix(file, ".yaml") || strings.HasSuffix(file, ".yml") {
+		err = yaml.Unmarshal(data, &entries)
+	} else {
+		entries, err = parseDotEnv(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	store, err := OpenStore("", baseDir, env)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := secretNames(store)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	secrets := []*Secret{}
+	for _, name := range names {
+		if !validName.MatchString(name) {
+			return nil, fmt.Errorf("%s: invalid secret name '%s', names may only contain letters, digits, '-' and '_'", file, name)
+		}
+		if existing[name] && !force {
+			return nil, fmt.Errorf("the secret %s/%s already exists, use --force to overwrite it", env, name)
+		}
+		secrets = append(secrets, &Secret{Name: name, Value: entries[name]})
+	}
+	return names, store.Write(secrets...)
+}
+
+// parseDotEnv reads `KEY=value` lines. Blank lines, comments and an `export ` prefix are ignored,
+// double quoted values are unescaped and single quoted values are taken literally.
+func parseDotEnv(data []byte) (map[string]string, error) {
+	entries := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text = strings.TrimPrefix(text, "export ")
+		kv := strings.SplitN(text, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("line %d: expected KEY=value", line)
+		}
+		key := strings.TrimSpace(kv[0])
+		value := strings.TrimSpace(kv[1])
+		switch {
+		case len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`):
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			value = unquoted
+		case len(value) >= 2 && strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'"):
+			value = value[1 : len(value)-1]
+		default:
+			if comment := strings.Index(value, " #"); comment >= 0 {
+				value = strings.TrimSpace(value[:comment])
+			}
+		}
+		entries[key] = value
+	}
+	return entries, scanner.Err()
+}
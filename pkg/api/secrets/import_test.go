@@ -0,0 +1,59 @@
+package secrets
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Parse_DotEnv(t *testing.T) {
+	entries, err := parseDotEnv([]byte(`
+# comment
+API_KEY=abc123
+export TOKEN = "multi\nline"
+LITERAL='a "quoted" $value'
+TRAILING=value # comment
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"API_KEY":  "abc123",
+		"TOKEN":    "multi\nline",
+		"LITERAL":  `a "quoted" $value`,
+		"TRAILING": "value",
+	}, entries)
+
+	_, err = parseDotEnv([]byte("NOT_AN_ENTRY"))
+	assert.EqualError(t, err, "line 1: expected KEY=value")
+}
+
+func Test_Import_And_Set_Secrets(t *testing.T) {
+	err := os.Setenv("XLRTE_PASSPHRASE", "LongSecret")
+	assert.NoError(t, err)
+	envName := RandStringBytes()
+	assert.NoError(t, os.MkdirAll(filepath.Join("testdata", "environments", envName, "secrets"), 0750))
+	defer func() {
+		assert.NoError(t, os.RemoveAll(filepath.Join("testdata", "environments", envName)))
+		assert.NoError(t, os.Setenv("XLRTE_PASSPHRASE", ""))
+	}()
+	assert.NoError(t, writePubKey("testdata", envName, johnDoeKey))
+
+	importFile := filepath.Join("testdata", "environments", envName, "import.yaml")
+	assert.NoError(t, ioutil.WriteFile(importFile, []byte("API_KEY: abc\nTOKEN: def\n"), 0600))
+	names, err := ImportSecrets("testdata", envName, importFile, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"API_KEY", "TOKEN"}, names)
+
+	_, err = ImportSecrets("testdata", envName, importFile, false)
+	assert.EqualError(t, err, "the secret "+envName+"/API_KEY already exists, use --force to overwrite it")
+
+	assert.Error(t, SetSecret("testdata", envName, "TOKEN", "ghi", false))
+	assert.NoError(t, SetSecret("testdata", envName, "TOKEN", "ghi", true))
+	assert.Error(t, SetSecret("testdata", envName, "not/valid", "ghi", true))
+
+	secrets, err := getAllSecretsPrivate(johnArmored, "testdata", envName)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Secret{{Name: "API_KEY", Value: "abc"}, {Name: "TOKEN", Value: "ghi"}}, secrets)
+}
@@ -0,0 +1,228 @@
+package secrets
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+)
+
+// KeyInfo describes a public key of an environment.
+type KeyInfo struct {
+	Identity    string
+	Fingerprint string
+	Expires     time.Time // zero if the key does not expire
+	CanEncrypt  bool
+	Secrets     int // secrets encrypted to the key, fewer than all secrets until `secret refresh` has run
+}
+
+func revokedFile(baseDir, env string) string {
+	return filepath.Join(baseDir, "environments", env, "pubkeys", "revoked")
+}
+
+// ListKeys returns the public keys of an environment with the number of secrets encrypted to each of them.
+func ListKeys(baseDir, env string) ([]*KeyInfo, error) {
+	if err := requireBackend(baseDir, env, "pgp"); err != nil {
+		return nil, err
+	}
+	keys, err := getPublicKeys(baseDir, env)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := listPGPSecrets(baseDir, env)
+	if err != nil {
+		return nil, err
+	}
+	infos := []*KeyInfo{}
+	for _, key := range keys {
+		info := toKeyInfo(key)
+		for _, secret := range secrets {
+			for _, recipient := range secret.Recipients {
+				if recipient == info.Identity {
+					info.Secrets++
+					break
+				}
+			}
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Identity < infos[j].Identity })
+	return infos, nil
+}
+
+// StaleSecrets returns the secrets that are still encrypted to keys which are no longer part of the environment,
+// such as revoked keys, and which `secret refresh` re-encrypts.
+func StaleSecrets(baseDir, env string) ([]string, error) {
+	if err := requireBackend(baseDir, env, "pgp"); err != nil {
+		return nil, err
+	}
+	keys, err := getPublicKeys(baseDir, env)
+	if err != nil {
+		return nil, err
+	}
+	identities := make(map[string]bool)
+	for _, key := range keys {
+		identities[strings.TrimSuffix(identityStr(key), ".asc")] = true
+	}
+	secrets, err := listPGPSecrets(baseDir, env)
+	if err != nil {
+		return nil, err
+	}
+	stale := []string{}
+	for _, secret := range secrets {
+		for _, recipient := range secret.Recipients {
+			if !identities[recipient] {
+				stale = append(stale, secret.Name)
+				break
+			}
+		}
+	}
+	return stale, nil
+}
+
+// AddKey adds the public key in file to an environment. Existing secrets are only encrypted to it
+// once someone with access runs `secret refresh`.
+func AddKey(baseDir, env, file string) (*KeyInfo, error) {
+	if err := requireBackend(baseDir, env, "pgp"); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	key, err := crypto.NewKeyFromArmored(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if key.IsPrivate() {
+		return nil, fmt.Errorf("%s is a private key, add the public key instead", file)
+	}
+	if !key.CanEncrypt() {
+		return nil, fmt.Errorf("key %s can not encrypt, it may be expired, revoked or lack an encryption subkey", key.GetFingerprint())
+	}
+	revoked, err := revokedFingerprints(baseDir, env)
+	if err != nil {
+		return nil, err
+	}
+	if revoked[key.GetFingerprint()] {
+		return nil, fmt.Errorf("key %s has been revoked for environment %s", key.GetFingerprint(), env)
+	}
+	err = writePubKey(baseDir, env, key)
+	if err != nil {
+		return nil, err
+	}
+	return toKeyInfo(key), nil
+}
+
+// RevokeKey removes the key with the given fingerprint, or its unique suffix of at least 16 characters, from an
+// environment and re-encrypts all secrets to the remaining keys. It only returns without error once no secret
+// is encrypted to the revoked key anymore. The values were readable by the key holder, so they should be rotated.
+func RevokeKey(homeDir, baseDir, env, fingerprint string) (*KeyInfo, error) {
+	if err := requireBackend(baseDir, env, "pgp"); err != nil {
+		return nil, err
+	}
+	_, armoredKey, err := GetPrivateKey(homeDir)
+	if err != nil {
+		return nil, err
+	}
+	return revokeKeyPrivate(armoredKey, baseDir, env, fingerprint)
+}
+
+func revokeKeyPrivate(armoredKey, baseDir, env, fingerprint string) (*KeyInfo, error) {
+	fingerprint = strings.ToLower(strings.ReplaceAll(fingerprint, " ", ""))
+	if len(fingerprint) < 16 {
+		return nil, fmt.Errorf("give at least the last 16 characters of the fingerprint")
+	}
+	keys, err := getPublicKeys(baseDir, env)
+	if err != nil {
+		return nil, err
+	}
+	var revoked *crypto.Key
+	for _, key := range keys {
+		if strings.HasSuffix(key.GetFingerprint(), fingerprint) {
+			if revoked != nil {
+				return nil, fmt.Errorf("fingerprint %s matches more than one key", fingerprint)
+			}
+			revoked = key
+		}
+	}
+	if revoked == nil {
+		return nil, fmt.Errorf("no key with fingerprint %s in environment %s", fingerprint, env)
+	}
+	if len(keys) == 1 {
+		return nil, fmt.Errorf("can not revoke the last key of environment %s, no one could decrypt its secrets", env)
+	}
+	// decrypt first, so that nothing changes if this key can not read all secrets
+	secrets, err := getAllSecretsPrivate(armoredKey, baseDir, env)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(revokedFile(baseDir, env), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	_, err = fmt.Fprintln(f, revoked.GetFingerprint())
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = os.Remove(filepath.Join(baseDir, "environments", env, "pubkeys", identityStr(revoked)))
+	if err != nil {
+		return nil, err
+	}
+	for _, secret := range secrets {
+		err = writePGPSecret(baseDir, env, secret.Name, secret.Value)
+		if err != nil {
+			return nil, fmt.Errorf("re-encrypting %s failed, run `xlrte secret refresh -e %s`: %w", secret.Name, env, err)
+		}
+	}
+	stale, err := StaleSecrets(baseDir, env)
+	if err != nil {
+		return nil, err
+	}
+	if len(stale) > 0 {
+		return nil, fmt.Errorf("secrets %s are still encrypted to removed keys, run `xlrte secret refresh -e %s`", strings.Join(stale, ", "), env)
+	}
+	return toKeyInfo(revoked), nil
+}
+
+func revokedFingerprints(baseDir, env string) (map[string]bool, error) {
+	revoked := make(map[string]bool)
+	data, err := ioutil.ReadFile(filepath.Clean(revokedFile(baseDir, env)))
+	if os.IsNotExist(err) {
+		return revoked, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			revoked[line] = true
+		}
+	}
+	return revoked, nil
+}
+
+func toKeyInfo(key *crypto.Key) *KeyInfo {
+	info := &KeyInfo{
+		Identity:    strings.TrimSuffix(identityStr(key), ".asc"),
+		Fingerprint: key.GetFingerprint(),
+		CanEncrypt:  key.CanEncrypt(),
+	}
+	entity := key.GetEntity()
+	if identity := entity.PrimaryIdentity(); identity != nil && identity.SelfSignature != nil {
+		lifetime := identity.SelfSignature.KeyLifetimeSecs
+		if lifetime != nil && *lifetime > 0 {
+			info.Expires = entity.PrimaryKey.CreationTime.Add(time.Duration(*lifetime) * time.Second)
+		}
+	}
+	return info
+}
@@ -0,0 +1,64 @@
+package secrets
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Add_List_Revoke_Keys(t *testing.T) {
+	err := os.Setenv("XLRTE_PASSPHRASE", "LongSecret")
+	assert.NoError(t, err)
+	envName := RandStringBytes()
+	envDir := filepath.Join("testdata", "environments", envName)
+	assert.NoError(t, os.MkdirAll(filepath.Join(envDir, "secrets"), 0750))
+	defer func() {
+		assert.NoError(t, os.RemoveAll(envDir))
+		assert.NoError(t, os.Setenv("XLRTE_PASSPHRASE", ""))
+	}()
+	assert.NoError(t, writePubKey("testdata", envName, johnDoeKey))
+	assert.NoError(t, WriteSecret("testdata", envName, "FOO", "foobar"))
+
+	janePublic, err := janeDoeKey.GetArmoredPublicKey()
+	assert.NoError(t, err)
+	janeFile := filepath.Join(envDir, "jane.pub")
+	assert.NoError(t, ioutil.WriteFile(janeFile, []byte(janePublic), 0600))
+	info, err := AddKey("testdata", envName, janeFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "jane-doe-jane@doe.com", info.Identity)
+	assert.True(t, info.CanEncrypt)
+	assert.True(t, info.Expires.IsZero())
+
+	assert.NoError(t, ioutil.WriteFile(janeFile, []byte(janeArmored), 0600))
+	_, err = AddKey("testdata", envName, janeFile)
+	assert.Error(t, err, "private keys are refused")
+
+	keys, err := ListKeys("testdata", envName)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2)
+	assert.Equal(t, 0, keys[0].Secrets, "jane's key is added after FOO was encrypted")
+	assert.Equal(t, 1, keys[1].Secrets)
+
+	assert.NoError(t, refreshPrivate(johnArmored, "testdata", envName))
+	_, err = revokeKeyPrivate(johnArmored, "testdata", envName, "0000000000000000")
+	assert.Error(t, err)
+	revoked, err := revokeKeyPrivate(johnArmored, "testdata", envName, janeDoeKey.GetFingerprint()[24:])
+	assert.NoError(t, err)
+	assert.Equal(t, janeDoeKey.GetFingerprint(), revoked.Fingerprint)
+
+	_, err = getAllSecretsPrivate(janeArmored, "testdata", envName)
+	assert.Error(t, err)
+	stale, err := StaleSecrets("testdata", envName)
+	assert.NoError(t, err)
+	assert.Empty(t, stale)
+
+	assert.NoError(t, ioutil.WriteFile(janeFile, []byte(janePublic), 0600))
+	_, err = AddKey("testdata", envName, janeFile)
+	assert.EqualError(t, err, "key "+janeDoeKey.GetFingerprint()+" has been revoked for environment "+envName)
+
+	_, err = revokeKeyPrivate(johnArmored, "testdata", envName, johnDoeKey.GetFingerprint())
+	assert.Error(t, err, "the last key can not be revoked")
+}
@@ -0,0 +1,212 @@
+package secrets
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+)
+
+// SecretInfo describes a stored secret without decrypting it.
+type SecretInfo struct {
+	Name       string
+	Recipients []string // public keys of the environment the secret is encrypted to, or key IDs of unknown keys
+	Modified   time.Time
+}
+
+// SecretDiff is a secret that differs between two environments.
+type SecretDiff struct {
+	Name   string
+	Change string // "only in <env>" or "values differ"
+}
+
+// NoSecretsError is returned for environments whose secrets have not been initialised.
+type NoSecretsError struct {
+	Env string
+}
+
+func (e *NoSecretsError) Error() string {
+	return fmt.Sprintf("environment %s has no secrets, have you run `xlrte secret init -e %s`?", e.Env, e.Env)
+}
+
+func secretPath(baseDir, env, secretName string) string {
+	return filepath.Join(baseDir, "environments", env, "secrets", fmt.Sprintf("%s.asc", secretName))
+}
+
+// ListSecrets returns the secrets of an environment, sorted by name.
+func ListSecrets(baseDir, env string) ([]*SecretInfo, error) {
+	store, err := OpenStore("", baseDir, env)
+	if err != nil {
+		return nil, err
+	}
+	return store.List()
+}
+
+func listPGPSecrets(baseDir, env string) ([]*SecretInfo, error) {
+	keys, err := getPublicKeys(baseDir, env)
+	if err != nil {
+		return nil, err
+	}
+	recipients := make(map[uint64]string)
+	for _, key := range keys {
+		name := strings.TrimSuffix(identityStr(key), ".asc")
+		entity := key.GetEntity()
+		recipients[entity.PrimaryKey.KeyId] = name
+		for _, subkey := range entity.Subkeys {
+			recipients[subkey.PublicKey.KeyId] = name
+		}
+	}
+
+	secretsDir := filepath.Join(baseDir, "environments", env, "secrets")
+	if _, err = os.Stat(secretsDir); os.IsNotExist(err) {
+		return nil, &NoSecretsError{Env: env}
+	}
+	infos := []*SecretInfo{}
+	err = filepath.Walk(secretsDir, func(path string, info os.FileInfo, e error) error {
+		if e != nil {
+			return e
+		}
+		if !strings.HasSuffix(path, ".asc") {
+			return nil
+		}
+		data, err := ioutil.ReadFile(filepath.Clean(path))
+		if err != nil {
+			return err
+		}
+		message, err := crypto.NewPGPMessageFromArmored(string(data))
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		secret := &SecretInfo{
+			Name:       strings.TrimSuffix(info.Name(), ".asc"),
+			Recipients: []string{},
+			Modified:   info.ModTime(),
+		}
+		keyIDs, _ := message.GetEncryptionKeyIDs()
+		for _, id := range keyIDs {
+			recipient, found := recipients[id]
+			if !found {
+				recipient = fmt.Sprintf("%016x", id)
+			}
+			secret.Recipients = append(secret.Recipients, recipient)
+		}
+		sort.Strings(secret.Recipients)
+		infos = append(infos, secret)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// GetSecret decrypts a single secret.
+func GetSecret(homeDir, baseDir, env, secretName string) (string, error) {
+	err := checkName(secretName)
+	if err != nil {
+		return "", err
+	}
+	store, err := OpenStore(homeDir, baseDir, env)
+	if err != nil {
+		return "", err
+	}
+	return store.Read(secretName)
+}
+
+func getSecretPrivate(armoredKey, baseDir, env, secretName string) (string, error) {
+	path := secretPath(baseDir, env, secretName)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return "", fmt.Errorf("secret %s/%s does not exist", env, secretName)
+	}
+	return decryptFile(armoredKey, path)
+}
+
+// RemoveSecret deletes a secret. Services still referencing it fail to deploy.
+func RemoveSecret(baseDir, env, secretName string) error {
+	err := checkName(secretName)
+	if err != nil {
+		return err
+	}
+	store, err := OpenStore("", baseDir, env)
+	if err != nil {
+		return err
+	}
+	return store.Remove(secretName)
+}
+
+// DiffSecrets compares the secret names of two environments. With compareValues it also decrypts the secrets
+// both environments have and reports those whose values differ, which needs access to both environments.
+// The environments may use different backends.
+func DiffSecrets(homeDir, baseDir, envA, envB string, compareValues bool) ([]*SecretDiff, error) {
+	storeA, err := OpenStore(homeDir, baseDir, envA)
+	if err != nil {
+		return nil, err
+	}
+	storeB, err := OpenStore(homeDir, baseDir, envB)
+	if err != nil {
+		return nil, err
+	}
+	return diffSecrets(storeA, storeB, envA, envB, compareValues)
+}
+
+func diffSecrets(storeA, storeB SecretStore, envA, envB string, compareValues bool) ([]*SecretDiff, error) {
+	namesA, err := secretNames(storeA)
+	if err != nil {
+		return nil, err
+	}
+	namesB, err := secretNames(storeB)
+	if err != nil {
+		return nil, err
+	}
+	all := []string{}
+	for name := range namesA {
+		all = append(all, name)
+	}
+	for name := range namesB {
+		if !namesA[name] {
+			all = append(all, name)
+		}
+	}
+	sort.Strings(all)
+
+	diffs := []*SecretDiff{}
+	for _, name := range all {
+		switch {
+		case !namesB[name]:
+			diffs = append(diffs, &SecretDiff{Name: name, Change: "only in " + envA})
+		case !namesA[name]:
+			diffs = append(diffs, &SecretDiff{Name: name, Change: "only in " + envB})
+		case compareValues:
+			valueA, e := storeA.Read(name)
+			if e != nil {
+				return nil, e
+			}
+			valueB, e := storeB.Read(name)
+			if e != nil {
+				return nil, e
+			}
+			if valueA != valueB {
+				diffs = append(diffs, &SecretDiff{Name: name, Change: "values differ"})
+			}
+		}
+	}
+	return diffs, nil
+}
+
+func secretNames(store SecretStore) (map[string]bool, error) {
+	names := make(map[string]bool)
+	infos, err := listStored(store)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		names[info.Name] = true
+	}
+	return names, nil
+}
@@ -0,0 +1,101 @@
+package secrets
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_List_Get_Remove_Secrets(t *testing.T) {
+	err := os.Setenv("XLRTE_PASSPHRASE", "LongSecret")
+	assert.NoError(t, err)
+	envName := RandStringBytes()
+	err = os.MkdirAll(filepath.Join("testdata", "environments", envName, "secrets"), 0750)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(filepath.Join("testdata", "environments", envName)))
+		assert.NoError(t, os.Setenv("XLRTE_PASSPHRASE", ""))
+	}()
+	assert.NoError(t, writePubKey("testdata", envName, johnDoeKey))
+	assert.NoError(t, writePubKey("testdata", envName, janeDoeKey))
+	assert.NoError(t, WriteSecret("testdata", envName, "FOO", "foobar"))
+	assert.NoError(t, WriteSecret("testdata", envName, "BAR", "bazqux"))
+
+	infos, err := ListSecrets("testdata", envName)
+	assert.NoError(t, err)
+	assert.Len(t, infos, 2)
+	assert.Equal(t, "BAR", infos[0].Name)
+	assert.Equal(t, []string{"jane-doe-jane@doe.com", "john-doe-john@doe.com"}, infos[0].Recipients)
+	assert.False(t, infos[0].Modified.IsZero())
+
+	value, err := getSecretPrivate(janeArmored, "testdata", envName, "FOO")
+	assert.NoError(t, err)
+	assert.Equal(t, "foobar", value)
+	_, err = getSecretPrivate(janeArmored, "testdata", envName, "BAZ")
+	assert.EqualError(t, err, "secret "+envName+"/BAZ does not exist")
+
+	assert.NoError(t, RemoveSecret("testdata", envName, "FOO"))
+	assert.Error(t, RemoveSecret("testdata", envName, "FOO"))
+	infos, err = ListSecrets("testdata", envName)
+	assert.NoError(t, err)
+	assert.Len(t, infos, 1)
+}
+
+func Test_Get_Remove_Reject_Invalid_Names(t *testing.T) {
+	envName := RandStringBytes()
+	err := os.MkdirAll(filepath.Join("testdata", "environments", envName, "secrets"), 0750)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(filepath.Join("testdata", "environments", envName)))
+	}()
+	assert.NoError(t, writePubKey("testdata", envName, johnDoeKey))
+	outside := filepath.Join("testdata", "environments", envName, "outside.asc")
+	assert.NoError(t, ioutil.WriteFile(outside, []byte("not a secret"), 0600))
+	name := filepath.Join("..", "outside")
+
+	_, err = GetSecret("", "testdata", envName, name)
+	assert.EqualError(t, err, "invalid secret name '"+name+"', names may only contain letters, digits, '-' and '_'")
+	err = RemoveSecret("testdata", envName, name)
+	assert.EqualError(t, err, "invalid secret name '"+name+"', names may only contain letters, digits, '-' and '_'")
+	_, err = os.Stat(outside)
+	assert.NoError(t, err)
+}
+
+func Test_Diff_Secrets(t *testing.T) {
+	err := os.Setenv("XLRTE_PASSPHRASE", "LongSecret")
+	assert.NoError(t, err)
+	envA := RandStringBytes()
+	envB := RandStringBytes()
+	defer func() {
+		assert.NoError(t, os.RemoveAll(filepath.Join("testdata", "environments", envA)))
+		assert.NoError(t, os.RemoveAll(filepath.Join("testdata", "environments", envB)))
+		assert.NoError(t, os.Setenv("XLRTE_PASSPHRASE", ""))
+	}()
+	for _, env := range []string{envA, envB} {
+		assert.NoError(t, os.MkdirAll(filepath.Join("testdata", "environments", env, "secrets"), 0750))
+		assert.NoError(t, writePubKey("testdata", env, johnDoeKey))
+		assert.NoError(t, WriteSecret("testdata", env, "SAME", "value"))
+	}
+	assert.NoError(t, WriteSecret("testdata", envA, "CHANGED", "a"))
+	assert.NoError(t, WriteSecret("testdata", envB, "CHANGED", "b"))
+	assert.NoError(t, WriteSecret("testdata", envA, "ONLY_A", "a"))
+	assert.NoError(t, WriteSecret("testdata", envB, "ONLY_B", "b"))
+
+	diffs, err := diffSecrets(newPGPStore("", "testdata", envA), newPGPStore("", "testdata", envB), envA, envB, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []*SecretDiff{
+		{Name: "ONLY_A", Change: "only in " + envA},
+		{Name: "ONLY_B", Change: "only in " + envB},
+	}, diffs)
+
+	diffs, err = diffSecrets(newPGPStore(johnArmored, "testdata", envA), newPGPStore(johnArmored, "testdata", envB), envA, envB, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []*SecretDiff{
+		{Name: "CHANGED", Change: "values differ"},
+		{Name: "ONLY_A", Change: "only in " + envA},
+		{Name: "ONLY_B", Change: "only in " + envB},
+	}, diffs)
+}
@@ -0,0 +1,74 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+)
+
+// pgpStore keeps every secret in its own armored PGP message under `secrets/<name>.asc`,
+// encrypted to the public keys in `pubkeys`.
+type pgpStore struct {
+	baseDir    string
+	env        string
+	privateKey func() (string, error)
+}
+
+func newPGPStore(armoredKey, baseDir, env string) *pgpStore {
+	return &pgpStore{baseDir: baseDir, env: env, privateKey: func() (string, error) {
+		return armoredKey, nil
+	}}
+}
+
+func (store *pgpStore) List() ([]*SecretInfo, error) {
+	return listPGPSecrets(store.baseDir, store.env)
+}
+
+func (store *pgpStore) Read(name string) (string, error) {
+	armoredKey, err := store.privateKey()
+	if err != nil {
+		return "", err
+	}
+	return getSecretPrivate(armoredKey, store.baseDir, store.env, name)
+}
+
+func (store *pgpStore) ReadMany(names []string) ([]*Secret, error) {
+	armoredKey, err := store.privateKey()
+	if err != nil {
+		return nil, err
+	}
+	secrets := []*Secret{}
+	for _, name := range names {
+		value, err := getSecretPrivate(armoredKey, store.baseDir, store.env, name)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, &Secret{Name: name, Value: value})
+	}
+	return secrets, nil
+}
+
+func (store *pgpStore) ReadAll() ([]*Secret, error) {
+	armoredKey, err := store.privateKey()
+	if err != nil {
+		return nil, err
+	}
+	return getAllSecretsPrivate(armoredKey, store.baseDir, store.env)
+}
+
+func (store *pgpStore) Write(secrets ...*Secret) error {
+	for _, secret := range secrets {
+		err := writePGPSecret(store.baseDir, store.env, secret.Name, secret.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *pgpStore) Remove(name string) error {
+	path := secretPath(store.baseDir, store.env, name)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return fmt.Errorf("secret %s/%s does not exist", store.env, name)
+	}
+	return os.Remove(path)
+}
@@ -1,11 +1,13 @@
 package secrets
 
 import (
+	"crypto/rand"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
+	"math/big"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
@@ -26,37 +28,51 @@ type Secret struct {
 	Value string
 }
 
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
 const intBytes = "0123456789"
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_"
 
+// RandStringBytes returns a random string of 10 to 30 url safe characters.
 func RandStringBytes() string {
-	len := 0
-	for len < 10 || len > 30 {
-		len = rand.Intn(30) //nolint
+	n, err := rand.Int(rand.Reader, big.NewInt(21))
+	if err != nil {
+		panic(err)
 	}
-	return RandStringBytesOfLength(len)
+	return RandStringBytesOfLength(10 + int(n.Int64()))
 }
 
 func RandIntBytes(n int) string {
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = intBytes[rand.Intn(len(intBytes))] //nolint
-	}
-	return string(b)
+	return mustRandomString(n, intBytes)
 }
 
 func RandStringBytesOfLength(n int) string {
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letterBytes[rand.Intn(len(letterBytes))] //nolint
+	return mustRandomString(n, letterBytes)
+}
+
+// mustRandomString panics if crypto/rand fails, as no secret can be generated without a source of randomness.
+func mustRandomString(n int, charset string) string {
+	if n <= 0 {
+		return ""
 	}
-	return string(b)
+	str, err := RandomString(n, charset)
+	if err != nil {
+		panic(err)
+	}
+	return str
 }
 
-func AddSecret(baseDir, env, secretName string) error {
-	path := filepath.Join(baseDir, "environments", env, "secrets", fmt.Sprintf("%s.asc", secretName))
-	_, err := os.Stat(path)
-	if err == nil {
+// AddSecret prompts for the value of a secret and, unless force is set, whether to overwrite an existing one.
+func AddSecret(baseDir, env, secretName string, force bool) error {
+	store, err := OpenStore("", baseDir, env)
+	if err != nil {
+		return err
+	}
+	found, err := exists(store, secretName)
+	if err != nil {
+		return err
+	}
+	if found && !force {
 		overwrite := false
 		prompt := &survey.Confirm{
 			Message: fmt.Sprintf("The secret %s/%s already exists, do you want to overwrite it?", env, secretName),
@@ -69,10 +85,6 @@ func AddSecret(baseDir, env, secretName string) error {
 			fmt.Println("cancelling secret creation")
 			return nil
 		}
-		err = os.Remove(path)
-		if err != nil {
-			return err
-		}
 	}
 	secretValue := ""
 	prompt := &survey.Password{Message: "Please enter the value of the secret:"}
@@ -81,42 +93,69 @@ func AddSecret(baseDir, env, secretName string) error {
 		return err
 	}
 
-	return WriteSecret(baseDir, env, secretName, secretValue)
+	return store.Write(&Secret{Name: secretName, Value: secretValue})
+
+}
+
+// SetSecret encrypts a secret without prompting, as needed in CI. Existing secrets are only overwritten with force.
+func SetSecret(baseDir, env, secretName, secretValue string, force bool) error {
+	err := checkName(secretName)
+	if err != nil {
+		return err
+	}
+	store, err := OpenStore("", baseDir, env)
+	if err != nil {
+		return err
+	}
+	found, err := exists(store, secretName)
+	if err != nil {
+		return err
+	}
+	if found && !force {
+		return fmt.Errorf("the secret %s/%s already exists, use --force to overwrite it", env, secretName)
+	}
+	return store.Write(&Secret{Name: secretName, Value: secretValue})
+}
 
+// checkName rejects invalid secret names, which could address files outside of the secrets of the environment.
+func checkName(secretName string) error {
+	if !validName.MatchString(secretName) {
+		return fmt.Errorf("invalid secret name '%s', names may only contain letters, digits, '-' and '_'", secretName)
+	}
+	return nil
 }
 
 func GetAllSecrets(homeDir, baseDir, env string) ([]*Secret, error) {
-	_, armoredKey, err := GetPrivateKey(homeDir)
+	store, err := OpenStore(homeDir, baseDir, env)
 	if err != nil {
 		return nil, err
 	}
-	return getAllSecretsPrivate(armoredKey, baseDir, env)
+	return store.ReadAll()
 }
 
-func getAllSecretsPrivate(armoredKey, baseDir, env string) ([]*Secret, error) {
-	if os.Getenv("XLRTE_PASSPHRASE") == "" {
-		defer func() {
-			_ = os.Setenv("XLRTE_PASSPHRASE", "")
-		}()
+// GetSecrets decrypts only the named secrets, so the passphrase is only asked for if a deployment needs a secret.
+func GetSecrets(homeDir, baseDir, env string, names []string) ([]*Secret, error) {
+	if len(names) == 0 {
+		return []*Secret{}, nil
 	}
+	store, err := OpenStore(homeDir, baseDir, env)
+	if err != nil {
+		return nil, err
+	}
+	return store.ReadMany(names)
+}
+
+func getAllSecretsPrivate(armoredKey, baseDir, env string) ([]*Secret, error) {
 	pubKeyDir := filepath.Join(baseDir, "environments", env, "secrets")
 	allSecrets := []*Secret{}
 	err := filepath.Walk(pubKeyDir, func(path string, info os.FileInfo, e error) error {
 		if !strings.HasSuffix(path, ".asc") {
 			return nil
 		}
-		data, err := ioutil.ReadFile(filepath.Clean(path))
-		if err != nil {
-			return err
-		}
-		pass, err := getPassphrase()
+		clearText, err := decryptFile(armoredKey, path)
 		if err != nil {
 			return err
 		}
-		clearText, err := helper.DecryptMessageArmored(armoredKey, []byte(pass), string(data))
-		if err != nil {
-			return fmt.Errorf("decryption failed, did you enter the correct passphrase? %w", err)
-		}
 		_, file := filepath.Split(path)
 		allSecrets = append(allSecrets, &Secret{
 			Name:  strings.TrimSuffix(file, ".asc"),
@@ -130,36 +169,58 @@ func getAllSecretsPrivate(armoredKey, baseDir, env string) ([]*Secret, error) {
 	return allSecrets, nil
 }
 
+func decryptFile(armoredKey, path string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return "", err
+	}
+	key, err := crypto.NewKeyFromArmored(armoredKey)
+	if err != nil {
+		return "", err
+	}
+	if clearText, ok := agentDecrypt(key.GetFingerprint(), string(data)); ok {
+		return clearText, nil
+	}
+	pass, err := getPassphrase()
+	if err != nil {
+		return "", err
+	}
+	clearText, err := helper.DecryptMessageArmored(armoredKey, []byte(pass), string(data))
+	if err != nil {
+		passphrase = ""
+		return "", fmt.Errorf("decryption failed, did you enter the correct passphrase? %w", err)
+	}
+	if !agentUnlocked[key.GetFingerprint()] {
+		agentUnlock(armoredKey, pass)
+		agentUnlocked[key.GetFingerprint()] = true
+	}
+	return clearText, nil
+}
+
+// Refresh re-encrypts all secrets to the current recipients of the environment.
 func Refresh(homeDir, baseDir, env string) error {
-	_, armoredKey, err := GetPrivateKey(homeDir)
+	store, err := OpenStore(homeDir, baseDir, env)
 	if err != nil {
 		return err
 	}
-	return refreshPrivate(armoredKey, baseDir, env)
+	return refresh(store)
 }
 
 func refreshPrivate(armoredKey, baseDir, env string) error {
-	secrets, err := getAllSecretsPrivate(armoredKey, baseDir, env)
+	return refresh(newPGPStore(armoredKey, baseDir, env))
+}
+
+// WriteSecret encrypts a secret to the recipients of the environment.
+func WriteSecret(baseDir, env, secretName, secretValue string) error {
+	store, err := OpenStore("", baseDir, env)
 	if err != nil {
 		return err
 	}
-	for _, secret := range secrets {
-		path := filepath.Join(baseDir, "environments", env, "secrets", fmt.Sprintf("%s.asc", secret.Name))
-		err = os.Remove(path)
-		if err != nil {
-			return err
-		}
-		err = WriteSecret(baseDir, env, secret.Name, secret.Value)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	return store.Write(&Secret{Name: secretName, Value: secretValue})
 }
 
-func WriteSecret(baseDir, env, secretName, secretValue string) error {
-	path := filepath.Join(baseDir, "environments", env, "secrets", fmt.Sprintf("%s.asc", secretName))
-
+func writePGPSecret(baseDir, env, secretName, secretValue string) error {
+	path := secretPath(baseDir, env, secretName)
 	keys, err := getPublicKeys(baseDir, env)
 	if err != nil {
 		return err
@@ -209,6 +270,10 @@ func WriteSecret(baseDir, env, secretName, secretValue string) error {
 }
 
 func InitSecrets(homeDir, baseDir, env string) (bool, error) {
+	err := requireBackend(baseDir, env, "pgp")
+	if err != nil {
+		return false, fmt.Errorf("%w, `secret init` creates PGP keys", err)
+	}
 	return initSecretsPrivate(homeDir, baseDir, env, initSettings)
 }
 
@@ -278,7 +343,11 @@ func GetPrivateKey(homeDir string) (*crypto.Key, string, error) {
 func getPublicKeys(baseDir, env string) ([]*crypto.Key, error) {
 	pubKeyDir := filepath.Join(baseDir, "environments", env, "pubkeys")
 	allKeys := []*crypto.Key{}
-	err := filepath.Walk(pubKeyDir, func(path string, info os.FileInfo, err error) error {
+	revoked, err := revokedFingerprints(baseDir, env)
+	if err != nil {
+		return nil, err
+	}
+	err = filepath.Walk(pubKeyDir, func(path string, info os.FileInfo, err error) error {
 		if strings.HasSuffix(path, ".asc") {
 			data, err := ioutil.ReadFile(filepath.Clean(path))
 			if err != nil {
@@ -288,6 +357,9 @@ func getPublicKeys(baseDir, env string) ([]*crypto.Key, error) {
 			if err != nil {
 				return err
 			}
+			if revoked[key.GetFingerprint()] {
+				return fmt.Errorf("the key in %s has been revoked, it must be removed", path)
+			}
 			allKeys = append(allKeys, key)
 		}
 		return nil
@@ -350,20 +422,27 @@ func identityStr(key *crypto.Key) string {
 	return identity + ".asc"
 }
 
+// passphrase is kept for the rest of the process once entered, rather than in the environment,
+// which subprocesses such as terraform inherit.
+var passphrase string
+
+// agentUnlocked are the fingerprints of keys whose passphrase has been handed to the agent, if one is running.
+var agentUnlocked = make(map[string]bool)
+
 func getPassphrase() (string, error) {
 	if os.Getenv("XLRTE_PASSPHRASE") != "" {
 		return os.Getenv("XLRTE_PASSPHRASE"), nil
 	}
+	if passphrase != "" {
+		return passphrase, nil
+	}
 	secretValue := ""
 	prompt := &survey.Password{Message: "Please enter your private key passphrase:"}
 	err := survey.AskOne(prompt, &secretValue)
 	if err != nil {
 		return "", err
 	}
-	err = os.Setenv("XLRTE_PASSPHRASE", secretValue)
-	if err != nil {
-		return "", err
-	}
+	passphrase = secretValue
 	return secretValue, nil
 }
 
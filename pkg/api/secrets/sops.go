@@ -0,0 +1,206 @@
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+type sopsConfig struct {
+	GCPKMS []string `yaml:"gcp_kms"`
+	AWSKMS []string `yaml:"aws_kms"`
+	Age    []string `yaml:"age"`
+	PGP    []string `yaml:"pgp"`
+}
+
+// sopsStore keeps all secrets of an environment in `secrets.sops.yaml`, encrypted by the sops binary with the
+// cloud KMS keys, age recipients or PGP fingerprints of the environment. As it is a single file, writing a
+// secret decrypts the others, so unlike with pgp and age it needs access to the keys.
+type sopsStore struct {
+	baseDir string
+	env     string
+	config  sopsConfig
+	run     func(stdin []byte, args ...string) ([]byte, error)
+}
+
+func runSops(stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command("sops", args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("sops %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func (store *sopsStore) path() string {
+	return filepath.Join(store.baseDir, "environments", store.env, "secrets.sops.yaml")
+}
+
+func (store *sopsStore) List() ([]*SecretInfo, error) {
+	info, err := os.Stat(store.path())
+	if os.IsNotExist(err) {
+		return nil, &NoSecretsError{Env: store.env}
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filepath.Clean(store.path()))
+	if err != nil {
+		return nil, err
+	}
+	// sops only encrypts the values, so the names can be read without access to the keys
+	var encrypted map[string]interface{}
+	err = yaml.Unmarshal(data, &encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", store.path(), err)
+	}
+	recipients := store.recipients()
+	infos := []*SecretInfo{}
+	for name := range encrypted {
+		if name == "sops" {
+			continue
+		}
+		infos = append(infos, &SecretInfo{Name: name, Recipients: recipients, Modified: info.ModTime()})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+func (store *sopsStore) Read(name string) (string, error) {
+	values, err := store.decrypt()
+	if err != nil {
+		return "", err
+	}
+	value, found := values[name]
+	if !found {
+		return "", fmt.Errorf("secret %s/%s does not exist", store.env, name)
+	}
+	return value, nil
+}
+
+func (store *sopsStore) ReadMany(names []string) ([]*Secret, error) {
+	values, err := store.decrypt()
+	if err != nil {
+		return nil, err
+	}
+	secrets := []*Secret{}
+	for _, name := range names {
+		value, found := values[name]
+		if !found {
+			return nil, fmt.Errorf("secret %s/%s does not exist", store.env, name)
+		}
+		secrets = append(secrets, &Secret{Name: name, Value: value})
+	}
+	return secrets, nil
+}
+
+func (store *sopsStore) ReadAll() ([]*Secret, error) {
+	values, err := store.decrypt()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	secrets := []*Secret{}
+	for _, name := range names {
+		secrets = append(secrets, &Secret{Name: name, Value: values[name]})
+	}
+	return secrets, nil
+}
+
+func (store *sopsStore) Write(secrets ...*Secret) error {
+	values, err := store.decrypt()
+	if err != nil {
+		return err
+	}
+	for _, secret := range secrets {
+		values[secret.Name] = secret.Value
+	}
+	return store.encrypt(values)
+}
+
+func (store *sopsStore) Remove(name string) error {
+	values, err := store.decrypt()
+	if err != nil {
+		return err
+	}
+	if _, found := values[name]; !found {
+		return fmt.Errorf("secret %s/%s does not exist", store.env, name)
+	}
+	delete(values, name)
+	return store.encrypt(values)
+}
+
+func (store *sopsStore) recipients() []string {
+	recipients := []string{}
+	recipients = append(recipients, store.config.GCPKMS...)
+	recipients = append(recipients, store.config.AWSKMS...)
+	recipients = append(recipients, store.config.Age...)
+	recipients = append(recipients, store.config.PGP...)
+	sort.Strings(recipients)
+	return recipients
+}
+
+func (store *sopsStore) decrypt() (map[string]string, error) {
+	values := make(map[string]string)
+	if _, err := os.Stat(store.path()); os.IsNotExist(err) {
+		return values, nil
+	}
+	out, err := store.run(nil, "--decrypt", "--input-type", "yaml", "--output-type", "yaml", store.path())
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(out, &values)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", store.path(), err)
+	}
+	return values, nil
+}
+
+func (store *sopsStore) encrypt(values map[string]string) error {
+	args := []string{"--encrypt", "--input-type", "yaml", "--output-type", "yaml"}
+	keys := []struct {
+		flag   string
+		values []string
+	}{
+		{"--gcp-kms", store.config.GCPKMS},
+		{"--kms", store.config.AWSKMS},
+		{"--age", store.config.Age},
+		{"--pgp", store.config.PGP},
+	}
+	for _, key := range keys {
+		if len(key.values) > 0 {
+			args = append(args, key.flag, strings.Join(key.values, ","))
+		}
+	}
+	if len(args) == 5 {
+		return fmt.Errorf("environment %s has no sops keys, add gcp_kms, aws_kms, age or pgp keys to `secrets.sops` in its resources.yaml", store.env)
+	}
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Join(store.baseDir, "environments", store.env), 0700)
+	if err != nil {
+		return err
+	}
+	// the plaintext is piped to sops, so that it is never written to the repository
+	out, err := store.run(data, append(args, "/dev/stdin")...)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(store.path(), out, 0600)
+}
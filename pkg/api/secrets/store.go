@@ -0,0 +1,177 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+// SecretStore encrypts the secrets of one environment. Reading needs the identity of the current user,
+// writing only the recipients of the environment.
+type SecretStore interface {
+	List() ([]*SecretInfo, error)
+	Read(name string) (string, error)
+	// ReadMany decrypts the named secrets, in the order of names, with a single access to the keys.
+	ReadMany(names []string) ([]*Secret, error)
+	ReadAll() ([]*Secret, error)
+	Write(secrets ...*Secret) error
+	Remove(name string) error
+}
+
+// StoreConfig is the `secrets` section of the resources.yaml of an environment, which selects the backend
+// secrets are stored with: `pgp` (the default), `age` or `sops`.
+type StoreConfig struct {
+	Backend string     `yaml:"backend"`
+	Age     ageConfig  `yaml:"age"`
+	Sops    sopsConfig `yaml:"sops"`
+}
+
+func readStoreConfig(baseDir, env string) (*StoreConfig, error) {
+	var resources struct {
+		Secrets StoreConfig `yaml:"secrets"`
+	}
+	file := filepath.Join(baseDir, "environments", env, "resources.yaml")
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		file = filepath.Join(baseDir, "environments", env, "resources.yml")
+	}
+	data, err := ioutil.ReadFile(filepath.Clean(file))
+	if os.IsNotExist(err) {
+		return &StoreConfig{Backend: "pgp"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(data, &resources)
+	if err != nil {
+		return nil, err
+	}
+	if resources.Secrets.Backend == "" {
+		resources.Secrets.Backend = "pgp"
+	}
+	return &resources.Secrets, nil
+}
+
+// OpenStore returns the store of the backend an environment is configured with.
+func OpenStore(homeDir, baseDir, env string) (SecretStore, error) {
+	config, err := readStoreConfig(baseDir, env)
+	if err != nil {
+		return nil, err
+	}
+	return openStore(homeDir, baseDir, env, config.Backend, config)
+}
+
+func openStore(homeDir, baseDir, env, backend string, config *StoreConfig) (SecretStore, error) {
+	switch backend {
+	case "pgp":
+		return &pgpStore{baseDir: baseDir, env: env, privateKey: func() (string, error) {
+			_, armoredKey, err := GetPrivateKey(homeDir)
+			return armoredKey, err
+		}}, nil
+	case "age":
+		return &ageStore{baseDir: baseDir, env: env, recipients: config.Age.Recipients, identity: func() (string, error) {
+			return ageIdentity(homeDir)
+		}}, nil
+	case "sops":
+		return &sopsStore{baseDir: baseDir, env: env, config: config.Sops, run: runSops}, nil
+	}
+	return nil, fmt.Errorf("unknown secrets backend '%s' for environment %s, supported backends are pgp, age and sops", backend, env)
+}
+
+// requireBackend is used by commands that only apply to one backend, such as managing PGP keys.
+func requireBackend(baseDir, env, backend string) error {
+	config, err := readStoreConfig(baseDir, env)
+	if err != nil {
+		return err
+	}
+	if config.Backend != backend {
+		return fmt.Errorf("environment %s stores secrets with %s, not %s", env, config.Backend, backend)
+	}
+	return nil
+}
+
+// MigrateSecrets moves the secrets of an environment from the backend `from` to the backend it is now configured
+// with. The old copies are removed once all secrets have been read back from the new backend.
+func MigrateSecrets(homeDir, baseDir, env, from string) ([]string, error) {
+	config, err := readStoreConfig(baseDir, env)
+	if err != nil {
+		return nil, err
+	}
+	if config.Backend == from {
+		return nil, fmt.Errorf("environment %s already uses %s, set `secrets.backend` in its resources.yaml to the new backend first", env, from)
+	}
+	source, err := openStore(homeDir, baseDir, env, from, config)
+	if err != nil {
+		return nil, err
+	}
+	target, err := openStore(homeDir, baseDir, env, config.Backend, config)
+	if err != nil {
+		return nil, err
+	}
+	return migrate(source, target)
+}
+
+func migrate(source, target SecretStore) ([]string, error) {
+	secrets, err := source.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	err = target.Write(secrets...)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, secret := range secrets {
+		value, e := target.Read(secret.Name)
+		if e != nil {
+			return nil, fmt.Errorf("verifying %s failed, the previous backend has been kept: %w", secret.Name, e)
+		}
+		if value != secret.Value {
+			return nil, fmt.Errorf("verifying %s failed, the previous backend has been kept", secret.Name)
+		}
+		names = append(names, secret.Name)
+	}
+	for _, name := range names {
+		err = source.Remove(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// refresh re-encrypts all secrets to the current recipients of a store.
+func refresh(store SecretStore) error {
+	secrets, err := store.ReadAll()
+	if err != nil {
+		return err
+	}
+	return store.Write(secrets...)
+}
+
+// listStored lists the secrets of a store. Age and sops environments need no `secret init`,
+// so they have no secrets until the first one is written, where pgp environments are not initialised.
+func listStored(store SecretStore) ([]*SecretInfo, error) {
+	infos, err := store.List()
+	var noSecrets *NoSecretsError
+	if _, isPGP := store.(*pgpStore); !isPGP && errors.As(err, &noSecrets) {
+		return []*SecretInfo{}, nil
+	}
+	return infos, err
+}
+
+func exists(store SecretStore, name string) (bool, error) {
+	infos, err := listStored(store)
+	if err != nil {
+		return false, err
+	}
+	for _, info := range infos {
+		if info.Name == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,204 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func Test_Store_Config(t *testing.T) {
+	envName := RandStringBytes()
+	dir := filepath.Join("testdata", "environments", envName)
+	assert.NoError(t, os.MkdirAll(dir, 0750))
+	defer func() {
+		assert.NoError(t, os.RemoveAll(dir))
+	}()
+
+	config, err := readStoreConfig("testdata", envName)
+	assert.NoError(t, err)
+	assert.Equal(t, "pgp", config.Backend)
+
+	resources := "secrets:\n  backend: age\n  age:\n    recipients:\n      - age1abc\ncloudsql:\n  - name: db\n"
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "resources.yaml"), []byte(resources), 0600))
+	config, err = readStoreConfig("testdata", envName)
+	assert.NoError(t, err)
+	assert.Equal(t, "age", config.Backend)
+	assert.Equal(t, []string{"age1abc"}, config.Age.Recipients)
+
+	assert.EqualError(t, requireBackend("testdata", envName, "pgp"), "environment "+envName+" stores secrets with age, not pgp")
+	_, err = openStore("", "testdata", envName, "vault", config)
+	assert.Error(t, err)
+}
+
+func Test_Age_Store(t *testing.T) {
+	envName := RandStringBytes()
+	defer func() {
+		assert.NoError(t, os.RemoveAll(filepath.Join("testdata", "environments", envName)))
+	}()
+	john, err := age.GenerateX25519Identity()
+	assert.NoError(t, err)
+	jane, err := age.GenerateX25519Identity()
+	assert.NoError(t, err)
+	steve, err := age.GenerateX25519Identity()
+	assert.NoError(t, err)
+
+	store := ageStoreFor(envName, john, john.Recipient().String(), jane.Recipient().String())
+	_, err = store.List()
+	assert.EqualError(t, err, (&NoSecretsError{Env: envName}).Error())
+	found, err := exists(store, "FOO")
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.NoError(t, store.Write(&Secret{Name: "FOO", Value: "foobar"}, &Secret{Name: "BAR", Value: "bazqux"}))
+
+	infos, err := store.List()
+	assert.NoError(t, err)
+	assert.Len(t, infos, 2)
+	assert.Equal(t, "BAR", infos[0].Name)
+
+	value, err := ageStoreFor(envName, jane).Read("FOO")
+	assert.NoError(t, err)
+	assert.Equal(t, "foobar", value)
+	_, err = ageStoreFor(envName, steve).Read("FOO")
+	assert.Error(t, err)
+	secrets, err := ageStoreFor(envName, jane).ReadMany([]string{"FOO"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*Secret{{Name: "FOO", Value: "foobar"}}, secrets)
+
+	store = ageStoreFor(envName, john, john.Recipient().String(), steve.Recipient().String())
+	assert.NoError(t, refresh(store))
+	secrets, err = ageStoreFor(envName, steve).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, []*Secret{{Name: "BAR", Value: "bazqux"}, {Name: "FOO", Value: "foobar"}}, secrets)
+
+	assert.NoError(t, store.Remove("FOO"))
+	assert.Error(t, store.Remove("FOO"))
+	assert.Error(t, ageStoreFor(envName, john).Write(&Secret{Name: "FOO", Value: "foobar"}))
+}
+
+func Test_Sops_Store(t *testing.T) {
+	envName := RandStringBytes()
+	defer func() {
+		assert.NoError(t, os.RemoveAll(filepath.Join("testdata", "environments", envName)))
+	}()
+	calls := [][]string{}
+	store := &sopsStore{baseDir: "testdata", env: envName, config: sopsConfig{GCPKMS: []string{"projects/p/locations/global/keyRings/r/cryptoKeys/k"}}, run: func(stdin []byte, args ...string) ([]byte, error) {
+		calls = append(calls, args)
+		return fakeSops(stdin, args)
+	}}
+
+	_, err := store.List()
+	assert.EqualError(t, err, (&NoSecretsError{Env: envName}).Error())
+	assert.NoError(t, store.Write(&Secret{Name: "FOO", Value: "foobar"}))
+	assert.NoError(t, store.Write(&Secret{Name: "BAR", Value: "bazqux"}))
+	assert.Equal(t, "--gcp-kms", calls[0][5])
+
+	data, err := ioutil.ReadFile(store.path())
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "foobar")
+	infos, err := store.List()
+	assert.NoError(t, err)
+	assert.Len(t, infos, 2)
+	assert.Equal(t, "BAR", infos[0].Name)
+	assert.Equal(t, []string{"projects/p/locations/global/keyRings/r/cryptoKeys/k"}, infos[0].Recipients)
+
+	value, err := store.Read("FOO")
+	assert.NoError(t, err)
+	assert.Equal(t, "foobar", value)
+	decrypts := len(calls)
+	secrets, err := store.ReadMany([]string{"FOO", "BAR"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*Secret{{Name: "FOO", Value: "foobar"}, {Name: "BAR", Value: "bazqux"}}, secrets)
+	assert.Len(t, calls, decrypts+1, "secrets are decrypted at once")
+	assert.NoError(t, store.Remove("FOO"))
+	_, err = store.Read("FOO")
+	assert.EqualError(t, err, "secret "+envName+"/FOO does not exist")
+
+	files, err := ioutil.ReadDir(filepath.Join("testdata", "environments", envName))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1, "plaintext is never written")
+	assert.Equal(t, "/dev/stdin", calls[0][len(calls[0])-1])
+
+	store.config = sopsConfig{}
+	assert.Error(t, store.Write(&Secret{Name: "FOO", Value: "foobar"}))
+}
+
+func Test_Migrate_Pgp_To_Age(t *testing.T) {
+	err := os.Setenv("XLRTE_PASSPHRASE", "LongSecret")
+	assert.NoError(t, err)
+	envName := RandStringBytes()
+	assert.NoError(t, os.MkdirAll(filepath.Join("testdata", "environments", envName, "secrets"), 0750))
+	defer func() {
+		assert.NoError(t, os.RemoveAll(filepath.Join("testdata", "environments", envName)))
+		assert.NoError(t, os.Setenv("XLRTE_PASSPHRASE", ""))
+	}()
+	assert.NoError(t, writePubKey("testdata", envName, johnDoeKey))
+	assert.NoError(t, WriteSecret("testdata", envName, "FOO", "foobar"))
+	assert.NoError(t, WriteSecret("testdata", envName, "BAR", "bazqux"))
+
+	identity, err := age.GenerateX25519Identity()
+	assert.NoError(t, err)
+	target := ageStoreFor(envName, identity, identity.Recipient().String())
+	migrated, err := migrate(newPGPStore(johnArmored, "testdata", envName), target)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"BAR", "FOO"}, migrated)
+
+	pgpSecrets, err := listPGPSecrets("testdata", envName)
+	assert.NoError(t, err)
+	assert.Len(t, pgpSecrets, 0)
+	secrets, err := target.ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, []*Secret{{Name: "BAR", Value: "bazqux"}, {Name: "FOO", Value: "foobar"}}, secrets)
+}
+
+func ageStoreFor(env string, identity *age.X25519Identity, recipients ...string) *ageStore {
+	return &ageStore{baseDir: "testdata", env: env, recipients: recipients, identity: func() (string, error) {
+		return identity.String(), nil
+	}}
+}
+
+// fakeSops base64 encodes values and adds a `sops` section, like sops keeps the keys of a file readable.
+func fakeSops(stdin []byte, args []string) ([]byte, error) {
+	data := stdin
+	var err error
+	if args[len(args)-1] != "/dev/stdin" {
+		data, err = ioutil.ReadFile(args[len(args)-1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	values := make(map[string]string)
+	err = yaml.Unmarshal(data, &values)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string)
+	for k, v := range values {
+		switch args[0] {
+		case "--encrypt":
+			out[k] = "ENC[" + base64.StdEncoding.EncodeToString([]byte(v)) + "]"
+		case "--decrypt":
+			if k == "sops" {
+				continue
+			}
+			plain, e := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(v, "ENC["), "]"))
+			if e != nil {
+				return nil, e
+			}
+			out[k] = string(plain)
+		default:
+			return nil, fmt.Errorf("unexpected sops command %s", args[0])
+		}
+	}
+	if args[0] == "--encrypt" {
+		out["sops"] = "metadata"
+	}
+	return yaml.Marshal(out)
+}
@@ -4,9 +4,15 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/xlrte/core/pkg/api"
@@ -44,7 +50,7 @@ func BuildCommand(ctx context.Context) *cobra.Command {
 	}
 
 	rootCmd.AddCommand(versionCommand(), providersCommand(), initProject(ctx),
-		planCommand(ctx), applyCommand(ctx), deleteCommand(ctx), initSecretsCommand())
+		planCommand(ctx), applyCommand(ctx), migrateCommand(ctx), deleteCommand(ctx), initSecretsCommand())
 
 	return rootCmd
 }
@@ -233,6 +239,53 @@ func applyCommand(ctx context.Context) *cobra.Command {
 	return apply
 }
 
+func migrateCommand(ctx context.Context) *cobra.Command {
+	dryRun := false
+	theArgs := runArgs{}
+	migrate := &cobra.Command{
+		Use:   "migrate",
+		Short: "runs pending database migrations",
+		Long:  `creates or updates the databases that have migrations, then runs the pending migrations. Migrations are also run as part of "apply", before services are updated`,
+		Run: func(cmd *cobra.Command, args []string) {
+			input := theArgs.toRunInputs()
+			if dryRun {
+				pending, err := api.PendingMigrations(ctx, input.basePath, input.selector, input.runtimes)
+				if err != nil {
+					checkSecretInit(err, theArgs.environment)
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				if len(pending) == 0 {
+					fmt.Println("No pending migrations")
+					return
+				}
+				resources := []string{}
+				for resource := range pending {
+					resources = append(resources, resource)
+				}
+				sort.Strings(resources)
+				for _, resource := range resources {
+					fmt.Printf("Pending migrations for %s:\n", resource)
+					for _, migration := range pending[resource] {
+						fmt.Println("  - " + migration)
+					}
+				}
+				return
+			}
+			fmt.Println("Migrating from configuration directory: " + theArgs.rootDir)
+			err := api.Execute(ctx, api.Migrate, input.basePath, input.selector, input.runtimes)
+			if err != nil {
+				checkSecretInit(err, theArgs.environment)
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		},
+	}
+	migrate.Flags().BoolVar(&dryRun, "dry-run", false, "Print pending migrations without applying them")
+	addRunTags(migrate, &theArgs)
+	return migrate
+}
+
 func initSecretSystem(rootDir *string, environment string) {
 	if rootDir == nil || *rootDir == "" {
 		*rootDir = ".xlrte/config"
@@ -260,6 +313,14 @@ func initSecretsCommand() *cobra.Command {
 	var rootDir string
 	var environment string
 	var name string
+	var names []string
+	var environments []string
+	var reveal bool
+	var compareValues bool
+	var fromFile, fromEnv, importFile string
+	var fromStdin, force bool
+	var fromBackend string
+	yes := ""
 	command := &cobra.Command{
 		Use:   "secret",
 		Short: "manage secrets & secret config",
@@ -288,7 +349,17 @@ func initSecretsCommand() *cobra.Command {
 				if rootDir == "" {
 					rootDir = ".xlrte/config"
 				}
-				err := secrets.AddSecret(rootDir, environment, name)
+				var err error
+				if fromFile != "" || fromEnv != "" || fromStdin {
+					value, e := secretValue(fromFile, fromEnv, fromStdin)
+					if e != nil {
+						fmt.Println(e)
+						os.Exit(1)
+					}
+					err = secrets.SetSecret(rootDir, environment, name, value, force)
+				} else {
+					err = secrets.AddSecret(rootDir, environment, name, force)
+				}
 				if err != nil {
 					checkSecretInit(err, environment)
 					fmt.Println(err)
@@ -317,15 +388,256 @@ func initSecretsCommand() *cobra.Command {
 				}
 			},
 		},
+		{
+			Use:   "rotate",
+			Short: "generates new values for database passwords and other generated secrets",
+			Long: `generates & encrypts new values for generated secrets that can be rotated, such as database passwords, or only the secrets given with --name.
+The next apply updates the database users before storing the new secret versions, then rolls out new revisions of the services using them.
+Open connections are kept, instances of the previous revision opening new connections fail until the new revision is serving.`,
+			Run: func(cmd *cobra.Command, args []string) {
+				theArgs := runArgs{rootDir: rootDir, environment: environment}
+				input := theArgs.toRunInputs()
+				rotated, err := api.RotateSecrets(input.basePath, input.selector, input.runtimes, names)
+				if err != nil {
+					checkSecretInit(err, environment)
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				for _, name := range rotated {
+					fmt.Println("rotated " + name)
+				}
+				fmt.Printf("Run `xlrte apply -e %s` to deploy the new values\n", environment)
+			},
+		},
+		{
+			Use:   "check",
+			Short: "checks that the secrets services reference exist",
+			Long: `lists the secrets services reference that do not exist in the environment, the secrets no service uses, and the secrets
+other environments have but some do not. Secrets are not decrypted. Exits with an error if referenced secrets are missing.`,
+			Run: func(cmd *cobra.Command, args []string) {
+				theArgs := runArgs{rootDir: rootDir, environment: environment}
+				input := theArgs.toRunInputs()
+				report, err := api.CheckSecrets(input.basePath, input.selector, input.runtimes)
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				missing := []string{}
+				for secretName := range report.Missing {
+					missing = append(missing, secretName)
+				}
+				sort.Strings(missing)
+				for _, secretName := range missing {
+					fmt.Printf("missing %s, used by %s\n", secretName, strings.Join(report.Missing[secretName], ", "))
+				}
+				for _, secretName := range report.Unused {
+					fmt.Printf("unused %s\n", secretName)
+				}
+				envs := []string{}
+				for env := range report.MissingInEnvironments {
+					envs = append(envs, env)
+				}
+				sort.Strings(envs)
+				for _, env := range envs {
+					fmt.Printf("environment %s lacks %s\n", env, strings.Join(report.MissingInEnvironments[env], ", "))
+				}
+				if len(missing) > 0 {
+					fmt.Printf("Add missing secrets with `xlrte secret add -e %s -n <name>`\n", environment)
+					os.Exit(1)
+				}
+			},
+		},
+		{
+			Use:   "import",
+			Short: "adds all secrets of a dotenv or YAML file",
+			Long:  `encrypts every KEY=value line of a dotenv file, or every entry of a YAML map if the file ends in .yaml or .yml, as a secret of the environment`,
+			Run: func(cmd *cobra.Command, args []string) {
+				if rootDir == "" {
+					rootDir = ".xlrte/config"
+				}
+				imported, err := secrets.ImportSecrets(rootDir, environment, importFile, force)
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				for _, secretName := range imported {
+					fmt.Println("imported " + secretName)
+				}
+			},
+		},
+		{
+			Use:   "list",
+			Short: "lists the secrets of an environment",
+			Long:  `lists the secrets of an environment with the public keys they are encrypted to and when they were last changed, without decrypting them`,
+			Run: func(cmd *cobra.Command, args []string) {
+				if rootDir == "" {
+					rootDir = ".xlrte/config"
+				}
+				infos, err := secrets.ListSecrets(rootDir, environment)
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(writer, "NAME\tRECIPIENTS\tMODIFIED")
+				for _, info := range infos {
+					fmt.Fprintf(writer, "%s\t%s\t%s\n", info.Name, strings.Join(info.Recipients, ", "), info.Modified.Format("2006-01-02 15:04:05"))
+				}
+				err = writer.Flush()
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+			},
+		},
+		{
+			Use:   "get",
+			Short: "prints the value of a secret",
+			Long:  `decrypts a secret and prints its value to stdout, which needs --reveal to avoid printing secrets by accident`,
+			Run: func(cmd *cobra.Command, args []string) {
+				if rootDir == "" {
+					rootDir = ".xlrte/config"
+				}
+				if !reveal {
+					fmt.Println("the value of a secret is only printed with --reveal")
+					os.Exit(1)
+				}
+				dirname, err := os.UserHomeDir()
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				value, err := secrets.GetSecret(dirname, rootDir, environment, name)
+				if err != nil {
+					checkSecretInit(err, environment)
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				fmt.Print(value)
+			},
+		},
+		{
+			Use:   "rm",
+			Short: "removes a secret",
+			Long:  `removes a secret. Services that still reference it can no longer be deployed`,
+			Run: func(cmd *cobra.Command, args []string) {
+				if rootDir == "" {
+					rootDir = ".xlrte/config"
+				}
+				text := yes
+				if yes != "yes" {
+					fmt.Printf("Are you sure you want to remove the secret %s/%s? ('yes', or any other input for no)\n", environment, name)
+					reader := bufio.NewReader(os.Stdin)
+					var err error
+					text, err = reader.ReadString('\n')
+					if err != nil {
+						fmt.Println(err)
+						os.Exit(1)
+					}
+				}
+				if text != "yes\n" && text != "yes" {
+					fmt.Println("remove cancelled")
+					return
+				}
+				err := secrets.RemoveSecret(rootDir, environment, name)
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+			},
+		},
+		{
+			Use:   "diff",
+			Short: "shows which secrets differ between two environments",
+			Long:  `shows the secrets that only exist in one of two environments, given as -e a -e b. With --values, secrets both have are decrypted and compared, values are never printed`,
+			Run: func(cmd *cobra.Command, args []string) {
+				if rootDir == "" {
+					rootDir = ".xlrte/config"
+				}
+				if len(environments) != 2 {
+					fmt.Println("diff compares exactly two environments, given as -e a -e b")
+					os.Exit(1)
+				}
+				dirname, err := os.UserHomeDir()
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				diffs, err := secrets.DiffSecrets(dirname, rootDir, environments[0], environments[1], compareValues)
+				if err != nil {
+					checkSecretInit(err, environments[0])
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				if len(diffs) == 0 {
+					fmt.Println("No differences")
+					return
+				}
+				for _, diff := range diffs {
+					fmt.Printf("%s: %s\n", diff.Name, diff.Change)
+				}
+			},
+		},
+		{
+			Use:   "migrate",
+			Short: "moves secrets to the backend an environment is configured with",
+			Long: `moves all secrets of an environment from the backend given with --from (pgp, age or sops) to the one set in secrets.backend of its resources.yaml.
+The old copies are removed once every secret has been read back from the new backend.`,
+			Run: func(cmd *cobra.Command, args []string) {
+				if rootDir == "" {
+					rootDir = ".xlrte/config"
+				}
+				dirname, err := os.UserHomeDir()
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				migrated, err := secrets.MigrateSecrets(dirname, rootDir, environment, fromBackend)
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				for _, secretName := range migrated {
+					fmt.Println("migrated " + secretName)
+				}
+			},
+		},
 	}
 	for _, cmd := range subCommands {
-		cmd.Flags().StringVarP(&environment, "environment", "e", "", "Environment name")
+		if cmd.Name() == "diff" {
+			cmd.Flags().StringSliceVarP(&environments, "environment", "e", nil, "Environment names, give two to compare")
+			cmd.Flags().BoolVar(&compareValues, "values", false, "Also compare the values of secrets both environments have")
+		} else {
+			cmd.Flags().StringVarP(&environment, "environment", "e", "", "Environment name")
+		}
 		err := cmd.MarkFlagRequired("environment")
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
+		if cmd.Name() == "get" {
+			cmd.Flags().BoolVar(&reveal, "reveal", false, "Print the decrypted value")
+		}
+		if cmd.Name() == "rm" {
+			cmd.Flags().StringVarP(&yes, "confirm", "y", "", "Confirms removal (non-interactive run), give 'yes' as an argument")
+		}
 		if cmd.Name() == "add" {
+			cmd.Flags().StringVar(&fromFile, "from-file", "", "Read the value from a file")
+			cmd.Flags().StringVar(&fromEnv, "from-env", "", "Read the value from an environment variable")
+			cmd.Flags().BoolVar(&fromStdin, "stdin", false, "Read the value from stdin")
+		}
+		if cmd.Name() == "add" || cmd.Name() == "import" {
+			cmd.Flags().BoolVar(&force, "force", false, "Overwrite existing secrets without asking")
+		}
+		if cmd.Name() == "import" {
+			cmd.Flags().StringVarP(&importFile, "file", "f", "", "dotenv or YAML file to import")
+			err = cmd.MarkFlagRequired("file")
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+		if cmd.Name() == "add" || cmd.Name() == "get" || cmd.Name() == "rm" {
 			cmd.Flags().StringVarP(&name, "name", "n", "", "Name of secret")
 			err = cmd.MarkFlagRequired("name")
 			if err != nil {
@@ -333,11 +645,19 @@ func initSecretsCommand() *cobra.Command {
 				os.Exit(1)
 			}
 		}
+		if cmd.Name() == "migrate" {
+			cmd.Flags().StringVar(&fromBackend, "from", "pgp", "Backend the secrets are stored with now")
+		}
+		if cmd.Name() == "rotate" {
+			cmd.Flags().StringSliceVarP(&names, "name", "n", nil, "Name of a secret to rotate, all generated secrets if not given")
+		}
 	}
 
 	command.AddCommand(
 		subCommands...,
 	)
+	command.AddCommand(keysCommand())
+	command.AddCommand(agentCommand())
 
 	command.Flags().StringVarP(&environment, "environment", "e", "", "Environment name")
 	err := command.MarkFlagRequired("environment")
@@ -348,6 +668,196 @@ func initSecretsCommand() *cobra.Command {
 	return command
 }
 
+func keysCommand() *cobra.Command {
+	rootDir := ".xlrte/config"
+	var environment string
+	var keyFile string
+	var fingerprint string
+	command := &cobra.Command{
+		Use:   "keys",
+		Short: "manage the public keys secrets are encrypted to",
+		Long:  `manage the public keys secrets are encrypted to`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := cmd.Help()
+			if err != nil {
+				panic(err)
+			}
+		},
+	}
+	subCommands := []*cobra.Command{
+		{
+			Use:   "list",
+			Short: "lists the public keys of an environment",
+			Long:  `lists the public keys of an environment with their fingerprint, expiry and the number of secrets encrypted to them`,
+			Run: func(cmd *cobra.Command, args []string) {
+				keys, err := secrets.ListKeys(rootDir, environment)
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				total, err := secrets.ListSecrets(rootDir, environment)
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(writer, "IDENTITY\tFINGERPRINT\tEXPIRES\tCAN ENCRYPT\tSECRETS")
+				for _, key := range keys {
+					expires := "never"
+					if !key.Expires.IsZero() {
+						expires = key.Expires.Format("2006-01-02")
+					}
+					fmt.Fprintf(writer, "%s\t%s\t%s\t%t\t%d/%d\n", key.Identity, key.Fingerprint, expires, key.CanEncrypt, key.Secrets, len(total))
+				}
+				err = writer.Flush()
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				stale, err := secrets.StaleSecrets(rootDir, environment)
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				if len(stale) > 0 {
+					fmt.Printf("Secrets still encrypted to removed keys: %s, run `xlrte secret refresh -e %s`\n", strings.Join(stale, ", "), environment)
+				}
+			},
+		},
+		{
+			Use:   "add",
+			Short: "adds a public key to an environment",
+			Long:  `adds an armored public key to an environment after checking that it can encrypt. Existing secrets are encrypted to it by "secret refresh"`,
+			Run: func(cmd *cobra.Command, args []string) {
+				key, err := secrets.AddKey(rootDir, environment, keyFile)
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				fmt.Printf("Added %s (%s), run `xlrte secret refresh -e %s` to give it access to existing secrets\n", key.Identity, key.Fingerprint, environment)
+			},
+		},
+		{
+			Use:   "revoke",
+			Short: "removes a public key and re-encrypts all secrets without it",
+			Long:  `removes the public key with the given fingerprint from an environment and re-encrypts all secrets to the remaining keys. The key can not be added again. Its holder could read the current values, which should be rotated`,
+			Run: func(cmd *cobra.Command, args []string) {
+				dirname, err := os.UserHomeDir()
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				key, err := secrets.RevokeKey(dirname, rootDir, environment, fingerprint)
+				if err != nil {
+					checkSecretInit(err, environment)
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				fmt.Printf("Revoked %s (%s), all secrets have been re-encrypted\n", key.Identity, key.Fingerprint)
+			},
+		},
+	}
+	for _, cmd := range subCommands {
+		cmd.Flags().StringVarP(&environment, "environment", "e", "", "Environment name")
+		err := cmd.MarkFlagRequired("environment")
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		switch cmd.Name() {
+		case "add":
+			cmd.Flags().StringVarP(&keyFile, "file", "f", "", "Armored public key file")
+			err = cmd.MarkFlagRequired("file")
+		case "revoke":
+			cmd.Flags().StringVar(&fingerprint, "fingerprint", "", "Fingerprint of the key, or at least its last 16 characters")
+			err = cmd.MarkFlagRequired("fingerprint")
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	command.AddCommand(subCommands...)
+	return command
+}
+
+func agentCommand() *cobra.Command {
+	var ttl time.Duration
+	var lock bool
+	command := &cobra.Command{
+		Use:   "agent",
+		Short: "caches the unlocked private key so the passphrase is only entered once",
+		Long: `runs an agent on a Unix socket, $HOME/.xlrte/agent.sock or XLRTE_AGENT_SOCK, that keeps the private key unlocked once its passphrase has been entered and decrypts secrets for later runs.
+The key is forgotten when it has not been used for --ttl, with --lock, or when the agent stops.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if lock {
+				err := secrets.LockAgent()
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				return
+			}
+			socket, err := secrets.AgentSocket()
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			listener, err := secrets.Listen(socket)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+			go func() {
+				<-signals
+				listener.Close() //nolint
+			}()
+			fmt.Printf("xlrte agent listening on %s\n", socket)
+			err = secrets.NewAgent(ttl).Serve(listener)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		},
+	}
+	command.Flags().DurationVar(&ttl, "ttl", 15*time.Minute, "Forget the key when it has not been used for this long")
+	command.Flags().BoolVar(&lock, "lock", false, "Make the running agent forget the key")
+	return command
+}
+
+// secretValue reads the value of a secret given by exactly one of --from-file, --from-env or --stdin.
+// A trailing newline is dropped from stdin, as `echo value |` adds one.
+func secretValue(fromFile, fromEnv string, fromStdin bool) (string, error) {
+	sources := 0
+	for _, set := range []bool{fromFile != "", fromEnv != "", fromStdin} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return "", fmt.Errorf("give only one of --from-file, --from-env and --stdin")
+	}
+	switch {
+	case fromFile != "":
+		data, err := ioutil.ReadFile(filepath.Clean(fromFile))
+		return string(data), err
+	case fromEnv != "":
+		value, found := os.LookupEnv(fromEnv)
+		if !found {
+			return "", fmt.Errorf("environment variable %s is not set", fromEnv)
+		}
+		return value, nil
+	default:
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r"), nil
+	}
+}
+
 func (theArgs *runArgs) toRunInputs() runInputs {
 	if theArgs.rootDir == "" {
 		theArgs.rootDir = ".xlrte/config"
@@ -0,0 +1,228 @@
+// Package registry resolves image tags to digests through the Docker Registry HTTP API V2,
+// which Artifact Registry, Container Registry and local registries implement.
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var manifestTypes = []string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}
+
+// Client looks up manifests in a registry. Password returns the password used to obtain
+// registry tokens, for GCP registries an OAuth access token; it may be nil for anonymous access.
+type Client struct {
+	HTTP     *http.Client
+	Username string
+	Password func() (string, error)
+}
+
+// Reference is an image reference split into the registry host, repository and tag or digest.
+type Reference struct {
+	Host       string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference splits an image such as `europe-west6-docker.pkg.dev/project/env/image:v1`.
+// The tag defaults to `latest`.
+func ParseReference(image string) (*Reference, error) {
+	ref := &Reference{}
+	name := image
+	if at := strings.LastIndex(name, "@"); at >= 0 {
+		ref.Digest = name[at+1:]
+		name = name[:at]
+	}
+	if colon := strings.LastIndex(name, ":"); colon > strings.LastIndex(name, "/") {
+		ref.Tag = name[colon+1:]
+		name = name[:colon]
+	}
+	slash := strings.Index(name, "/")
+	if slash <= 0 || slash == len(name)-1 {
+		return nil, fmt.Errorf("image %s must include a registry host and a repository", image)
+	}
+	ref.Host = name[:slash]
+	ref.Repository = name[slash+1:]
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+	return ref, nil
+}
+
+// Pinned is the reference by digest, which always resolves to the same image.
+func (ref *Reference) Pinned() string {
+	return fmt.Sprintf("%s/%s@%s", ref.Host, ref.Repository, ref.Digest)
+}
+
+// ResolveDigest returns the image pinned to the digest its tag currently points to.
+// Images that already reference a digest are returned unchanged.
+func (client *Client) ResolveDigest(ctx context.Context, image string) (string, error) {
+	ref, err := ParseReference(image)
+	if err != nil {
+		return "", err
+	}
+	if ref.Digest != "" {
+		return image, nil
+	}
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", ref.Host, ref.Repository, ref.Tag)
+
+	token := ""
+	resp, err := client.get(ctx, http.MethodHead, manifestURL, token)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close() //nolint
+	if resp.StatusCode == http.StatusUnauthorized {
+		token, err = client.token(ctx, resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return "", fmt.Errorf("image %s: %w", image, err)
+		}
+		resp, err = client.get(ctx, http.MethodHead, manifestURL, token)
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close() //nolint
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("image %s: registry returned %s, has the image been pushed?", image, resp.Status)
+	}
+	ref.Digest = resp.Header.Get("Docker-Content-Digest")
+	if ref.Digest == "" {
+		return client.digestFromBody(ctx, ref, manifestURL, token)
+	}
+	return ref.Pinned(), nil
+}
+
+// digestFromBody computes the digest of a manifest for registries that don't send the Docker-Content-Digest header.
+func (client *Client) digestFromBody(ctx context.Context, ref *Reference, manifestURL, token string) (string, error) {
+	resp, err := client.get(ctx, http.MethodGet, manifestURL, token)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close() //nolint
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("image %s/%s:%s: registry returned %s", ref.Host, ref.Repository, ref.Tag, resp.Status)
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, resp.Body)
+	if err != nil {
+		return "", err
+	}
+	ref.Digest = fmt.Sprintf("sha256:%x", hash.Sum(nil))
+	return ref.Pinned(), nil
+}
+
+func (client *Client) get(ctx context.Context, method, target, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestTypes, ", "))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return client.httpClient().Do(req)
+}
+
+// token requests a bearer token from the realm of a `WWW-Authenticate: Bearer realm=...,service=...,scope=...` challenge.
+func (client *Client) token(ctx context.Context, challenge string) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", fmt.Errorf("unsupported registry authentication '%s'", challenge)
+	}
+	params := parseChallenge(strings.TrimPrefix(challenge, "Bearer "))
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid registry authentication realm '%s'", params["realm"])
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if client.Password != nil {
+		password, e := client.Password()
+		if e != nil {
+			return "", e
+		}
+		req.SetBasicAuth(client.Username, password)
+	}
+	resp, err := client.httpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close() //nolint
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry token request returned %s", resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	err = json.Unmarshal(body, &token)
+	if err != nil {
+		return "", err
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
+
+func (client *Client) httpClient() *http.Client {
+	if client.HTTP != nil {
+		return client.HTTP
+	}
+	return http.DefaultClient
+}
+
+func parseChallenge(challenge string) map[string]string {
+	params := make(map[string]string)
+	for _, part := range splitChallenge(challenge) {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 2 {
+			params[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return params
+}
+
+// splitChallenge splits on commas outside of quotes, as scopes may contain commas.
+func splitChallenge(challenge string) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i, c := range challenge {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			parts = append(parts, challenge[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, challenge[start:])
+}
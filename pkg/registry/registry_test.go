@@ -0,0 +1,98 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const manifest = `{"schemaVersion":2}`
+
+// localRegistry serves the manifest of tag v1 of image `app` to requests with the token of user `oauth2accesstoken`.
+func localRegistry(t *testing.T, withDigestHeader bool) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			user, password, ok := r.BasicAuth()
+			if !ok || user != "oauth2accesstoken" || password != "access-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			assert.Equal(t, "repository:app:pull", r.URL.Query().Get("scope"))
+			fmt.Fprint(w, `{"token":"registry-token"}`)
+		case "/v2/app/manifests/v1":
+			if r.Header.Get("Authorization") != "Bearer registry-token" {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="local",scope="repository:app:pull"`, server.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			assert.True(t, strings.Contains(r.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json"))
+			if withDigestHeader {
+				w.Header().Set("Docker-Content-Digest", "sha256:abc")
+			}
+			if r.Method == http.MethodGet {
+				fmt.Fprint(w, manifest)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server
+}
+
+func Test_Parse_Reference(t *testing.T) {
+	ref, err := ParseReference("europe-west6-docker.pkg.dev/project/prod/app:v1")
+	assert.NoError(t, err)
+	assert.Equal(t, &Reference{Host: "europe-west6-docker.pkg.dev", Repository: "project/prod/app", Tag: "v1"}, ref)
+
+	ref, err = ParseReference("localhost:5000/app")
+	assert.NoError(t, err)
+	assert.Equal(t, &Reference{Host: "localhost:5000", Repository: "app", Tag: "latest"}, ref)
+
+	ref, err = ParseReference("gcr.io/project/app@sha256:abc")
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:abc", ref.Digest)
+
+	_, err = ParseReference("app:v1")
+	assert.Error(t, err)
+}
+
+func Test_Resolve_Digest(t *testing.T) {
+	server := localRegistry(t, true)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	client := &Client{HTTP: server.Client(), Username: "oauth2accesstoken", Password: func() (string, error) { return "access-token", nil }}
+	pinned, err := client.ResolveDigest(context.Background(), host+"/app:v1")
+	assert.NoError(t, err)
+	assert.Equal(t, host+"/app@sha256:abc", pinned)
+
+	pinned, err = client.ResolveDigest(context.Background(), host+"/app@sha256:def")
+	assert.NoError(t, err)
+	assert.Equal(t, host+"/app@sha256:def", pinned)
+
+	_, err = client.ResolveDigest(context.Background(), host+"/app:missing")
+	assert.Error(t, err)
+
+	client.Password = func() (string, error) { return "wrong", nil }
+	_, err = client.ResolveDigest(context.Background(), host+"/app:v1")
+	assert.Error(t, err)
+}
+
+func Test_Resolve_Digest_From_Manifest(t *testing.T) {
+	server := localRegistry(t, false)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	client := &Client{HTTP: server.Client(), Username: "oauth2accesstoken", Password: func() (string, error) { return "access-token", nil }}
+	pinned, err := client.ResolveDigest(context.Background(), host+"/app:v1")
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%s/app@sha256:%x", host, sha256.Sum256([]byte(manifest))), pinned)
+}
@@ -34,7 +34,7 @@ func (rt *awsRuntime) Init(env api.EnvContext) error {
 }
 
 //InitSecrets initialises the secrets system.
-func (rt *awsRuntime) InitSecrets(env api.EnvContext, secrets []*secrets.Secret) error {
+func (rt *awsRuntime) InitSecrets(env api.EnvContext, secrets []*secrets.Secret, stored []string) error {
 	return nil
 }
 func (rt *awsRuntime) Resources() []api.ResourceLoader {
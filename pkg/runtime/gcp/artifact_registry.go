@@ -0,0 +1,116 @@
+package gcp
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/xlrte/core/pkg/api"
+	"github.com/xlrte/core/pkg/registry"
+)
+
+//go:embed templates/artifact_registry.tf
+var artifactRegistryMain string
+
+const artifactRegistryFile = "artifact_registry.tf"
+
+// artifactRegistry is the Docker repository images are deployed from, configured by the `artifact_registry`
+// resource of an environment. Images are deployed from the `docker_repo` of the environment, or gcr.io of its
+// project. With `create: true` and no `docker_repo`, xlrte creates an Artifact Registry repository for the
+// environment and deploys from it instead.
+type artifactRegistry struct {
+	baseDir    string
+	Project    string `yaml:"-"`
+	Region     string `yaml:"-"`
+	RepoBase   string `yaml:"-"`
+	Managed    bool   `yaml:"-"`
+	Create     bool   `yaml:"create"`
+	PinDigests bool   `yaml:"pin_digests"` // deploy images by the digest their tag points to at plan time
+	resolve    func(image string) (string, error)
+}
+
+func newArtifactRegistry(baseDir string) *artifactRegistry {
+	client := &registry.Client{Username: "oauth2accesstoken", Password: accessToken}
+	return &artifactRegistry{
+		baseDir: baseDir,
+		resolve: func(image string) (string, error) {
+			return client.ResolveDigest(context.Background(), image)
+		},
+	}
+}
+
+// defaultRepoBase is the Container Registry of the project, used when an environment sets no `docker_repo`.
+func defaultRepoBase(ctx api.EnvContext) string {
+	return fmt.Sprintf("gcr.io/%s/", ctx.Context)
+}
+
+// environmentRepoBase is the Artifact Registry repository xlrte creates for the environment.
+func environmentRepoBase(ctx api.EnvContext) string {
+	return fmt.Sprintf("%s-docker.pkg.dev/%s/%s/", ctx.Region, ctx.Context, ctx.EnvName)
+}
+
+func (r *artifactRegistry) init(ctx api.EnvContext) error {
+	if ctx.Config != nil {
+		err := ctx.Config("artifact_registry", r)
+		if err != nil {
+			return err
+		}
+	}
+	r.Project = ctx.Context
+	r.Region = ctx.Region
+	r.RepoBase = ctx.RepoBase
+	r.Managed = r.Create && ctx.RepoBase == ""
+	if r.Managed {
+		r.RepoBase = environmentRepoBase(ctx)
+	} else if r.RepoBase == "" {
+		r.RepoBase = defaultRepoBase(ctx)
+	} else if !strings.HasSuffix(r.RepoBase, "/") {
+		// docker_repo may be given as a repository path without the separator of the image name
+		r.RepoBase += "/"
+	}
+	return nil
+}
+
+// image is the image of a service, pinned to its current digest if pin_digests is set,
+// so that re-applying a deployment never picks up a re-pushed tag.
+func (r *artifactRegistry) image(baseName, version string) (string, error) {
+	image := fmt.Sprintf("%s%s:%s", r.RepoBase, baseName, version)
+	if !r.PinDigests {
+		return image, nil
+	}
+	pinned, err := r.resolve(image)
+	if err != nil {
+		return "", fmt.Errorf("pin_digests: %w", err)
+	}
+	return pinned, nil
+}
+
+// Configure writes the repository to its own file, as it does not belong to a single service.
+func (r *artifactRegistry) Configure() error {
+	file := filepath.Join(r.baseDir, artifactRegistryFile)
+	if !r.Managed {
+		err := os.Remove(file)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(file, []byte(artifactRegistryMain), 0600)
+}
+
+// accessToken is the OAuth access token of the current gcloud credentials, which Artifact Registry accepts
+// as password of the user oauth2accesstoken. GOOGLE_OAUTH_ACCESS_TOKEN takes precedence, as for terraform.
+func accessToken() (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	out, err := exec.Command("gcloud", "auth", "print-access-token").Output()
+	if err != nil {
+		return "", fmt.Errorf("could not get an access token from gcloud, set GOOGLE_OAUTH_ACCESS_TOKEN instead: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,94 @@
+package gcp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlrte/core/pkg/api"
+)
+
+func Test_Registry_Creates_Environment_Repository(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tf_temp")
+	if err != nil {
+		log.Fatalf("error creating temp dir: %s", err)
+	}
+	defer func() {
+		e := os.RemoveAll(tmpDir)
+		assert.NoError(t, e)
+	}()
+
+	r := newArtifactRegistry(tmpDir)
+	assert.NoError(t, r.init(api.EnvContext{Context: "theproject", Region: "europe-west6", EnvName: "prod"}))
+	assert.False(t, r.Managed, "existing environments keep deploying from gcr.io")
+	image, err := r.image("foo", "v1")
+	assert.NoError(t, err)
+	assert.Equal(t, "gcr.io/theproject/foo:v1", image)
+	assert.NoError(t, r.Configure())
+	file := filepath.Join(tmpDir, artifactRegistryFile)
+	_, err = os.Stat(file)
+	assert.True(t, os.IsNotExist(err))
+
+	r = newArtifactRegistry(tmpDir)
+	r.Create = true
+	assert.NoError(t, r.init(api.EnvContext{Context: "theproject", Region: "europe-west6", EnvName: "prod"}))
+	assert.True(t, r.Managed)
+	image, err = r.image("foo", "v1")
+	assert.NoError(t, err)
+	assert.Equal(t, "europe-west6-docker.pkg.dev/theproject/prod/foo:v1", image)
+
+	assert.NoError(t, r.Configure())
+	assertInFile(t, file, `module "artifact_registry"`)
+
+	r = newArtifactRegistry(tmpDir)
+	r.Create = true
+	assert.NoError(t, r.init(api.EnvContext{Context: "theproject", Region: "europe-west6", EnvName: "prod", RepoBase: "eu.gcr.io/shared/"}))
+	assert.False(t, r.Managed)
+	image, err = r.image("foo", "v1")
+	assert.NoError(t, err)
+	assert.Equal(t, "eu.gcr.io/shared/foo:v1", image)
+	assert.NoError(t, r.Configure())
+	_, err = os.Stat(file)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_Registry_Adds_Separator_To_Docker_Repo(t *testing.T) {
+	r := newArtifactRegistry("")
+	assert.NoError(t, r.init(api.EnvContext{Context: "theproject", Region: "europe-west6", EnvName: "prod", RepoBase: "europe-docker.pkg.dev/proj/repo"}))
+	image, err := r.image("foo", "v1")
+	assert.NoError(t, err)
+	assert.Equal(t, "europe-docker.pkg.dev/proj/repo/foo:v1", image)
+}
+
+func Test_Registry_Pins_Digests(t *testing.T) {
+	r := newArtifactRegistry("")
+	env := envWithResources(t, filepath.Join("testdata", "artifact_registry", "resources.yaml"))
+	env.Region = "europe-west6"
+	assert.NoError(t, r.init(env))
+	assert.True(t, r.PinDigests)
+
+	r.resolve = func(image string) (string, error) {
+		assert.Equal(t, "europe-west6-docker.pkg.dev/theproject/prod/foo:v1", image)
+		return "europe-west6-docker.pkg.dev/theproject/prod/foo@sha256:abc", nil
+	}
+	loader := &cloudRunLoader{baseDir: "", registry: r}
+	env.Version = func(s string) (string, error) { return "v1", nil }
+	conf, err := loader.toCloudRunSettings(env, &api.Service{
+		SVCName: "cloudrun-srv",
+		Runtime: "cloudrun",
+		Spec:    cloudRunSpec{BaseName: "foo"},
+	}, api.DeploymentContext{Env: api.EnvVars{}})
+	assert.NoError(t, err)
+	assert.Equal(t, "europe-west6-docker.pkg.dev/theproject/prod/foo@sha256:abc", conf.ImageID)
+	assert.Contains(t, conf.DependsOn, "module.artifact_registry")
+
+	r.resolve = func(image string) (string, error) {
+		return "", fmt.Errorf("not found")
+	}
+	_, err = r.image("foo", "v2")
+	assert.Error(t, err)
+}
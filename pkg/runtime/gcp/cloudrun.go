@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/xlrte/core/pkg/api"
@@ -20,18 +21,27 @@ var cloudRunMain string
 //go:embed templates/cloudrun_domain.tf
 var cloudRunNetworkMain string
 
+// cloudRunIngress maps the `http.ingress` of a service to the Cloud Run ingress setting.
+var cloudRunIngress = map[string]string{
+	"all":             "all",
+	"internal":        "internal",
+	"internal-and-lb": "internal-and-cloud-load-balancing",
+}
+
 type scaling struct {
 	MinInstances int `yaml:"min_instances,omitempty"`
 	MaxInstances int `yaml:"max_instances,omitempty"`
 }
 type cloudRunRuntimeConfig struct {
-	Name        string  `yaml:"name" validate:"required"`
-	Memory      string  `yaml:"memory,omitempty"`
-	CPU         int     `yaml:"cpu,omitempty" validate:"min=1,max=4"` // 1, 2, 4
-	Timeout     int     `yaml:"timeout,omitempty"`
-	MaxRequests int     `yaml:"max_requests,omitempty"`
-	Scaling     scaling `yaml:"scaling,omitempty"`
-	Domain      domain  `yaml:"domain,omitempty"`
+	Name        string    `yaml:"name" validate:"required"`
+	Memory      string    `yaml:"memory,omitempty"`
+	CPU         int       `yaml:"cpu,omitempty" validate:"min=1,max=4"` // 1, 2, 4
+	Timeout     int       `yaml:"timeout,omitempty"`
+	MaxRequests int       `yaml:"max_requests,omitempty"`
+	Scaling     scaling   `yaml:"scaling,omitempty"`
+	Domain      domain    `yaml:"domain,omitempty"`
+	Security    *security `yaml:"security,omitempty"`
+	CDN         *cdn      `yaml:"cdn,omitempty"`
 }
 
 type domain struct {
@@ -47,19 +57,28 @@ type cloudRunNetwork struct {
 type cloudRunConfig struct {
 	baseDir               string
 	ServiceName           string
+	ServiceAccount        string
 	ImageID               string
 	Traffic               int
 	IsPublic              bool
 	Http2                 bool
+	HttpPath              string
+	Ingress               string
 	Env                   api.EnvVars
 	RuntimeConfig         cloudRunRuntimeConfig
 	NetworkConfig         *cloudRunNetwork
 	PublishTopics         []string
 	SubscribeTopics       []*subscription
 	CloudStorage          []*gcsIAM
+	ProjectRoles          []string
+	InvokeServices        []string
+	EgressAllTraffic      bool // route all traffic through the private network, to reach services with internal ingress
+	TaskQueues            []string
 	ServerlessNetworkLink string
 	HasServerlessNetwork  bool
 	DependsOn             []string
+	ingress               *httpIngress
+	internalCallees       []string
 }
 
 type cloudRunSpec struct {
@@ -68,8 +87,10 @@ type cloudRunSpec struct {
 }
 
 type http struct {
-	Public bool `yaml:"public" validate:"required"`
-	Http2  bool `yaml:"http2"`
+	Public  bool   `yaml:"public" validate:"required"`
+	Http2   bool   `yaml:"http2"`
+	Path    string `yaml:"path"`
+	Ingress string `yaml:"ingress"` // all (default), internal or internal-and-lb
 }
 
 type crFile struct {
@@ -78,8 +99,11 @@ type crFile struct {
 }
 
 type cloudRunLoader struct {
-	baseDir string
-	service *api.Service
+	baseDir  string
+	service  *api.Service
+	ingress  *httpIngress
+	registry *artifactRegistry
+	services map[string]*cloudRunConfig
 }
 
 func (loader *cloudRunLoader) Name() string {
@@ -92,17 +116,54 @@ func (loader *cloudRunLoader) Load(ctx api.EnvContext, service *api.Service, dep
 		return nil, err
 	}
 	config.baseDir = loader.baseDir
+	if loader.services != nil {
+		loader.services[config.ServiceName] = config
+	}
+	if loader.ingress != nil {
+		err = loader.ingress.addRoute(&httpRoute{
+			Service:  config.ServiceName,
+			Path:     config.HttpPath,
+			Ingress:  config.Ingress,
+			Security: config.RuntimeConfig.Security,
+			CDN:      config.RuntimeConfig.CDN,
+		})
+		if err != nil {
+			return nil, err
+		}
+		config.ingress = loader.ingress
+	}
 	return config, nil
 }
 
 func (config *cloudRunConfig) Configure() error {
-	return configureCloudRun(config.baseDir, *config)
+	if len(config.internalCallees) > 0 && !config.HasServerlessNetwork {
+		return fmt.Errorf("service %s invokes %s, which only accepts internal traffic, but is not connected to a private network to send it through; depend on a resource in a private network or set http.ingress to all",
+			config.ServiceName, strings.Join(config.internalCallees, ", "))
+	}
+	err := configureCloudRun(config.baseDir, *config)
+	if err != nil {
+		return err
+	}
+	if config.ingress != nil {
+		return config.ingress.Configure()
+	}
+	return nil
 }
 
 func (config *cloudRunConfig) Identity() api.ResourceIdentity {
 	return api.ResourceIdentity{Type: "cloudrun", ID: config.ServiceName}
 }
 
+// SecretVersions pins each secret to the version of its secret module, so that a new revision is rolled out
+// when a secret is changed or rotated, rather than only new instances reading the latest version.
+func (config *cloudRunConfig) SecretVersions() map[string]string {
+	versions := make(map[string]string)
+	for key, secretID := range config.Env.Secrets {
+		versions[key] = strings.TrimSuffix(secretID, ".secret_id") + ".version"
+	}
+	return versions
+}
+
 func (loader *cloudRunLoader) toCloudRunSettings(ctx api.EnvContext, service *api.Service, deploymentContext api.DeploymentContext) (*cloudRunConfig, error) {
 	serviceSettings := defaultCloudRunRuntimeConfig()
 	if deploymentContext.Resources != nil {
@@ -117,10 +178,6 @@ func (loader *cloudRunLoader) toCloudRunSettings(ctx api.EnvContext, service *ap
 			}
 		}
 	}
-	if ctx.RepoBase == "" {
-		ctx.RepoBase = fmt.Sprintf("gcr.io/%s/", ctx.Context)
-	}
-
 	bytes, err := yaml.Marshal(service.Spec)
 	if err != nil {
 		return nil, err
@@ -134,14 +191,40 @@ func (loader *cloudRunLoader) toCloudRunSettings(ctx api.EnvContext, service *ap
 	if err != nil {
 		return nil, err
 	}
+	if def.Http.Ingress == "" {
+		def.Http.Ingress = "all"
+	}
+	ingress, found := cloudRunIngress[def.Http.Ingress]
+	if !found {
+		return nil, fmt.Errorf("service %s: invalid http ingress '%s', valid values are all, internal and internal-and-lb", service.SVCName, def.Http.Ingress)
+	}
+	accountID, err := serviceAccountID(service.SVCName, ctx.EnvName)
+	if err != nil {
+		return nil, err
+	}
+	registry := loader.registry
+	if registry == nil {
+		registry = newArtifactRegistry(loader.baseDir)
+		err = registry.init(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	imageID, err := registry.image(def.BaseName, version)
+	if err != nil {
+		return nil, fmt.Errorf("service %s: %w", service.SVCName, err)
+	}
 	config := &cloudRunConfig{
-		ServiceName:   service.SVCName,
-		ImageID:       fmt.Sprintf("%s%s:%s", ctx.RepoBase, def.BaseName, version),
-		Traffic:       100,
-		IsPublic:      def.Http.Public,
-		Http2:         def.Http.Http2,
-		RuntimeConfig: *serviceSettings,
-		Env:           deploymentContext.Env,
+		ServiceName:    service.SVCName,
+		ServiceAccount: fmt.Sprintf("%s@%s.iam.gserviceaccount.com", accountID, ctx.Context),
+		ImageID:        imageID,
+		Traffic:        100,
+		IsPublic:       def.Http.Public,
+		Http2:          def.Http.Http2,
+		HttpPath:       def.Http.Path,
+		Ingress:        ingress,
+		RuntimeConfig:  *serviceSettings,
+		Env:            deploymentContext.Env,
 	}
 	if config.Env.Refs == nil {
 		config.Env.Refs = make(map[string]string)
@@ -150,12 +233,19 @@ func (loader *cloudRunLoader) toCloudRunSettings(ctx api.EnvContext, service *ap
 		config.Env.Secrets = make(map[string]string)
 	}
 
+	if registry.Managed {
+		config.DependsOn = append(config.DependsOn, "module.artifact_registry")
+	}
+
 	for k, v := range config.Env.Secrets {
 		config.Env.Secrets[k] = fmt.Sprintf("module.secret-%s.secret_id", v)
 		config.DependsOn = append(config.DependsOn, fmt.Sprintf("module.secret-%s", v))
 	}
 
 	if config.RuntimeConfig.Domain.DNSZone != "" && config.RuntimeConfig.Domain.Name != "" {
+		if config.Ingress != "all" {
+			return nil, fmt.Errorf("service %s: a domain mapping needs http ingress all, use the http resource of the environment with ingress internal-and-lb instead", service.SVCName)
+		}
 
 		nwConfig := cloudRunNetwork{
 			Domain:  config.RuntimeConfig.Domain,
@@ -204,6 +294,9 @@ func parseCloudRunRTEConfig(resource *[]byte) ([]*cloudRunRuntimeConfig, error)
 		if conf.Scaling.MaxInstances == 0 {
 			conf.Scaling.MaxInstances = 1000
 		}
+		if conf.CDN != nil {
+			conf.CDN.setDefaults()
+		}
 		validate := validator.New()
 		if errs := validate.Struct(conf); errs != nil {
 			return nil, errs
@@ -218,7 +311,11 @@ func configureCloudRun(baseDir string, config cloudRunConfig) error {
 		{"cloudrun.tf", cloudRunMain},
 	}
 
-	err := applyTerraformTemplates(baseDir, files, config)
+	err := applyTerraformTemplates(baseDir, files, &config)
+	if err != nil {
+		return err
+	}
+	err = writeIAMReport(baseDir, &config)
 	if err != nil {
 		return err
 	}
@@ -8,9 +8,10 @@ import (
 )
 
 type cloudRunDependency struct {
-	baseDir string
-	Service string `yaml:"name"`
-	EnvVar  string `yaml:"env"`
+	baseDir  string
+	services map[string]*cloudRunConfig
+	Service  string `yaml:"name"`
+	EnvVar   string `yaml:"env"`
 }
 
 func (rt *cloudRunDependency) Load(d *api.ResourceDefinition) ([]api.Resource, []api.DependencyBinding, error) {
@@ -22,6 +23,7 @@ func (rt *cloudRunDependency) Load(d *api.ResourceDefinition) ([]api.Resource, [
 	}
 	for _, service := range services {
 		service.baseDir = rt.baseDir
+		service.services = rt.services
 		bindings = append(bindings, api.DependencyBinding{
 			DependedOnBy: d.DependedOnBy,
 			Privileges:   api.ReadWrite,
@@ -48,6 +50,13 @@ func (rt *cloudRunDependency) ConfigureResource(resource api.Resource) error {
 		urlLink := fmt.Sprintf("module.%s-%s.cloud_run_endpoint", "cloudrun", rt.Service)
 		cloudRun.DependsOn = append(cloudRun.DependsOn, dependsOnLink)
 		cloudRun.Env.Refs[fmt.Sprintf("%s_HOST", serviceKey)] = urlLink
+		// the caller's service account may invoke the service, which therefore does not need to be public
+		cloudRun.InvokeServices = append(cloudRun.InvokeServices, rt.Service)
+		// requests to run.app URLs leave through the internet unless all traffic is routed through the private network
+		if callee := rt.services[rt.Service]; callee != nil && callee.Ingress != "all" {
+			cloudRun.EgressAllTraffic = true
+			cloudRun.internalCallees = append(cloudRun.internalCallees, rt.Service)
+		}
 	}
 	return nil
 }
@@ -10,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/xlrte/core/pkg/api"
 	"gopkg.in/yaml.v2"
 )
 
@@ -69,6 +70,7 @@ func Test_Template_SubscribeTopics(t *testing.T) {
 				RetainAckedMessages:   true,
 			},
 		},
+		ProjectRoles: []string{"roles/datastore.user"},
 	}
 
 	err = configureCloudRun(tmpDir, conf)
@@ -79,6 +81,7 @@ func Test_Template_SubscribeTopics(t *testing.T) {
 	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `message_retention_duration = "605s"`)
 	assertInFile(t, filepath.Join(tmpDir, "main.tf"), "retain_acked_messages = true")
 	assertInFile(t, filepath.Join(tmpDir, "main.tf"), "enable_message_ordering = true")
+	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `project_roles = ["roles/datastore.user",]`)
 }
 
 func Test_Template_With_Network(t *testing.T) {
@@ -211,3 +214,97 @@ func Test_parseCloudRunSettings_HasMissConf(t *testing.T) {
 	assert.Error(t, err)
 
 }
+
+func Test_Service_Ingress(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tf_temp")
+	if err != nil {
+		log.Fatalf("error creating temp dir: %s", err)
+	}
+	defer func() {
+		e := os.RemoveAll(tmpDir)
+		assert.NoError(t, e)
+	}()
+	loader := &cloudRunLoader{baseDir: tmpDir}
+	env := api.EnvContext{
+		Context: "theproject",
+		EnvName: "prod",
+		Version: func(s string) (string, error) { return "v1", nil },
+	}
+	load := func(ingress string) (*cloudRunConfig, error) {
+		resource, err := loader.Load(env, &api.Service{
+			SVCName: "backend",
+			Runtime: "cloudrun",
+			Spec: cloudRunSpec{
+				BaseName: "foo",
+				Http:     http{Ingress: ingress},
+			},
+		}, api.DeploymentContext{Env: api.EnvVars{}})
+		if err != nil {
+			return nil, err
+		}
+		return resource.(*cloudRunConfig), nil
+	}
+
+	config, err := load("")
+	assert.NoError(t, err)
+	assert.Equal(t, "all", config.Ingress)
+
+	config, err = load("internal-and-lb")
+	assert.NoError(t, err)
+	assert.Equal(t, "internal-and-cloud-load-balancing", config.Ingress)
+
+	_, err = load("private")
+	assert.Error(t, err)
+
+	config, err = load("internal")
+	assert.NoError(t, err)
+	assert.NoError(t, config.Configure())
+	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `ingress = "internal"`)
+}
+
+func Test_Dependency_Grants_Invoker(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tf_temp")
+	if err != nil {
+		log.Fatalf("error creating temp dir: %s", err)
+	}
+	defer func() {
+		e := os.RemoveAll(tmpDir)
+		assert.NoError(t, e)
+	}()
+
+	caller := &cloudRunConfig{ServiceName: "frontend", Env: api.EnvVars{Refs: map[string]string{}}}
+	dependency := &cloudRunDependency{Service: "backend", EnvVar: "API"}
+	assert.NoError(t, dependency.ConfigureResource(caller))
+	assert.Equal(t, "module.cloudrun-backend.cloud_run_endpoint", caller.Env.Refs["API_HOST"])
+	assert.Equal(t, []string{"backend"}, caller.InvokeServices)
+
+	assert.NoError(t, configureCloudRun(tmpDir, *caller))
+	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `invoke_services = ["backend",]`)
+}
+
+func Test_Invoking_Internal_Service_Routes_All_Traffic(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tf_temp")
+	if err != nil {
+		log.Fatalf("error creating temp dir: %s", err)
+	}
+	defer func() {
+		e := os.RemoveAll(tmpDir)
+		assert.NoError(t, e)
+	}()
+
+	services := map[string]*cloudRunConfig{
+		"backend": {ServiceName: "backend", Ingress: "internal"},
+		"public":  {ServiceName: "public", Ingress: "all"},
+	}
+	caller := &cloudRunConfig{baseDir: tmpDir, ServiceName: "frontend", Env: api.EnvVars{Refs: map[string]string{}}}
+	assert.NoError(t, (&cloudRunDependency{Service: "public", services: services}).ConfigureResource(caller))
+	assert.False(t, caller.EgressAllTraffic)
+	assert.NoError(t, (&cloudRunDependency{Service: "backend", services: services}).ConfigureResource(caller))
+	assert.True(t, caller.EgressAllTraffic)
+	assert.EqualError(t, caller.Configure(), "service frontend invokes backend, which only accepts internal traffic, but is not connected to a private network to send it through; depend on a resource in a private network or set http.ingress to all")
+
+	caller.HasServerlessNetwork = true
+	caller.ServerlessNetworkLink = "module.network-default.serverless_connector"
+	assert.NoError(t, caller.Configure())
+	assertInFile(t, filepath.Join(tmpDir, "main.tf"), "egress_all_traffic = true")
+}
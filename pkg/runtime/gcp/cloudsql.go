@@ -3,6 +3,9 @@ package gcp
 import (
 	_ "embed"
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/xlrte/core/pkg/api"
 	"gopkg.in/yaml.v2"
@@ -11,19 +14,53 @@ import (
 //go:embed templates/cloudsql.tf
 var cloudSqlMain string
 
+var sqlVersions = map[string][]string{
+	"postgres": {"9.6", "10", "11", "12", "13", "14", "15"},
+	"mysql":    {"5.6", "5.7", "8.0"},
+}
+
+var defaultSqlVersions = map[string]string{
+	"postgres": "13",
+	"mysql":    "8.0",
+}
+
+var sqlPorts = map[string]int{
+	"postgres": 5432,
+	"mysql":    3306,
+}
+
+var sqlUserName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`)
+
+// database names are part of the DB_<name>_<db>_NAME variables of services
+var sqlDatabaseName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`)
+
 type cloudSql struct {
 	baseDir                    string
-	DbName                     string `yaml:"name"`
-	DBType                     string `yaml:"type"`
-	MachineType                string `yaml:"machine_type"`
-	Size                       int    `yaml:"storage_size"`
-	DeleteProtection           bool   `yaml:"delete_protection"`
-	MaintenanceWindowHour      int    `yaml:"maintenance_window_hour"`
-	MaintenanceWindowDay       int    `yaml:"maintenance_window_day"`
-	PointInTimeRecoveryEnabled bool   `yaml:"point_in_time_recovery_enabled"`
-	BackupEnabled              *bool  `yaml:"backup_enabled"`
-	BackupStartTime            string `yaml:"backup_start_time"`
-	NetworkLink                string `yaml:"-"`
+	runtime                    migrationContext
+	secretDeps                 map[string]string
+	DbName                     string            `yaml:"name"`
+	DBType                     string            `yaml:"type"`
+	Version                    string            `yaml:"version"`
+	MachineType                string            `yaml:"machine_type"`
+	Size                       int               `yaml:"storage_size"`
+	DeleteProtection           bool              `yaml:"delete_protection"`
+	MaintenanceWindowHour      int               `yaml:"maintenance_window_hour"`
+	MaintenanceWindowDay       int               `yaml:"maintenance_window_day"`
+	PointInTimeRecoveryEnabled bool              `yaml:"point_in_time_recovery_enabled"`
+	BackupEnabled              *bool             `yaml:"backup_enabled"`
+	BackupStartTime            string            `yaml:"backup_start_time"`
+	HighAvailability           bool              `yaml:"high_availability"`
+	ReadReplicas               int               `yaml:"read_replicas"`
+	Flags                      map[string]string `yaml:"flags"`
+	Databases                  []string          `yaml:"databases"`
+	Users                      []string          `yaml:"users"`
+	Migrations                 string            `yaml:"migrations"`
+	NetworkName                string            `yaml:"network"`
+	Port                       int               `yaml:"-"`
+	NetworkLink                string            `yaml:"-"`
+	PeeringAddress             string            `yaml:"-"`
+	PeeringPrefix              int               `yaml:"-"`
+	CreatePeering              bool              `yaml:"-"`
 }
 
 func (rt *cloudSql) Load(d *api.ResourceDefinition) ([]api.Resource, []api.DependencyBinding, error) {
@@ -45,18 +82,11 @@ func (rt *cloudSql) Load(d *api.ResourceDefinition) ([]api.Resource, []api.Depen
 		}
 	}
 
-	network := &privateNetwork{baseDir: rt.baseDir, MinInstances: 2, MaxInstances: 3, InstanceType: "f1-micro"}
-	err := d.GetConfig("vpc_access_connector", network)
+	networks, err := loadNetworks(d, rt.baseDir)
 	if err != nil {
 		return nil, nil, err
 	}
-	rs = append(rs, network)
-	bindings = append(bindings, api.DependencyBinding{
-		DependedOnBy: d.DependedOnBy,
-		Privileges:   api.Owner,
-		Identity:     network.Identity(),
-		Config:       network.configurator(),
-	})
+	usedNetworks := make(map[string]bool)
 
 	for _, db := range dbs {
 		for _, r := range resources {
@@ -69,19 +99,61 @@ func (rt *cloudSql) Load(d *api.ResourceDefinition) ([]api.Resource, []api.Depen
 				db.PointInTimeRecoveryEnabled = r.PointInTimeRecoveryEnabled
 				db.BackupEnabled = r.BackupEnabled
 				db.BackupStartTime = r.BackupStartTime
+				db.HighAvailability = r.HighAvailability
+				db.ReadReplicas = r.ReadReplicas
+				db.Flags = r.Flags
+				db.Databases = r.Databases
+				db.Users = r.Users
+				if r.Version != "" {
+					db.Version = r.Version
+				}
+				if r.NetworkName != "" {
+					db.NetworkName = r.NetworkName
+				}
 				break
 			}
 		}
+		network, err := networkFor(networks, db.NetworkName)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cloudsql %s: %w", db.DbName, err)
+		}
+		if !usedNetworks[network.NetworkName] {
+			usedNetworks[network.NetworkName] = true
+			rs = append(rs, network)
+			bindings = append(bindings, api.DependencyBinding{
+				DependedOnBy: d.DependedOnBy,
+				Privileges:   api.Owner,
+				Identity:     network.Identity(),
+				Config:       network.configurator(),
+			})
+		}
+		db.NetworkName = network.NetworkName
+		db.PeeringAddress, db.PeeringPrefix = network.peering()
+		db.CreatePeering = network.ExistingNetwork == ""
 		if db.Size == 0 {
 			db.Size = 10
 		}
 		if db.MachineType == "" {
 			db.MachineType = "db-f1-micro"
 		}
-		if db.DBType == "postgres" {
-			db.DBType = "POSTGRES_13"
-		} else {
-			return nil, nil, fmt.Errorf("at the moment 'postgres' is the only supported database")
+		engine, err := toDatabaseVersion(db.DBType, db.Version)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cloudsql %s: %w", db.DbName, err)
+		}
+		db.Port = sqlPorts[db.DBType]
+		db.DBType = engine
+		if db.ReadReplicas < 0 {
+			return nil, nil, fmt.Errorf("cloudsql %s: read_replicas can not be negative", db.DbName)
+		}
+		for _, database := range db.Databases {
+			if !sqlDatabaseName.MatchString(database) {
+				return nil, nil, fmt.Errorf("cloudsql %s: invalid database name '%s', database names must start with a letter and only contain letters, digits and underscores", db.DbName, database)
+			}
+		}
+		for _, user := range db.Users {
+			if !sqlUserName.MatchString(user) {
+				return nil, nil, fmt.Errorf("cloudsql %s: invalid user name '%s', user names must start with a letter and only contain letters, digits and underscores", db.DbName, user)
+			}
 		}
 		if db.MaintenanceWindowDay == 0 {
 			db.MaintenanceWindowDay = 7
@@ -93,26 +165,47 @@ func (rt *cloudSql) Load(d *api.ResourceDefinition) ([]api.Resource, []api.Depen
 			soTrue := true
 			db.BackupEnabled = &soTrue
 		}
+		// MySQL replicas and high availability need binary logging, which Cloud SQL only enables with backups
+		if strings.HasPrefix(db.DBType, "MYSQL") && !*db.BackupEnabled && (db.HighAvailability || db.ReadReplicas > 0) {
+			return nil, nil, fmt.Errorf("cloudsql %s: mysql read_replicas and high_availability need backup_enabled", db.DbName)
+		}
 		if db.BackupStartTime == "" {
 			db.BackupStartTime = "04:00"
 		}
 		db.baseDir = rt.baseDir
 		identity := api.ResourceIdentity{Type: "cloudsql", ID: db.DbName}
 		passwordRef := api.SecretRef{
-			Name: "PASSWORD",
-			Type: api.RandomString,
+			Name:   "PASSWORD",
+			Type:   api.Password,
+			Rotate: true,
 		}
 		userRef := api.SecretRef{
 			Name: "USER",
 			Type: api.RandomString,
 		}
+		secretRefs := []api.SecretRef{passwordRef, userRef}
+		for _, user := range db.Users {
+			secretRefs = append(secretRefs, api.SecretRef{
+				Name:   fmt.Sprintf("%s_PASSWORD", user),
+				Type:   api.Password,
+				Rotate: true,
+			})
+		}
+		if rt.secretDeps != nil {
+			// a rotated password is only stored once the database user has it, so new revisions can connect
+			for _, ref := range secretRefs {
+				if ref.Rotate {
+					rt.secretDeps[fmt.Sprintf("%s_%s", identity.String(), ref.Name)] = toDependency(identity.String())
+				}
+			}
+		}
 		// DB dependency of Service
 		bindings = append(bindings, api.DependencyBinding{
 			DependedOnBy: d.DependedOnBy,
 			Privileges:   api.Owner,
 			Identity:     identity,
 			Config:       db,
-			SecretRefs:   []api.SecretRef{passwordRef, userRef},
+			SecretRefs:   secretRefs,
 		})
 
 		// Network dependency of DB
@@ -123,6 +216,16 @@ func (rt *cloudSql) Load(d *api.ResourceDefinition) ([]api.Resource, []api.Depen
 			Config:       network.configurator(),
 		})
 		rs = append(rs, db)
+		if db.Migrations != "" {
+			rs = append(rs, &cloudSqlMigrations{
+				baseDir:   rt.baseDir,
+				runtime:   rt.runtime,
+				DbName:    db.DbName,
+				Engine:    db.DBType,
+				Port:      db.Port,
+				Directory: migrationsDir(d.RootDir, db.Migrations),
+			})
+		}
 	}
 	return rs, bindings, nil
 }
@@ -156,7 +259,26 @@ func (r *cloudSql) ConfigureResource(resource api.Resource) error {
 		userSecret := fmt.Sprintf("secret-%s_USER", r.Identity().String())
 		pwdSecretId := fmt.Sprintf("secret-%s_PASSWORD.secret_id", r.Identity().String())
 		userSecretId := fmt.Sprintf("secret-%s_USER.secret_id", r.Identity().String())
+		if cloudrun.Env.Vars == nil {
+			cloudrun.Env.Vars = make(map[string]string)
+		}
 		cloudrun.Env.Refs[fmt.Sprintf("DB_%s_HOST", r.DbName)] = host
+		cloudrun.Env.Refs[fmt.Sprintf("DB_%s_NAME", r.DbName)] = fmt.Sprintf("module.%s-%s.db_name", "cloudsql", r.DbName)
+		if r.Port > 0 {
+			cloudrun.Env.Vars[fmt.Sprintf("DB_%s_PORT", r.DbName)] = strconv.Itoa(r.Port)
+		}
+		if r.ReadReplicas > 0 {
+			cloudrun.Env.Refs[fmt.Sprintf("DB_%s_READ_HOST", r.DbName)] = fmt.Sprintf("module.%s-%s.read_replica_private_ips[0]", "cloudsql", r.DbName)
+		}
+		for _, database := range r.Databases {
+			cloudrun.Env.Vars[fmt.Sprintf("DB_%s_%s_NAME", r.DbName, database)] = database
+		}
+		for _, user := range r.Users {
+			userPwdSecret := fmt.Sprintf("secret-%s_%s_PASSWORD", r.Identity().String(), user)
+			cloudrun.DependsOn = append(cloudrun.DependsOn, toDependency(userPwdSecret))
+			cloudrun.Env.Vars[fmt.Sprintf("DB_%s_%s_USER", r.DbName, user)] = user
+			cloudrun.Env.Secrets[fmt.Sprintf("DB_%s_%s_PASSWORD", r.DbName, user)] = toDependency(userPwdSecret + ".secret_id")
+		}
 		cloudrun.DependsOn = append(cloudrun.DependsOn, dependency)
 		cloudrun.DependsOn = append(cloudrun.DependsOn, toDependency(pwdSecret))
 		cloudrun.DependsOn = append(cloudrun.DependsOn, toDependency(userSecret))
@@ -166,6 +288,22 @@ func (r *cloudSql) ConfigureResource(resource api.Resource) error {
 	return nil
 }
 
+func toDatabaseVersion(dbType, version string) (string, error) {
+	versions, ok := sqlVersions[dbType]
+	if !ok {
+		return "", fmt.Errorf("unsupported database type '%s', supported types are 'postgres' and 'mysql'", dbType)
+	}
+	if version == "" {
+		version = defaultSqlVersions[dbType]
+	}
+	for _, v := range versions {
+		if v == version {
+			return fmt.Sprintf("%s_%s", strings.ToUpper(dbType), strings.ReplaceAll(version, ".", "_")), nil
+		}
+	}
+	return "", fmt.Errorf("unsupported %s version '%s', supported versions are %s", dbType, version, strings.Join(versions, ", "))
+}
+
 func toDependency(name string) string {
 	return fmt.Sprintf("module.%s", name)
 }
@@ -0,0 +1,333 @@
+package gcp
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq" // registers the "postgres" driver
+	"github.com/xlrte/core/pkg/api"
+)
+
+//go:embed templates/cloudsql_migrations.tf
+var cloudSqlMigrationsMain string
+
+const migrationsTable = "xlrte_schema_migrations"
+
+var migrationFile = regexp.MustCompile(`^(\d+)_([a-zA-Z0-9_\-]+)\.sql$`)
+
+// migrationContext gives migrations access to the outputs and secrets of the deployment they are part of.
+type migrationContext interface {
+	output(ctx context.Context, name string) (string, error)
+	secret(name string) (string, error)
+}
+
+type cloudSqlMigrations struct {
+	baseDir   string
+	runtime   migrationContext
+	DbName    string
+	Engine    string
+	Port      int
+	Directory string
+}
+
+type migration struct {
+	Version int64
+	Name    string
+	Path    string
+}
+
+// migrationStore records which migrations have been applied to a database.
+type migrationStore interface {
+	init(ctx context.Context) error
+	applied(ctx context.Context) (map[int64]bool, error)
+	apply(ctx context.Context, m migration, statements string) error
+}
+
+type sqlMigrationStore struct {
+	db         *sql.DB
+	isPostgres bool
+}
+
+func (r *cloudSqlMigrations) Identity() api.ResourceIdentity {
+	return api.ResourceIdentity{Type: "cloudsql_migrations", ID: r.DbName}
+}
+
+func (r *cloudSqlMigrations) Configure() error {
+	return applyTerraformTemplates(r.baseDir, []crFile{
+		{"cloudsql_migrations.tf", cloudSqlMigrationsMain},
+	}, r)
+}
+
+func (r *cloudSqlMigrations) MigrationTargets() []api.ResourceIdentity {
+	return []api.ResourceIdentity{{Type: "cloudsql", ID: r.DbName}}
+}
+
+func (r *cloudSqlMigrations) Migrate(ctx context.Context) error {
+	migrations, err := readMigrations(r.Directory)
+	if err != nil {
+		return err
+	}
+	store, err := r.connect(ctx)
+	if err != nil {
+		return err
+	}
+	if store == nil {
+		return fmt.Errorf("cloudsql %s has not been created, migrations can not be applied", r.DbName)
+	}
+	defer store.db.Close() //nolint
+	err = store.init(ctx)
+	if err != nil {
+		return err
+	}
+	pending, err := pendingMigrations(ctx, store, migrations)
+	if err != nil {
+		return err
+	}
+	for _, m := range pending {
+		fmt.Printf("applying migration %s to cloudsql %s\n", filepath.Base(m.Path), r.DbName)
+		data, e := ioutil.ReadFile(filepath.Clean(m.Path))
+		if e != nil {
+			return e
+		}
+		e = store.apply(ctx, m, string(data))
+		if e != nil {
+			return fmt.Errorf("migration %s failed: %w", m.Path, e)
+		}
+	}
+	return nil
+}
+
+func (r *cloudSqlMigrations) PendingMigrations(ctx context.Context) ([]string, error) {
+	migrations, err := readMigrations(r.Directory)
+	if err != nil {
+		return nil, err
+	}
+	store, err := r.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pending := migrations
+	if store != nil {
+		defer store.db.Close() //nolint
+		pending, err = pendingMigrations(ctx, store, migrations)
+		if err != nil {
+			return nil, err
+		}
+	}
+	names := []string{}
+	for _, m := range pending {
+		names = append(names, filepath.Base(m.Path))
+	}
+	return names, nil
+}
+
+// connect opens a connection to the database, or returns nil if the database does not exist yet.
+func (r *cloudSqlMigrations) connect(ctx context.Context) (*sqlMigrationStore, error) {
+	user, err := r.runtime.secret(fmt.Sprintf("cloudsql-%s_USER", r.DbName))
+	if err != nil {
+		return nil, err
+	}
+	password, err := r.runtime.secret(fmt.Sprintf("cloudsql-%s_PASSWORD", r.DbName))
+	if err != nil {
+		return nil, err
+	}
+	dbName, err := r.runtime.output(ctx, fmt.Sprintf("cloudsql-%s-db_name", r.DbName))
+	if err != nil {
+		return nil, err
+	}
+	if dbName == "" {
+		// the database has not been created yet
+		return nil, nil
+	}
+	host, port, isOverride := migrationHost(r.DbName, r.Port)
+	if host == "" {
+		host, err = r.runtime.output(ctx, fmt.Sprintf("cloudsql-%s-private_ip", r.DbName))
+		if err != nil {
+			return nil, err
+		}
+	}
+	address := net.JoinHostPort(host, port)
+
+	isPostgres := strings.HasPrefix(r.Engine, "POSTGRES")
+	var db *sql.DB
+	if isPostgres {
+		sslMode := "require"
+		if isOverride {
+			// overridden hosts are expected to be a local Cloud SQL Auth proxy, which handles encryption itself
+			sslMode = "disable"
+		}
+		dsn := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(user, password),
+			Host:     address,
+			Path:     dbName,
+			RawQuery: "sslmode=" + sslMode,
+		}
+		db, err = sql.Open("postgres", dsn.String())
+	} else {
+		config := mysql.NewConfig()
+		config.User = user
+		config.Passwd = password
+		config.Net = "tcp"
+		config.Addr = address
+		config.DBName = dbName
+		config.MultiStatements = true
+		config.ParseTime = true
+		db, err = sql.Open("mysql", config.FormatDSN())
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close() //nolint
+		return nil, fmt.Errorf("could not connect to cloudsql %s at %s to run migrations. Migrations need network access to the database, set XLRTE_DB_%s_HOST to connect through a Cloud SQL Auth proxy: %w", r.DbName, address, envKey(r.DbName), err)
+	}
+	return &sqlMigrationStore{db: db, isPostgres: isPostgres}, nil
+}
+
+// migrationHost returns a host and port set in the environment as XLRTE_DB_<NAME>_HOST and XLRTE_DB_<NAME>_PORT, if any.
+func migrationHost(dbName string, defaultPort int) (string, string, bool) {
+	key := envKey(dbName)
+	host := os.Getenv(fmt.Sprintf("XLRTE_DB_%s_HOST", key))
+	port := os.Getenv(fmt.Sprintf("XLRTE_DB_%s_PORT", key))
+	if port == "" {
+		port = strconv.Itoa(defaultPort)
+	}
+	return host, port, host != ""
+}
+
+func envKey(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// migrationsDir resolves a relative migrations directory against the configuration directory.
+func migrationsDir(rootDir, dir string) string {
+	if filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(rootDir, dir)
+}
+
+// readMigrations reads the migrations in a directory, named <version>_<description>.sql, ordered by version.
+func readMigrations(dir string) ([]migration, error) {
+	entries, err := ioutil.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return nil, fmt.Errorf("could not read migrations: %w", err)
+	}
+	migrations := []migration{}
+	versions := make(map[int64]string)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		parts := migrationFile.FindStringSubmatch(entry.Name())
+		if parts == nil {
+			return nil, fmt.Errorf("migration %s does not follow the naming convention <version>_<description>.sql, such as 0001_create_users.sql", entry.Name())
+		}
+		version, e := strconv.ParseInt(parts[1], 10, 64)
+		if e != nil {
+			return nil, e
+		}
+		if previous, found := versions[version]; found {
+			return nil, fmt.Errorf("migrations %s and %s have the same version %d", previous, entry.Name(), version)
+		}
+		versions[version] = entry.Name()
+		migrations = append(migrations, migration{
+			Version: version,
+			Name:    parts[2],
+			Path:    filepath.Join(dir, entry.Name()),
+		})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+func pendingMigrations(ctx context.Context, store migrationStore, migrations []migration) ([]migration, error) {
+	applied, err := store.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pending := []migration{}
+	for _, m := range migrations {
+		if !applied[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+func (store *sqlMigrationStore) init(ctx context.Context) error {
+	_, err := store.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+  version BIGINT PRIMARY KEY,
+  name VARCHAR(255) NOT NULL,
+  applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`, migrationsTable))
+	return err
+}
+
+func (store *sqlMigrationStore) applied(ctx context.Context) (map[int64]bool, error) {
+	applied := make(map[int64]bool)
+	query := "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?"
+	if store.isPostgres {
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1"
+	}
+	var tables int
+	err := store.db.QueryRowContext(ctx, query, migrationsTable).Scan(&tables)
+	if err != nil {
+		return nil, err
+	}
+	if tables == 0 {
+		return applied, nil
+	}
+	rows, err := store.db.QueryContext(ctx, fmt.Sprintf("SELECT version FROM %s", migrationsTable))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close() //nolint
+	for rows.Next() {
+		var version int64
+		err = rows.Scan(&version)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+func (store *sqlMigrationStore) apply(ctx context.Context, m migration, statements string) error {
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, statements)
+	if err != nil {
+		tx.Rollback() //nolint
+		return err
+	}
+	insert := fmt.Sprintf("INSERT INTO %s (version, name) VALUES (?, ?)", migrationsTable)
+	if store.isPostgres {
+		insert = fmt.Sprintf("INSERT INTO %s (version, name) VALUES ($1, $2)", migrationsTable)
+	}
+	_, err = tx.ExecContext(ctx, insert, m.Version, m.Name)
+	if err != nil {
+		tx.Rollback() //nolint
+		return err
+	}
+	return tx.Commit()
+}
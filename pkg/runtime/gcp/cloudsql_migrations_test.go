@@ -0,0 +1,162 @@
+package gcp
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlrte/core/pkg/api"
+)
+
+type fakeMigrationStore struct {
+	appliedVersions map[int64]bool
+}
+
+type fakeMigrationContext struct {
+	outputs map[string]string
+	secrets map[string]string
+}
+
+func (store *fakeMigrationStore) init(ctx context.Context) error {
+	return nil
+}
+
+func (store *fakeMigrationStore) applied(ctx context.Context) (map[int64]bool, error) {
+	return store.appliedVersions, nil
+}
+
+func (store *fakeMigrationStore) apply(ctx context.Context, m migration, statements string) error {
+	store.appliedVersions[m.Version] = true
+	return nil
+}
+
+func (c *fakeMigrationContext) output(ctx context.Context, name string) (string, error) {
+	return c.outputs[name], nil
+}
+
+func (c *fakeMigrationContext) secret(name string) (string, error) {
+	return c.secrets[name], nil
+}
+
+func Test_Read_Migrations_In_Order(t *testing.T) {
+	migrations, err := readMigrations(filepath.Join("testdata", "cloudsql", "migrations"))
+	assert.NoError(t, err)
+	assert.Equal(t, []migration{
+		{Version: 1, Name: "create_users", Path: filepath.Join("testdata", "cloudsql", "migrations", "0001_create_users.sql")},
+		{Version: 2, Name: "add_email", Path: filepath.Join("testdata", "cloudsql", "migrations", "0002_add_email.sql")},
+		{Version: 10, Name: "add_email_index", Path: filepath.Join("testdata", "cloudsql", "migrations", "10_add_email_index.sql")},
+	}, migrations)
+}
+
+func Test_Read_Migrations_Invalid_Name(t *testing.T) {
+	_, err := readMigrations(filepath.Join("testdata", "cloudsql", "migrations-invalid"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "create_users.sql")
+}
+
+func Test_Read_Migrations_Duplicate_Version(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "migrations")
+	if err != nil {
+		log.Fatalf("error creating temp dir: %s", err)
+	}
+	defer func() {
+		e := os.RemoveAll(tmpDir)
+		assert.NoError(t, e)
+	}()
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "1_first.sql"), []byte("SELECT 1;"), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "001_second.sql"), []byte("SELECT 1;"), 0600))
+
+	_, err = readMigrations(tmpDir)
+	assert.Error(t, err)
+}
+
+func Test_Pending_Migrations(t *testing.T) {
+	migrations, err := readMigrations(filepath.Join("testdata", "cloudsql", "migrations"))
+	assert.NoError(t, err)
+	store := &fakeMigrationStore{appliedVersions: map[int64]bool{1: true}}
+
+	pending, err := pendingMigrations(context.Background(), store, migrations)
+	assert.NoError(t, err)
+	assert.Len(t, pending, 2)
+	assert.Equal(t, int64(2), pending[0].Version)
+	assert.Equal(t, int64(10), pending[1].Version)
+
+	for _, m := range pending {
+		assert.NoError(t, store.apply(context.Background(), m, ""))
+	}
+	pending, err = pendingMigrations(context.Background(), store, migrations)
+	assert.NoError(t, err)
+	assert.Len(t, pending, 0)
+}
+
+func Test_All_Migrations_Pending_Before_Database_Exists(t *testing.T) {
+	migrations := &cloudSqlMigrations{
+		runtime:   &fakeMigrationContext{outputs: map[string]string{}, secrets: map[string]string{}},
+		DbName:    "my-pg-db",
+		Engine:    "POSTGRES_13",
+		Port:      5432,
+		Directory: filepath.Join("testdata", "cloudsql", "migrations"),
+	}
+
+	pending, err := migrations.PendingMigrations(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0001_create_users.sql", "0002_add_email.sql", "10_add_email_index.sql"}, pending)
+	assert.Error(t, migrations.Migrate(context.Background()))
+}
+
+func Test_Load_CloudSql_With_Migrations(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tf_temp")
+	if err != nil {
+		log.Fatalf("error creating temp dir: %s", err)
+	}
+	defer func() {
+		e := os.RemoveAll(tmpDir)
+		assert.NoError(t, e)
+	}()
+	serviceData := getCloudRunBytes(t, filepath.Join("testdata", "cloudsql", "service-migrations.yaml"), "cloudsql")
+
+	resource := &cloudSql{baseDir: tmpDir}
+	resources, _, err := resource.Load(&api.ResourceDefinition{
+		Name:          "cloudsql",
+		DependedOnBy:  api.ResourceIdentity{ID: "the-service", Type: "cloudrun"},
+		ServiceConfig: serviceData,
+		RootDir:       "config",
+	})
+	assert.NoError(t, err)
+	assert.Len(t, resources, 3)
+
+	migrations, ok := resources[2].(*cloudSqlMigrations)
+	assert.True(t, ok)
+	assert.Equal(t, api.ResourceIdentity{Type: "cloudsql_migrations", ID: "my-pg-db"}, migrations.Identity())
+	assert.Equal(t, []api.ResourceIdentity{{Type: "cloudsql", ID: "my-pg-db"}}, migrations.MigrationTargets())
+	assert.Equal(t, "POSTGRES_13", migrations.Engine)
+	assert.Equal(t, 5432, migrations.Port)
+	assert.Equal(t, filepath.Join("config", "testdata", "cloudsql", "migrations"), migrations.Directory)
+
+	err = migrations.Configure()
+	assert.NoError(t, err)
+	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `output "cloudsql-my-pg-db-private_ip"`)
+	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `value = module.cloudsql-my-pg-db.db_name`)
+}
+
+func Test_Migration_Host_Override(t *testing.T) {
+	host, port, isOverride := migrationHost("my-pg-db", 5432)
+	assert.Equal(t, "", host)
+	assert.Equal(t, "5432", port)
+	assert.False(t, isOverride)
+
+	assert.NoError(t, os.Setenv("XLRTE_DB_MY_PG_DB_HOST", "127.0.0.1"))
+	assert.NoError(t, os.Setenv("XLRTE_DB_MY_PG_DB_PORT", "6543"))
+	defer func() {
+		assert.NoError(t, os.Unsetenv("XLRTE_DB_MY_PG_DB_HOST"))
+		assert.NoError(t, os.Unsetenv("XLRTE_DB_MY_PG_DB_PORT"))
+	}()
+	host, port, isOverride = migrationHost("my-pg-db", 5432)
+	assert.Equal(t, "127.0.0.1", host)
+	assert.Equal(t, "6543", port)
+	assert.True(t, isOverride)
+}
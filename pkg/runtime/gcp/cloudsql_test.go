@@ -92,6 +92,8 @@ func Test_CloudSqlTemplate(t *testing.T) {
 
 	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `disk_size = 10`)
 	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `deletion_protection = true`)
+	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `database_version = "POSTGRES_13"`)
+	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `read_replicas = 0`)
 
 }
 
@@ -111,5 +113,175 @@ func Test_ConfigureResource(t *testing.T) {
 	})
 	assert.Equal(t, cloudRun.Env.Refs, map[string]string{
 		"DB_theDb_HOST": "module.cloudsql-theDb.master_private_ip",
+		"DB_theDb_NAME": "module.cloudsql-theDb.db_name",
 	})
 }
+
+func Test_ConfigureResource_Replicas_And_Users(t *testing.T) {
+	db := &cloudSql{
+		DbName:       "theDb",
+		Port:         3306,
+		ReadReplicas: 1,
+		Databases:    []string{"orders"},
+		Users:        []string{"reporting"},
+	}
+	cloudRun := cloudRunConfig{}
+
+	err := db.ConfigureResource(&cloudRun)
+	assert.NoError(t, err)
+
+	assert.Contains(t, cloudRun.DependsOn, "module.secret-cloudsql-theDb_reporting_PASSWORD")
+	assert.Equal(t, "module.cloudsql-theDb.read_replica_private_ips[0]", cloudRun.Env.Refs["DB_theDb_READ_HOST"])
+	assert.Equal(t, map[string]string{
+		"DB_theDb_PORT":           "3306",
+		"DB_theDb_orders_NAME":    "orders",
+		"DB_theDb_reporting_USER": "reporting",
+	}, cloudRun.Env.Vars)
+	assert.Equal(t, "module.secret-cloudsql-theDb_reporting_PASSWORD.secret_id", cloudRun.Env.Secrets["DB_theDb_reporting_PASSWORD"])
+}
+
+func Test_Can_Read_MySQL_Resources(t *testing.T) {
+	serviceData := getCloudRunBytes(t, filepath.Join("testdata", "cloudsql", "service-mysql.yaml"), "cloudsql")
+	confData := getCloudRunBytes(t, filepath.Join("testdata", "cloudsql", "resources-mysql.yaml"), "cloudsql")
+
+	resource := &cloudSql{secretDeps: map[string]string{}}
+	resources, bindings, err := resource.Load(&api.ResourceDefinition{
+		Name:           "cloudsql",
+		DependedOnBy:   api.ResourceIdentity{ID: "the-service", Type: "cloudrun"},
+		ServiceConfig:  serviceData,
+		ResourceConfig: &confData,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, resources, 2)
+
+	db := resources[1].(*cloudSql)
+	assert.Equal(t, "MYSQL_5_7", db.DBType)
+	assert.Equal(t, 3306, db.Port)
+	assert.True(t, db.HighAvailability)
+	assert.Equal(t, 2, db.ReadReplicas)
+	assert.Equal(t, map[string]string{"max_connections": "200"}, db.Flags)
+	assert.Equal(t, []string{"orders", "billing"}, db.Databases)
+	assert.Equal(t, []string{"reporting"}, db.Users)
+
+	assert.Equal(t, []api.SecretRef{
+		{Name: "PASSWORD", Type: api.Password, Rotate: true},
+		{Name: "USER", Type: api.RandomString},
+		{Name: "reporting_PASSWORD", Type: api.Password, Rotate: true},
+	}, bindings[1].SecretRefs)
+	assert.Equal(t, map[string]string{
+		"cloudsql-my-mysql-db_PASSWORD":           "module.cloudsql-my-mysql-db",
+		"cloudsql-my-mysql-db_reporting_PASSWORD": "module.cloudsql-my-mysql-db",
+	}, resource.secretDeps)
+}
+
+func Test_Database_Versions(t *testing.T) {
+	version, err := toDatabaseVersion("postgres", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "POSTGRES_13", version)
+
+	version, err = toDatabaseVersion("postgres", "15")
+	assert.NoError(t, err)
+	assert.Equal(t, "POSTGRES_15", version)
+
+	version, err = toDatabaseVersion("mysql", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "MYSQL_8_0", version)
+
+	_, err = toDatabaseVersion("mysql", "14")
+	assert.Error(t, err)
+
+	_, err = toDatabaseVersion("dynamodb", "")
+	assert.Error(t, err)
+}
+
+func Test_Invalid_User_Name(t *testing.T) {
+	serviceData := getCloudRunBytes(t, filepath.Join("testdata", "cloudsql", "service-mysql.yaml"), "cloudsql")
+	confData := []byte(`- name: my-mysql-db
+  users:
+  - not-valid
+`)
+
+	resource := &cloudSql{}
+	_, _, err := resource.Load(&api.ResourceDefinition{
+		Name:           "cloudsql",
+		DependedOnBy:   api.ResourceIdentity{ID: "the-service", Type: "cloudrun"},
+		ServiceConfig:  serviceData,
+		ResourceConfig: &confData,
+	})
+	assert.Error(t, err)
+}
+
+func Test_Invalid_Database_Name(t *testing.T) {
+	serviceData := getCloudRunBytes(t, filepath.Join("testdata", "cloudsql", "service-mysql.yaml"), "cloudsql")
+	confData := []byte(`- name: my-mysql-db
+  databases:
+  - "orders\"]"
+`)
+
+	resource := &cloudSql{}
+	_, _, err := resource.Load(&api.ResourceDefinition{
+		Name:           "cloudsql",
+		DependedOnBy:   api.ResourceIdentity{ID: "the-service", Type: "cloudrun"},
+		ServiceConfig:  serviceData,
+		ResourceConfig: &confData,
+	})
+	assert.EqualError(t, err, `cloudsql my-mysql-db: invalid database name 'orders"]', database names must start with a letter and only contain letters, digits and underscores`)
+}
+
+func Test_MySQL_Replicas_Need_Backups(t *testing.T) {
+	serviceData := getCloudRunBytes(t, filepath.Join("testdata", "cloudsql", "service-mysql.yaml"), "cloudsql")
+	for _, config := range []string{"read_replicas: 1", "high_availability: true"} {
+		confData := []byte("- name: my-mysql-db\n  backup_enabled: false\n  " + config + "\n")
+
+		resource := &cloudSql{}
+		_, _, err := resource.Load(&api.ResourceDefinition{
+			Name:           "cloudsql",
+			DependedOnBy:   api.ResourceIdentity{ID: "the-service", Type: "cloudrun"},
+			ServiceConfig:  serviceData,
+			ResourceConfig: &confData,
+		})
+		assert.EqualError(t, err, "cloudsql my-mysql-db: mysql read_replicas and high_availability need backup_enabled", config)
+	}
+}
+
+func Test_CloudSql_Unknown_Network(t *testing.T) {
+	serviceData := getCloudRunBytes(t, filepath.Join("testdata", "cloudsql", "service-network.yaml"), "cloudsql")
+
+	resource := &cloudSql{}
+	_, _, err := resource.Load(&api.ResourceDefinition{
+		Name:          "cloudsql",
+		DependedOnBy:  api.ResourceIdentity{ID: "the-service", Type: "cloudrun"},
+		ServiceConfig: serviceData,
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "network shared is not defined")
+}
+
+func Test_CloudSql_Default_Network_Template(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tf_temp")
+	if err != nil {
+		log.Fatalf("error creating temp dir: %s", err)
+	}
+	defer func() {
+		e := os.RemoveAll(tmpDir)
+		assert.NoError(t, e)
+	}()
+	serviceData := getCloudRunBytes(t, filepath.Join("testdata", "cloudsql", "service.yaml"), "cloudsql")
+
+	resource := &cloudSql{baseDir: tmpDir}
+	resources, _, err := resource.Load(&api.ResourceDefinition{
+		Name:          "cloudsql",
+		DependedOnBy:  api.ResourceIdentity{ID: "the-service", Type: "cloudrun"},
+		ServiceConfig: serviceData,
+	})
+	assert.NoError(t, err)
+	db := resources[1].(*cloudSql)
+	assert.Equal(t, "network", db.NetworkName)
+	assert.True(t, db.CreatePeering)
+
+	assert.NoError(t, db.Configure())
+	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `network_name = "network"`)
+	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `peering_address = ""`)
+	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `peering_prefix_length = 16`)
+	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `create_private_service_access = true`)
+}
@@ -3,6 +3,7 @@ package gcp
 import (
 	_ "embed"
 	"fmt"
+	"strings"
 
 	"github.com/xlrte/core/pkg/api"
 	"gopkg.in/yaml.v2"
@@ -11,20 +12,68 @@ import (
 //go:embed templates/cloudstorage.tf
 var gcsMain string
 
+var gcsStorageClasses = map[string]bool{
+	"STANDARD": true,
+	"NEARLINE": true,
+	"COLDLINE": true,
+	"ARCHIVE":  true,
+}
+
+var gcsEvents = map[string]bool{
+	"OBJECT_FINALIZE":        true,
+	"OBJECT_METADATA_UPDATE": true,
+	"OBJECT_DELETE":          true,
+	"OBJECT_ARCHIVE":         true,
+}
+
 type gcsConfig struct {
 	baseDir           string
-	BucketName        string `yaml:"name"`
-	IsPublic          bool   `yaml:"public"`
-	Access            string `yaml:"access"`
-	Owner             *bool  `yaml:"owner"`
-	Location          string `yaml:"location"`
-	StorageClass      string `yaml:"storage_class"`
-	VersioningEnabled *bool  `yaml:"versioning_enabled"`
+	BucketName        string             `yaml:"name"`
+	IsPublic          bool               `yaml:"public"`
+	Access            string             `yaml:"access"`
+	Owner             *bool              `yaml:"owner"`
+	Location          string             `yaml:"location"`
+	StorageClass      string             `yaml:"storage_class"`
+	VersioningEnabled *bool              `yaml:"versioning_enabled"`
+	UniformAccess     bool               `yaml:"uniform_access"`
+	Lifecycle         []*gcsLifecycle    `yaml:"lifecycle"`
+	CORS              []*gcsCORS         `yaml:"cors"`
+	Retention         *gcsRetention      `yaml:"retention"`
+	Notifications     []*gcsNotification `yaml:"notifications"`
+	Notify            string             `yaml:"notify"` // subscribe the service to events of the bucket, such as object-finalize
+}
+
+// gcsLifecycle deletes objects, or transitions them to another storage class, once they are `age` days old.
+// `keep_versions` deletes noncurrent versions once there are more than that many newer versions.
+type gcsLifecycle struct {
+	Action       string `yaml:"action"` // delete or transition
+	StorageClass string `yaml:"storage_class"`
+	Age          int    `yaml:"age"`
+	KeepVersions int    `yaml:"keep_versions"`
+}
+
+type gcsCORS struct {
+	Origins         []string `yaml:"origins"`
+	Methods         []string `yaml:"methods"`
+	ResponseHeaders []string `yaml:"response_headers"`
+	MaxAge          int      `yaml:"max_age_seconds"`
+}
+
+type gcsRetention struct {
+	PeriodDays int  `yaml:"period_days"`
+	Locked     bool `yaml:"locked"` // a locked policy can never be removed or shortened
+}
+
+// gcsNotification publishes changes of objects to a topic, which a service of the deployment must declare.
+type gcsNotification struct {
+	Topic  string   `yaml:"topic"`
+	Events []string `yaml:"events"` // all events if empty
+	Prefix string   `yaml:"prefix"`
 }
 
 type gcsIAM struct {
 	Bucket string
-	Role   string
+	Roles  []string
 }
 
 func (rt *gcsConfig) Load(d *api.ResourceDefinition) ([]api.Resource, []api.DependencyBinding, error) {
@@ -72,29 +121,139 @@ func (rt *gcsConfig) Load(d *api.ResourceDefinition) ([]api.Resource, []api.Depe
 				if res.VersioningEnabled != nil {
 					dep.VersioningEnabled = res.VersioningEnabled
 				}
+				dep.UniformAccess = res.UniformAccess
+				dep.Lifecycle = res.Lifecycle
+				dep.CORS = res.CORS
+				dep.Retention = res.Retention
+				dep.Notifications = res.Notifications
 				break
 			}
 		}
-		dep.baseDir = rt.baseDir
-		iamRole := gcsIAM{dep.BucketName, "roles/storage.objectViewer"}
-		if ownership == api.Owner || ownership == api.ReadWrite {
-			iamRole = gcsIAM{dep.BucketName, "roles/storage.objectAdmin"}
+		err = dep.validate()
+		if err != nil {
+			return nil, nil, err
 		}
+		dep.baseDir = rt.baseDir
+		iamRole := gcsIAM{dep.BucketName, gcsRoles(ownership)}
 
 		bindings = append(bindings, api.DependencyBinding{
 			DependedOnBy: d.DependedOnBy,
 			Privileges:   ownership,
 			Identity:     dep.Identity(),
 			Config:       &iamRole,
+			// notifications can only be added to buckets created by the deployment
+			Declared: dep.Notify != "",
 		})
+		if dep.Notify != "" {
+			topic, notifyBindings, e := dep.notify(d.DependedOnBy, rt.baseDir)
+			if e != nil {
+				return nil, nil, e
+			}
+			rs = append(rs, topic)
+			bindings = append(bindings, notifyBindings...)
+		}
 		if ownership == api.Owner {
 			rs = append(rs, dep)
+			for _, notification := range dep.Notifications {
+				bindings = append(bindings, api.DependencyBinding{
+					DependedOnBy: dep.Identity(),
+					Privileges:   api.ReadOnly,
+					Identity:     api.ResourceIdentity{Type: "pubsub", ID: notification.Topic},
+					Declared:     true,
+				})
+			}
 		}
 	}
 
 	return rs, bindings, nil
 }
 
+// notify creates a topic for an event of the bucket, which the bucket publishes to and the service subscribes to.
+// Services notified of the same event share the topic, each with its own subscription.
+func (r *gcsConfig) notify(service api.ResourceIdentity, baseDir string) (*pubSubConfig, []api.DependencyBinding, error) {
+	event := strings.ToUpper(strings.ReplaceAll(r.Notify, "-", "_"))
+	if !gcsEvents[event] {
+		return nil, nil, fmt.Errorf("cloudstorage %s: invalid notify '%s', valid events are object-finalize, object-metadata-update, object-delete and object-archive", r.BucketName, r.Notify)
+	}
+	topic := &pubSubConfig{baseDir: baseDir, TopicName: fmt.Sprintf("%s_%s", r.BucketName, strings.ToLower(event))}
+	sub := defaultConf()
+	sub.TopicName = topic.TopicName
+
+	return topic, []api.DependencyBinding{
+		{
+			DependedOnBy: r.Identity(),
+			Privileges:   api.ReadOnly,
+			Identity:     topic.Identity(),
+			Config:       &gcsNotification{Topic: topic.TopicName, Events: []string{event}},
+		},
+		{
+			DependedOnBy: service,
+			Privileges:   api.ReadOnly,
+			Identity:     topic.Identity(),
+			Config:       sub,
+		},
+	}, nil
+}
+
+func (r *gcsConfig) validate() error {
+	for _, rule := range r.Lifecycle {
+		switch rule.Action {
+		case "delete":
+			if rule.Age <= 0 && rule.KeepVersions <= 0 {
+				return fmt.Errorf("cloudstorage %s: a delete lifecycle rule needs age or keep_versions", r.BucketName)
+			}
+			if rule.KeepVersions > 0 && !*r.VersioningEnabled {
+				return fmt.Errorf("cloudstorage %s: keep_versions needs versioning_enabled", r.BucketName)
+			}
+		case "transition":
+			if !gcsStorageClasses[rule.StorageClass] {
+				return fmt.Errorf("cloudstorage %s: invalid lifecycle storage_class '%s', valid classes are STANDARD, NEARLINE, COLDLINE and ARCHIVE", r.BucketName, rule.StorageClass)
+			}
+			if rule.Age <= 0 {
+				return fmt.Errorf("cloudstorage %s: a transition lifecycle rule needs age", r.BucketName)
+			}
+		default:
+			return fmt.Errorf("cloudstorage %s: invalid lifecycle action '%s', valid actions are delete and transition", r.BucketName, rule.Action)
+		}
+	}
+	for _, cors := range r.CORS {
+		if len(cors.Origins) == 0 || len(cors.Methods) == 0 {
+			return fmt.Errorf("cloudstorage %s: cors rules need origins and methods", r.BucketName)
+		}
+	}
+	if r.Retention != nil {
+		if r.Retention.PeriodDays <= 0 {
+			return fmt.Errorf("cloudstorage %s: retention period_days must be at least 1", r.BucketName)
+		}
+		if *r.VersioningEnabled {
+			return fmt.Errorf("cloudstorage %s: a retention policy can't be combined with versioning_enabled", r.BucketName)
+		}
+	}
+	for _, notification := range r.Notifications {
+		if notification.Topic == "" {
+			return fmt.Errorf("cloudstorage %s: notifications need a topic", r.BucketName)
+		}
+		for _, event := range notification.Events {
+			if !gcsEvents[event] {
+				return fmt.Errorf("cloudstorage %s: invalid notification event '%s', valid events are OBJECT_FINALIZE, OBJECT_METADATA_UPDATE, OBJECT_DELETE and OBJECT_ARCHIVE", r.BucketName, event)
+			}
+		}
+	}
+	return nil
+}
+
+// gcsRoles maps privileges to the narrowest object roles that grant them.
+func gcsRoles(privileges api.DependencyPrivileges) []string {
+	switch privileges {
+	case api.Owner:
+		return []string{"roles/storage.objectAdmin"}
+	case api.ReadWrite:
+		return []string{"roles/storage.objectCreator", "roles/storage.objectViewer"}
+	default:
+		return []string{"roles/storage.objectViewer"}
+	}
+}
+
 func (r *gcsConfig) Configure() error {
 	return applyTerraformTemplates(r.baseDir, []crFile{
 		{"main.tf", gcsMain},
@@ -118,3 +277,16 @@ func (iam *gcsIAM) ConfigureResource(resource api.Resource) error {
 	}
 	return nil
 }
+
+func (notification *gcsNotification) ConfigureResource(resource api.Resource) error {
+	bucket, ok := resource.(*gcsConfig)
+	if ok {
+		for _, existing := range bucket.Notifications {
+			if existing.Topic == notification.Topic {
+				return nil
+			}
+		}
+		bucket.Notifications = append(bucket.Notifications, notification)
+	}
+	return nil
+}
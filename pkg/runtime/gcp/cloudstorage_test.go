@@ -1,6 +1,9 @@
 package gcp
 
 import (
+	"io/ioutil"
+	"log"
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -36,27 +39,27 @@ func Test_CloudStorage_Can_Read_Config(t *testing.T) {
 	assert.Equal(t, api.ResourceIdentity{ID: "the-service", Type: "cloudrun"}, bindings[0].DependedOnBy)
 	assert.Equal(t, api.ResourceIdentity{Type: "cloudstorage", ID: "foo-bucket"}, bindings[0].Identity)
 	assert.Equal(t, api.ReadOnly, bindings[0].Privileges)
-	assert.Equal(t, &gcsIAM{"foo-bucket", "roles/storage.objectViewer"}, bindings[0].Config)
+	assert.Equal(t, &gcsIAM{"foo-bucket", []string{"roles/storage.objectViewer"}}, bindings[0].Config)
 
 	assert.Equal(t, api.ResourceIdentity{ID: "the-service", Type: "cloudrun"}, bindings[1].DependedOnBy)
 	assert.Equal(t, api.ResourceIdentity{Type: "cloudstorage", ID: "bar"}, bindings[1].Identity)
 	assert.Equal(t, api.Owner, bindings[1].Privileges)
-	assert.Equal(t, &gcsIAM{"bar", "roles/storage.objectAdmin"}, bindings[1].Config)
+	assert.Equal(t, &gcsIAM{"bar", []string{"roles/storage.objectAdmin"}}, bindings[1].Config)
 
 	assert.Equal(t, api.ResourceIdentity{ID: "the-service", Type: "cloudrun"}, bindings[2].DependedOnBy)
 	assert.Equal(t, api.ResourceIdentity{Type: "cloudstorage", ID: "baz"}, bindings[2].Identity)
 	assert.Equal(t, api.Owner, bindings[2].Privileges)
-	assert.Equal(t, &gcsIAM{"baz", "roles/storage.objectAdmin"}, bindings[2].Config)
+	assert.Equal(t, &gcsIAM{"baz", []string{"roles/storage.objectAdmin"}}, bindings[2].Config)
 
 	assert.Equal(t, api.ResourceIdentity{ID: "the-service", Type: "cloudrun"}, bindings[3].DependedOnBy)
 	assert.Equal(t, api.ResourceIdentity{Type: "cloudstorage", ID: "bazf"}, bindings[3].Identity)
 	assert.Equal(t, api.ReadWrite, bindings[3].Privileges)
-	assert.Equal(t, &gcsIAM{"bazf", "roles/storage.objectAdmin"}, bindings[3].Config)
+	assert.Equal(t, &gcsIAM{"bazf", []string{"roles/storage.objectCreator", "roles/storage.objectViewer"}}, bindings[3].Config)
 
 }
 
 func Test_IAM_Configures_Resource(t *testing.T) {
-	iam := &gcsIAM{"baz", "roles/storage.objectAdmin"}
+	iam := &gcsIAM{"baz", []string{"roles/storage.objectAdmin"}}
 	cloudRun := cloudRunConfig{}
 
 	err := iam.ConfigureResource(&cloudRun)
@@ -65,3 +68,110 @@ func Test_IAM_Configures_Resource(t *testing.T) {
 	assert.Equal(t, cloudRun.CloudStorage, []*gcsIAM{iam})
 
 }
+
+func Test_CloudStorage_Policies(t *testing.T) {
+	serviceData := getCloudRunBytes(t, filepath.Join("testdata", "cloudstorage", "service-policies.yaml"), "cloudstorage")
+	confData := getCloudRunBytes(t, filepath.Join("testdata", "cloudstorage", "resources-policies.yaml"), "cloudstorage")
+
+	tmpDir, err := ioutil.TempDir("", "tf_temp")
+	if err != nil {
+		log.Fatalf("error creating temp dir: %s", err)
+	}
+	defer func() {
+		e := os.RemoveAll(tmpDir)
+		assert.NoError(t, e)
+	}()
+
+	resource := &gcsConfig{baseDir: tmpDir}
+	resources, bindings, err := resource.Load(&api.ResourceDefinition{
+		Name:           "cloudstorage",
+		DependedOnBy:   api.ResourceIdentity{ID: "the-service", Type: "cloudrun"},
+		ServiceConfig:  serviceData,
+		ResourceConfig: &confData,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, resources, 2)
+	assert.Len(t, bindings, 3)
+
+	uploads := resources[0].(*gcsConfig)
+	assert.True(t, uploads.UniformAccess)
+	assert.Len(t, uploads.Lifecycle, 2)
+	assert.Equal(t, []string{"https://example.com"}, uploads.CORS[0].Origins)
+	assert.Equal(t, api.DependencyBinding{
+		DependedOnBy: api.ResourceIdentity{Type: "cloudstorage", ID: "uploads"},
+		Privileges:   api.ReadOnly,
+		Identity:     api.ResourceIdentity{Type: "pubsub", ID: "upload_events"},
+		Declared:     true,
+	}, bindings[1])
+
+	assert.NoError(t, uploads.Configure())
+	file := filepath.Join(tmpDir, "main.tf")
+	assertInFile(t, file, `uniform_access = true`)
+	assertInFile(t, file, `action = "SetStorageClass"
+      storage_class = "NEARLINE"
+      age = 30
+      num_newer_versions = null`)
+	assertInFile(t, file, `action = "Delete"
+      storage_class = null
+      age = null
+      num_newer_versions = 3`)
+	assertInFile(t, file, `methods = ["PUT","GET",]`)
+	assertInFile(t, file, `retention = null`)
+	assertInFile(t, file, `topic = "upload_events"`)
+	assertInFile(t, file, `depends_on = [module.pubsub-upload_events,]`)
+
+	archive := resources[1].(*gcsConfig)
+	assert.NoError(t, archive.Configure())
+	assertInFile(t, file, `period_days = 365`)
+}
+
+func Test_CloudStorage_Invalid_Policies(t *testing.T) {
+	disabled := false
+	enabled := true
+	invalid := []*gcsConfig{
+		{BucketName: "b", VersioningEnabled: &disabled, Lifecycle: []*gcsLifecycle{{Action: "archive", Age: 1}}},
+		{BucketName: "b", VersioningEnabled: &disabled, Lifecycle: []*gcsLifecycle{{Action: "delete"}}},
+		{BucketName: "b", VersioningEnabled: &disabled, Lifecycle: []*gcsLifecycle{{Action: "delete", KeepVersions: 2}}},
+		{BucketName: "b", VersioningEnabled: &disabled, Lifecycle: []*gcsLifecycle{{Action: "transition", StorageClass: "COLD", Age: 1}}},
+		{BucketName: "b", VersioningEnabled: &disabled, CORS: []*gcsCORS{{Origins: []string{"*"}}}},
+		{BucketName: "b", VersioningEnabled: &enabled, Retention: &gcsRetention{PeriodDays: 1}},
+		{BucketName: "b", VersioningEnabled: &disabled, Notifications: []*gcsNotification{{Topic: "t", Events: []string{"OBJECT_CREATE"}}}},
+	}
+	for _, config := range invalid {
+		assert.Error(t, config.validate())
+	}
+}
+
+func Test_CloudStorage_Notify(t *testing.T) {
+	serviceData := getCloudRunBytes(t, filepath.Join("testdata", "cloudstorage", "service-notify.yaml"), "cloudstorage")
+
+	resource := &gcsConfig{}
+	resources, bindings, err := resource.Load(&api.ResourceDefinition{
+		Name:          "cloudstorage",
+		DependedOnBy:  api.ResourceIdentity{ID: "the-service", Type: "cloudrun"},
+		ServiceConfig: serviceData,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, resources, 2)
+	assert.Len(t, bindings, 3)
+
+	topic := api.ResourceIdentity{Type: "pubsub", ID: "uploads_object_finalize"}
+	assert.Equal(t, topic, resources[0].Identity())
+	assert.True(t, bindings[0].Declared)
+
+	bucket := resources[1].(*gcsConfig)
+	assert.Equal(t, bucket.Identity(), bindings[1].DependedOnBy)
+	assert.NoError(t, bindings[1].Config.ConfigureResource(bucket))
+	assert.NoError(t, bindings[1].Config.ConfigureResource(bucket))
+	assert.Equal(t, []*gcsNotification{{Topic: "uploads_object_finalize", Events: []string{"OBJECT_FINALIZE"}}}, bucket.Notifications)
+
+	cloudRun := &cloudRunConfig{}
+	assert.Equal(t, topic, bindings[2].Identity)
+	assert.NoError(t, bindings[2].Config.ConfigureResource(cloudRun))
+	assert.Equal(t, "uploads_object_finalize", cloudRun.SubscribeTopics[0].TopicName)
+	assert.Equal(t, 20, cloudRun.SubscribeTopics[0].AckDeadline)
+
+	bucket.Notify = "object-create"
+	_, _, err = bucket.notify(api.ResourceIdentity{}, "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,179 @@
+package gcp
+
+import (
+	_ "embed"
+	"fmt"
+	"regexp"
+
+	"github.com/xlrte/core/pkg/api"
+	"gopkg.in/yaml.v2"
+)
+
+//go:embed templates/cloudtasks.tf
+var cloudTasksMain string
+
+var queueName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9-]{0,62}$`)
+
+// cloudTasksQueue is a queue services enqueue tasks to, with its rate limits and retries configured in resources.yaml.
+type cloudTasksQueue struct {
+	baseDir                 string
+	QueueName               string `yaml:"name"`
+	Target                  string `yaml:"target"` // the Cloud Run service tasks are sent to, if any
+	MaxDispatchesPerSecond  int    `yaml:"max_dispatches_per_second"`
+	MaxConcurrentDispatches int    `yaml:"max_concurrent_dispatches"`
+	MaxAttempts             int    `yaml:"max_attempts"` // -1 retries indefinitely
+	MinBackoff              int    `yaml:"min_backoff_seconds"`
+	MaxBackoff              int    `yaml:"max_backoff_seconds"`
+	MaxRetryDuration        int    `yaml:"max_retry_duration_seconds"` // 0 is unlimited
+}
+
+type cloudTasksEnqueuer struct {
+	Queue  string
+	Target string
+}
+
+func (rt *cloudTasksQueue) Load(d *api.ResourceDefinition) ([]api.Resource, []api.DependencyBinding, error) {
+	var queues []*cloudTasksQueue
+	var resources []*cloudTasksQueue
+	var rs []api.Resource
+	var bindings []api.DependencyBinding
+
+	err := yaml.Unmarshal(d.ServiceConfig, &queues)
+	if err != nil {
+		return nil, nil, err
+	}
+	if d.ResourceConfig != nil {
+		err = yaml.Unmarshal(*d.ResourceConfig, &resources)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, queue := range queues {
+		target := queue.Target
+		*queue = cloudTasksQueue{
+			QueueName:               queue.QueueName,
+			MaxDispatchesPerSecond:  500,
+			MaxConcurrentDispatches: 1000,
+			MaxAttempts:             100,
+			MinBackoff:              1,
+			MaxBackoff:              3600,
+		}
+		for _, r := range resources {
+			if r.QueueName == queue.QueueName {
+				queue.applySettings(r)
+				break
+			}
+		}
+		err = queue.validate()
+		if err != nil {
+			return nil, nil, err
+		}
+		queue.baseDir = rt.baseDir
+		rs = append(rs, queue)
+
+		bindings = append(bindings, api.DependencyBinding{
+			DependedOnBy: d.DependedOnBy,
+			Privileges:   api.Owner,
+			Identity:     queue.Identity(),
+			Config:       &cloudTasksEnqueuer{Queue: queue.QueueName, Target: target},
+		})
+		if target != "" {
+			bindings = append(bindings, api.DependencyBinding{
+				DependedOnBy: d.DependedOnBy,
+				Privileges:   api.ReadWrite,
+				Identity:     api.ResourceIdentity{Type: "cloudrun", ID: target},
+				Declared:     true,
+			})
+		}
+	}
+	return rs, bindings, nil
+}
+
+func (r *cloudTasksQueue) applySettings(settings *cloudTasksQueue) {
+	if settings.MaxDispatchesPerSecond != 0 {
+		r.MaxDispatchesPerSecond = settings.MaxDispatchesPerSecond
+	}
+	if settings.MaxConcurrentDispatches != 0 {
+		r.MaxConcurrentDispatches = settings.MaxConcurrentDispatches
+	}
+	if settings.MaxAttempts != 0 {
+		r.MaxAttempts = settings.MaxAttempts
+	}
+	if settings.MinBackoff != 0 {
+		r.MinBackoff = settings.MinBackoff
+	}
+	if settings.MaxBackoff != 0 {
+		r.MaxBackoff = settings.MaxBackoff
+	}
+	r.MaxRetryDuration = settings.MaxRetryDuration
+}
+
+func (r *cloudTasksQueue) validate() error {
+	if !queueName.MatchString(r.QueueName) {
+		return fmt.Errorf("invalid cloudtasks queue name '%s', names may only contain letters, digits and '-'", r.QueueName)
+	}
+	if r.MaxDispatchesPerSecond < 1 || r.MaxDispatchesPerSecond > 500 {
+		return fmt.Errorf("cloudtasks %s: max_dispatches_per_second must be between 1 and 500", r.QueueName)
+	}
+	if r.MaxConcurrentDispatches < 1 || r.MaxConcurrentDispatches > 5000 {
+		return fmt.Errorf("cloudtasks %s: max_concurrent_dispatches must be between 1 and 5000", r.QueueName)
+	}
+	if r.MaxAttempts < -1 {
+		return fmt.Errorf("cloudtasks %s: max_attempts must be -1 (unlimited) or more", r.QueueName)
+	}
+	if r.MinBackoff < 0 || r.MaxBackoff < r.MinBackoff {
+		return fmt.Errorf("cloudtasks %s: max_backoff_seconds must not be less than min_backoff_seconds", r.QueueName)
+	}
+	if r.MaxRetryDuration < 0 {
+		return fmt.Errorf("cloudtasks %s: max_retry_duration_seconds must not be negative", r.QueueName)
+	}
+	return nil
+}
+
+func (r *cloudTasksQueue) Configure() error {
+	return applyTerraformTemplates(r.baseDir, []crFile{
+		{"cloudtasks.tf", cloudTasksMain},
+	}, r)
+}
+
+func (r *cloudTasksQueue) Name() string {
+	return "cloudtasks"
+}
+
+func (r *cloudTasksQueue) Identity() api.ResourceIdentity {
+	return api.ResourceIdentity{Type: r.Name(), ID: r.QueueName}
+}
+
+// ConfigureResource lets the service enqueue tasks. Tasks for a target service carry an OIDC token of the
+// enqueuing service's own account, which is therefore allowed to invoke the target.
+func (e *cloudTasksEnqueuer) ConfigureResource(resource api.Resource) error {
+	cloudRun, ok := resource.(*cloudRunConfig)
+	if ok {
+		queue := toDependency(fmt.Sprintf("cloudtasks-%s", e.Queue))
+		if cloudRun.Env.Refs == nil {
+			cloudRun.Env.Refs = make(map[string]string)
+		}
+		cloudRun.Env.Refs[fmt.Sprintf("TASKS_%s_NAME", e.Queue)] = queue + ".id"
+		cloudRun.DependsOn = append(cloudRun.DependsOn, queue)
+		cloudRun.TaskQueues = append(cloudRun.TaskQueues, e.Queue)
+		if e.Target != "" {
+			cloudRun.Env.Refs[fmt.Sprintf("TASKS_%s_TARGET_URL", e.Queue)] = fmt.Sprintf("module.cloudrun-%s.cloud_run_endpoint", e.Target)
+			if cloudRun.Env.Vars == nil {
+				cloudRun.Env.Vars = make(map[string]string)
+			}
+			cloudRun.Env.Vars[fmt.Sprintf("TASKS_%s_SERVICE_ACCOUNT", e.Queue)] = cloudRun.ServiceAccount
+			cloudRun.InvokeServices = appendUnique(cloudRun.InvokeServices, e.Target)
+		}
+	}
+	return nil
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,80 @@
+package gcp
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlrte/core/pkg/api"
+)
+
+func Test_CloudTasks_Loads_Queues(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tf_temp")
+	if err != nil {
+		log.Fatalf("error creating temp dir: %s", err)
+	}
+	defer func() {
+		e := os.RemoveAll(tmpDir)
+		assert.NoError(t, e)
+	}()
+	serviceData := getCloudRunBytes(t, filepath.Join("testdata", "cloudtasks", "service.yaml"), "cloudtasks")
+	confData := getCloudRunBytes(t, filepath.Join("testdata", "cloudtasks", "resources.yaml"), "cloudtasks")
+
+	loader := &cloudTasksQueue{baseDir: tmpDir}
+	resources, bindings, err := loader.Load(&api.ResourceDefinition{
+		Name:           "cloudtasks",
+		DependedOnBy:   api.ResourceIdentity{ID: "the-service", Type: "cloudrun"},
+		ServiceConfig:  serviceData,
+		ResourceConfig: &confData,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, resources, 2)
+	assert.Len(t, bindings, 3)
+	assert.Equal(t, api.ResourceIdentity{Type: "cloudrun", ID: "mailer"}, bindings[1].Identity)
+	assert.True(t, bindings[1].Declared)
+
+	emails := resources[0].(*cloudTasksQueue)
+	assert.Equal(t, 10, emails.MaxDispatchesPerSecond)
+	assert.Equal(t, 3600, emails.MaxRetryDuration)
+	reports := resources[1].(*cloudTasksQueue)
+	assert.Equal(t, 500, reports.MaxDispatchesPerSecond)
+	assert.Equal(t, 100, reports.MaxAttempts)
+
+	assert.NoError(t, emails.Configure())
+	file := filepath.Join(tmpDir, "main.tf")
+	assertInFile(t, file, `module "cloudtasks-emails"`)
+	assertInFile(t, file, `max_concurrent_dispatches = 5`)
+	assertInFile(t, file, `max_backoff_seconds = 600`)
+
+	cloudRun := &cloudRunConfig{ServiceName: "the-service", ServiceAccount: "the-service-prod@p.iam.gserviceaccount.com"}
+	assert.NoError(t, bindings[0].Config.ConfigureResource(cloudRun))
+	assert.NoError(t, bindings[2].Config.ConfigureResource(cloudRun))
+	assert.Equal(t, "module.cloudtasks-emails.id", cloudRun.Env.Refs["TASKS_emails_NAME"])
+	assert.Equal(t, "module.cloudrun-mailer.cloud_run_endpoint", cloudRun.Env.Refs["TASKS_emails_TARGET_URL"])
+	assert.Equal(t, "the-service-prod@p.iam.gserviceaccount.com", cloudRun.Env.Vars["TASKS_emails_SERVICE_ACCOUNT"])
+	assert.Equal(t, "module.cloudtasks-reports.id", cloudRun.Env.Refs["TASKS_reports_NAME"])
+	assert.Equal(t, []string{"emails", "reports"}, cloudRun.TaskQueues)
+	assert.Equal(t, []string{"mailer"}, cloudRun.InvokeServices)
+}
+
+func Test_CloudTasks_Validation(t *testing.T) {
+	valid := cloudTasksQueue{QueueName: "q", MaxDispatchesPerSecond: 1, MaxConcurrentDispatches: 1, MaxAttempts: -1, MinBackoff: 1, MaxBackoff: 1}
+	assert.NoError(t, valid.validate())
+
+	invalid := []func(q *cloudTasksQueue){
+		func(q *cloudTasksQueue) { q.QueueName = "my_queue" },
+		func(q *cloudTasksQueue) { q.MaxDispatchesPerSecond = 501 },
+		func(q *cloudTasksQueue) { q.MaxConcurrentDispatches = 0 },
+		func(q *cloudTasksQueue) { q.MaxAttempts = -2 },
+		func(q *cloudTasksQueue) { q.MaxBackoff = 0 },
+		func(q *cloudTasksQueue) { q.MaxRetryDuration = -1 },
+	}
+	for _, change := range invalid {
+		queue := valid
+		change(&queue)
+		assert.Error(t, queue.validate())
+	}
+}
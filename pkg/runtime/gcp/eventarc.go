@@ -0,0 +1,123 @@
+package gcp
+
+import (
+	_ "embed"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/xlrte/core/pkg/api"
+	"gopkg.in/yaml.v2"
+)
+
+//go:embed templates/eventarc.tf
+var eventarcMain string
+
+var triggerName = regexp.MustCompile(`^[a-z][a-z0-9-]*[a-z0-9]$`)
+
+// eventarcEvents lists the supported event types with the filters each of them requires.
+var eventarcEvents = map[string][]string{
+	"google.cloud.audit.log.v1.written":              {"serviceName", "methodName"},
+	"google.cloud.storage.object.v1.finalized":       {"bucket"},
+	"google.cloud.storage.object.v1.deleted":         {"bucket"},
+	"google.cloud.storage.object.v1.archived":        {"bucket"},
+	"google.cloud.storage.object.v1.metadataUpdated": {"bucket"},
+}
+
+// eventarcTrigger delivers Cloud Audit Log and direct GCP events to the Cloud Run service that depends on it.
+// Audit log events are only emitted for services whose Data Access audit logs are enabled.
+type eventarcTrigger struct {
+	baseDir     string
+	TriggerName string            `yaml:"name"`
+	Event       string            `yaml:"event"`
+	Filters     map[string]string `yaml:"filters"`
+	Path        string            `yaml:"path"`
+	Location    string            `yaml:"location"` // defaults to the region, storage events must use the location of the bucket
+	Service     string            `yaml:"-"`
+	Bucket      string            `yaml:"-"` // the cloudstorage resource storage events are emitted by
+}
+
+// StorageEvent is set for Cloud Storage events, which need the storage service agent to publish them.
+func (r *eventarcTrigger) StorageEvent() bool {
+	return strings.HasPrefix(r.Event, "google.cloud.storage.")
+}
+
+func (rt *eventarcTrigger) Load(d *api.ResourceDefinition) ([]api.Resource, []api.DependencyBinding, error) {
+	var triggers []*eventarcTrigger
+	var rs []api.Resource
+	var bindings []api.DependencyBinding
+
+	err := yaml.Unmarshal(d.ServiceConfig, &triggers)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, trigger := range triggers {
+		err = trigger.validate()
+		if err != nil {
+			return nil, nil, err
+		}
+		trigger.baseDir = rt.baseDir
+		trigger.Service = d.DependedOnBy.ID
+		rs = append(rs, trigger)
+		bindings = append(bindings, api.DependencyBinding{
+			DependedOnBy: d.DependedOnBy,
+			Privileges:   api.Owner,
+			Identity:     trigger.Identity(),
+		})
+		if bucket, found := trigger.Filters["bucket"]; found {
+			// buckets are suffixed with the environment, as in modules/cloudstorage
+			trigger.Filters["bucket"] = bucket + "-${var.environment}"
+			trigger.Bucket = bucket
+			// the bucket must exist before the trigger filtering on it can be created
+			bindings = append(bindings, api.DependencyBinding{
+				DependedOnBy: trigger.Identity(),
+				Privileges:   api.ReadOnly,
+				Identity:     api.ResourceIdentity{Type: "cloudstorage", ID: bucket},
+				Declared:     true,
+			})
+		}
+	}
+	return rs, bindings, nil
+}
+
+func (r *eventarcTrigger) validate() error {
+	if !triggerName.MatchString(r.TriggerName) || len(r.TriggerName) > 15 {
+		return fmt.Errorf("invalid eventarc trigger name '%s', names must start with a letter, only contain lower case letters, digits and '-' and be at most 15 characters", r.TriggerName)
+	}
+	required, found := eventarcEvents[r.Event]
+	if !found {
+		supported := []string{}
+		for event := range eventarcEvents {
+			supported = append(supported, event)
+		}
+		sort.Strings(supported)
+		return fmt.Errorf("eventarc %s: unsupported event type '%s', supported types are %s", r.TriggerName, r.Event, strings.Join(supported, ", "))
+	}
+	for _, filter := range required {
+		if r.Filters[filter] == "" {
+			return fmt.Errorf("eventarc %s: event type %s needs the filter %s", r.TriggerName, r.Event, filter)
+		}
+	}
+	if _, found := r.Filters["type"]; found {
+		return fmt.Errorf("eventarc %s: the type filter is set by event", r.TriggerName)
+	}
+	if r.Path != "" && !strings.HasPrefix(r.Path, "/") {
+		return fmt.Errorf("eventarc %s: path must start with '/'", r.TriggerName)
+	}
+	return nil
+}
+
+func (r *eventarcTrigger) Configure() error {
+	return applyTerraformTemplates(r.baseDir, []crFile{
+		{"eventarc.tf", eventarcMain},
+	}, r)
+}
+
+func (r *eventarcTrigger) Name() string {
+	return "eventarc"
+}
+
+func (r *eventarcTrigger) Identity() api.ResourceIdentity {
+	return api.ResourceIdentity{Type: r.Name(), ID: r.TriggerName}
+}
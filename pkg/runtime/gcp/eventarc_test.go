@@ -0,0 +1,75 @@
+package gcp
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlrte/core/pkg/api"
+)
+
+func Test_Eventarc_Loads_Triggers(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tf_temp")
+	if err != nil {
+		log.Fatalf("error creating temp dir: %s", err)
+	}
+	defer func() {
+		e := os.RemoveAll(tmpDir)
+		assert.NoError(t, e)
+	}()
+	serviceData := getCloudRunBytes(t, filepath.Join("testdata", "eventarc", "service.yaml"), "eventarc")
+
+	loader := &eventarcTrigger{baseDir: tmpDir}
+	resources, bindings, err := loader.Load(&api.ResourceDefinition{
+		Name:          "eventarc",
+		DependedOnBy:  api.ResourceIdentity{ID: "the-service", Type: "cloudrun"},
+		ServiceConfig: serviceData,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, resources, 2)
+	assert.Len(t, bindings, 3)
+	assert.Equal(t, api.ResourceIdentity{Type: "eventarc", ID: "on-upload"}, bindings[0].Identity)
+	assert.Equal(t, api.DependencyBinding{
+		DependedOnBy: api.ResourceIdentity{Type: "eventarc", ID: "on-upload"},
+		Privileges:   api.ReadOnly,
+		Identity:     api.ResourceIdentity{Type: "cloudstorage", ID: "uploads"},
+		Declared:     true,
+	}, bindings[1])
+
+	upload := resources[0].(*eventarcTrigger)
+	assert.Equal(t, "the-service", upload.Service)
+	assert.True(t, upload.StorageEvent())
+	assert.NoError(t, upload.Configure())
+	file := filepath.Join(tmpDir, "main.tf")
+	assertInFile(t, file, `module "eventarc-on-upload"`)
+	assertInFile(t, file, `"bucket" = "uploads-${var.environment}"`)
+	assertInFile(t, file, `location = "us"`)
+	assertInFile(t, file, `path = "/events/upload"`)
+	assertInFile(t, file, `storage_event = true`)
+	assertInFile(t, file, `depends_on = [module.cloudrun-the-service, module.cloudstorage-uploads]`)
+
+	audit := resources[1].(*eventarcTrigger)
+	assert.False(t, audit.StorageEvent())
+	assert.NoError(t, audit.Configure())
+	assertInFile(t, file, `location = var.region`)
+	assertInFile(t, file, `depends_on = [module.cloudrun-the-service]`)
+	assertInFile(t, file, `"methodName" = "cloudsql.instances.create"`)
+}
+
+func Test_Eventarc_Validation(t *testing.T) {
+	invalid := []*eventarcTrigger{
+		{TriggerName: "Upload", Event: "google.cloud.storage.object.v1.finalized", Filters: map[string]string{"bucket": "b"}},
+		{TriggerName: "a-very-long-trigger-name", Event: "google.cloud.storage.object.v1.finalized", Filters: map[string]string{"bucket": "b"}},
+		{TriggerName: "upload", Event: "google.cloud.storage.object.v1.created", Filters: map[string]string{"bucket": "b"}},
+		{TriggerName: "upload", Event: "google.cloud.storage.object.v1.finalized"},
+		{TriggerName: "audit", Event: "google.cloud.audit.log.v1.written", Filters: map[string]string{"serviceName": "run.googleapis.com"}},
+		{TriggerName: "upload", Event: "google.cloud.storage.object.v1.finalized", Filters: map[string]string{"bucket": "b", "type": "x"}},
+		{TriggerName: "upload", Event: "google.cloud.storage.object.v1.finalized", Filters: map[string]string{"bucket": "b"}, Path: "events"},
+	}
+	for _, trigger := range invalid {
+		assert.Error(t, trigger.validate(), trigger.TriggerName)
+	}
+}
@@ -0,0 +1,115 @@
+package gcp
+
+import (
+	_ "embed"
+	"fmt"
+
+	"github.com/xlrte/core/pkg/api"
+	"gopkg.in/yaml.v2"
+)
+
+//go:embed templates/firestore.tf
+var firestoreMain string
+
+var firestoreTypes = map[string]bool{
+	"FIRESTORE_NATIVE": true,
+	"DATASTORE_MODE":   true,
+}
+
+// firestoreConfig is the (default) Firestore database of the environment's project.
+type firestoreConfig struct {
+	baseDir      string
+	Access       string `yaml:"access"`
+	Owner        *bool  `yaml:"owner"`
+	Location     string `yaml:"location"`
+	DatabaseType string `yaml:"type"`
+}
+
+type firestoreIAM struct {
+	Role string
+}
+
+func (rt *firestoreConfig) Load(d *api.ResourceDefinition) ([]api.Resource, []api.DependencyBinding, error) {
+	var rs []api.Resource
+	var bindings []api.DependencyBinding
+
+	dep := &firestoreConfig{}
+	resource := &firestoreConfig{}
+
+	err := yaml.Unmarshal(d.ServiceConfig, dep)
+	if err != nil {
+		return nil, nil, err
+	}
+	if d.ResourceConfig != nil {
+		err = yaml.Unmarshal(*d.ResourceConfig, resource)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	ownership := api.ReadOnly
+	if dep.Owner != nil {
+		if !*dep.Owner && dep.Access == "readwrite" {
+			ownership = api.ReadWrite
+		} else {
+			ownership = api.Owner
+		}
+	} else if dep.Access == "readwrite" {
+		ownership = api.Owner
+	}
+
+	dep.Location = "nam5"
+	dep.DatabaseType = "FIRESTORE_NATIVE"
+	if resource.Location != "" {
+		dep.Location = resource.Location
+	}
+	if resource.DatabaseType != "" {
+		dep.DatabaseType = resource.DatabaseType
+	}
+	if !firestoreTypes[dep.DatabaseType] {
+		return nil, nil, fmt.Errorf("firestore: invalid type '%s', valid types are FIRESTORE_NATIVE and DATASTORE_MODE", dep.DatabaseType)
+	}
+	dep.baseDir = rt.baseDir
+
+	iamRole := firestoreIAM{"roles/datastore.viewer"}
+	if ownership == api.Owner || ownership == api.ReadWrite {
+		iamRole = firestoreIAM{"roles/datastore.user"}
+	}
+
+	bindings = append(bindings, api.DependencyBinding{
+		DependedOnBy: d.DependedOnBy,
+		Privileges:   ownership,
+		Identity:     dep.Identity(),
+		Config:       &iamRole,
+		// services that do not own the database depend on module.firestore-default, which some service must create
+		Declared: ownership != api.Owner,
+	})
+	if ownership == api.Owner {
+		rs = append(rs, dep)
+	}
+
+	return rs, bindings, nil
+}
+
+func (r *firestoreConfig) Configure() error {
+	return applyTerraformTemplates(r.baseDir, []crFile{
+		{"firestore.tf", firestoreMain},
+	}, r)
+}
+
+func (r *firestoreConfig) Name() string {
+	return "firestore"
+}
+
+func (r *firestoreConfig) Identity() api.ResourceIdentity {
+	return api.ResourceIdentity{Type: r.Name(), ID: "default"}
+}
+
+func (iam *firestoreIAM) ConfigureResource(resource api.Resource) error {
+	cloudRun, ok := resource.(*cloudRunConfig)
+	if ok {
+		cloudRun.DependsOn = append(cloudRun.DependsOn, "module.firestore-default")
+		cloudRun.ProjectRoles = append(cloudRun.ProjectRoles, iam.Role)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package gcp
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlrte/core/pkg/api"
+)
+
+func Test_Firestore_Can_Read_Config(t *testing.T) {
+	serviceData := getCloudRunBytes(t, filepath.Join("testdata", "firestore", "service.yaml"), "firestore")
+	confData := getCloudRunBytes(t, filepath.Join("testdata", "firestore", "resources.yaml"), "firestore")
+
+	resource := &firestoreConfig{}
+	resources, bindings, err := resource.Load(&api.ResourceDefinition{
+		Name:           "firestore",
+		DependedOnBy:   api.ResourceIdentity{ID: "the-service", Type: "cloudrun"},
+		ServiceConfig:  serviceData,
+		ResourceConfig: &confData,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, resources, 1)
+	assert.Len(t, bindings, 1)
+
+	assert.Equal(t, api.ResourceIdentity{Type: "firestore", ID: "default"}, resources[0].Identity())
+	assert.Equal(t, "eur3", resources[0].(*firestoreConfig).Location)
+	assert.Equal(t, "DATASTORE_MODE", resources[0].(*firestoreConfig).DatabaseType)
+
+	assert.Equal(t, api.ResourceIdentity{ID: "the-service", Type: "cloudrun"}, bindings[0].DependedOnBy)
+	assert.Equal(t, api.Owner, bindings[0].Privileges)
+	assert.Equal(t, &firestoreIAM{"roles/datastore.user"}, bindings[0].Config)
+}
+
+func Test_Firestore_Privileges(t *testing.T) {
+	for _, tc := range []struct {
+		config     string
+		privileges api.DependencyPrivileges
+		role       string
+		resources  int
+	}{
+		{"access: read", api.ReadOnly, "roles/datastore.viewer", 0},
+		{"access: read\nowner: true", api.Owner, "roles/datastore.user", 1},
+		{"access: readwrite", api.Owner, "roles/datastore.user", 1},
+		{"access: readwrite\nowner: false", api.ReadWrite, "roles/datastore.user", 0},
+	} {
+		resource := &firestoreConfig{}
+		resources, bindings, err := resource.Load(&api.ResourceDefinition{
+			Name:          "firestore",
+			DependedOnBy:  api.ResourceIdentity{ID: "the-service", Type: "cloudrun"},
+			ServiceConfig: []byte(tc.config),
+		})
+		assert.NoError(t, err)
+		assert.Len(t, resources, tc.resources, tc.config)
+		assert.Equal(t, tc.resources == 0, bindings[0].Declared, tc.config)
+		assert.Equal(t, tc.privileges, bindings[0].Privileges, tc.config)
+		assert.Equal(t, &firestoreIAM{tc.role}, bindings[0].Config, tc.config)
+	}
+}
+
+func Test_Firestore_Invalid_Type(t *testing.T) {
+	confData := []byte("type: MONGODB")
+	resource := &firestoreConfig{}
+	_, _, err := resource.Load(&api.ResourceDefinition{
+		Name:           "firestore",
+		DependedOnBy:   api.ResourceIdentity{ID: "the-service", Type: "cloudrun"},
+		ServiceConfig:  []byte("access: readwrite"),
+		ResourceConfig: &confData,
+	})
+	assert.Error(t, err)
+}
+
+func Test_FirestoreTemplate(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tf_temp")
+	if err != nil {
+		log.Fatalf("error creating temp dir: %s", err)
+	}
+	defer func() {
+		e := os.RemoveAll(tmpDir)
+		assert.NoError(t, e)
+	}()
+
+	db := &firestoreConfig{baseDir: tmpDir, Location: "eur3", DatabaseType: "FIRESTORE_NATIVE"}
+	err = db.Configure()
+	assert.NoError(t, err)
+
+	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `module "firestore-default"`)
+	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `location = "eur3"`)
+	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `database_type = "FIRESTORE_NATIVE"`)
+}
+
+func Test_Firestore_IAM_Configures_Resource(t *testing.T) {
+	iam := &firestoreIAM{"roles/datastore.viewer"}
+	cloudRun := cloudRunConfig{}
+
+	err := iam.ConfigureResource(&cloudRun)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"module.firestore-default"}, cloudRun.DependsOn)
+	assert.Equal(t, []string{"roles/datastore.viewer"}, cloudRun.ProjectRoles)
+}
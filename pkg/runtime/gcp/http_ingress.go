@@ -0,0 +1,229 @@
+package gcp
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/xlrte/core/pkg/api"
+)
+
+//go:embed templates/http.tf
+var httpIngressMain string
+
+const httpIngressFile = "http.tf"
+
+var routePath = regexp.MustCompile(`^/([a-zA-Z0-9._~\-]+(/[a-zA-Z0-9._~\-]+)*)?$`)
+
+// httpIngress is the environment-level HTTPS load balancer, configured by the `http` resource of an environment,
+// which routes requests to services by the `spec.http.path` of each service.
+type httpIngress struct {
+	baseDir        string
+	Domain         string       `yaml:"domain"`
+	Domains        []string     `yaml:"domains"`
+	DNSZone        string       `yaml:"dns_zone"`
+	DefaultService string       `yaml:"default_service"`
+	Routes         []*httpRoute `yaml:"-"`
+}
+
+type httpRoute struct {
+	Service  string
+	Path     string
+	Ingress  string
+	Security *security
+	CDN      *cdn
+}
+
+// security is the Cloud Armor policy of a service behind the ingress. Rules are evaluated as
+// deny list, OWASP rules, rate limit and then allow list; with an allow list, all other traffic is denied.
+// The rate limit only applies to the allow list if there is one, as traffic it lets through is not checked further.
+type security struct {
+	Allow     []string   `yaml:"allow" validate:"max=10,dive,cidr"`
+	Deny      []string   `yaml:"deny" validate:"max=10,dive,cidr"`
+	OWASP     []string   `yaml:"owasp" validate:"dive,oneof=sqli xss lfi rfi rce methodenforcement scannerdetection protocolattack php sessionfixation"`
+	RateLimit *rateLimit `yaml:"rate_limit"`
+}
+
+type rateLimit struct {
+	Requests       int `yaml:"requests" validate:"min=1"`
+	IntervalSec    int `yaml:"interval_sec" validate:"oneof=10 30 60 120 180 240 300 600 900 1200 1800 2700 3600"`
+	BanDurationSec int `yaml:"ban_duration_sec" validate:"min=0"` // 0 throttles instead of banning
+}
+
+// securityRule is a rule of a Cloud Armor policy. Cloud Armor evaluates rules by priority and stops at the first
+// that matches, either by source ranges or by the preconfigured OWASP rule set.
+type securityRule struct {
+	Priority    int
+	Action      string
+	Description string
+	SrcIPRanges []string
+	OWASP       string
+	RateLimit   *rateLimit
+}
+
+// Rules are the rules of the policy in the order Cloud Armor evaluates them, ending with the default rule.
+func (s *security) Rules() []securityRule {
+	rules := []securityRule{}
+	if len(s.Deny) > 0 {
+		rules = append(rules, securityRule{Priority: 1000, Action: "deny(403)", Description: "deny list", SrcIPRanges: s.Deny})
+	}
+	for i, owasp := range s.OWASP {
+		rules = append(rules, securityRule{Priority: 2000 + i, Action: "deny(403)", Description: "OWASP " + owasp, OWASP: owasp})
+	}
+	defaultAction := "allow"
+	throttled := []string{"*"}
+	if len(s.Allow) > 0 {
+		defaultAction = "deny(403)"
+		throttled = s.Allow
+	}
+	if s.RateLimit != nil {
+		action := "throttle"
+		if s.RateLimit.BanDurationSec > 0 {
+			action = "rate_based_ban"
+		}
+		rules = append(rules, securityRule{Priority: 3000, Action: action, Description: "rate limit per client IP", SrcIPRanges: throttled, RateLimit: s.RateLimit})
+	}
+	if len(s.Allow) > 0 {
+		rules = append(rules, securityRule{Priority: 4000, Action: "allow", Description: "allow list", SrcIPRanges: s.Allow})
+	}
+	return append(rules, securityRule{Priority: 2147483647, Action: defaultAction, Description: "default rule", SrcIPRanges: []string{"*"}})
+}
+
+// cdn enables Cloud CDN for a service behind the ingress.
+type cdn struct {
+	CacheMode       string `yaml:"cache_mode" validate:"oneof=CACHE_ALL_STATIC USE_ORIGIN_HEADERS FORCE_CACHE_ALL"`
+	DefaultTTL      int    `yaml:"default_ttl" validate:"min=0"`
+	MaxTTL          int    `yaml:"max_ttl" validate:"min=0"`
+	ClientTTL       int    `yaml:"client_ttl" validate:"min=0"`
+	NegativeCaching bool   `yaml:"negative_caching"`
+}
+
+func (c *cdn) setDefaults() {
+	if c.CacheMode == "" {
+		c.CacheMode = "CACHE_ALL_STATIC"
+	}
+	if c.DefaultTTL == 0 {
+		c.DefaultTTL = 3600
+	}
+	if c.MaxTTL == 0 {
+		c.MaxTTL = 86400
+	}
+	if c.ClientTTL == 0 {
+		c.ClientTTL = c.DefaultTTL
+	}
+}
+
+func newHttpIngress(baseDir string) *httpIngress {
+	return &httpIngress{baseDir: baseDir, Routes: []*httpRoute{}}
+}
+
+// init reads the `http` resource of the environment, the ingress is disabled if there is none.
+func (ingress *httpIngress) init(ctx api.EnvContext) error {
+	if ctx.Config == nil {
+		return nil
+	}
+	err := ctx.Config("http", ingress)
+	if err != nil {
+		return err
+	}
+	if ingress.Domain != "" {
+		ingress.Domains = append([]string{ingress.Domain}, ingress.Domains...)
+		ingress.Domain = ""
+	}
+	return nil
+}
+
+func (ingress *httpIngress) enabled() bool {
+	return len(ingress.Domains) > 0
+}
+
+// addRoute routes requests with the path prefix of the route to its service.
+func (ingress *httpIngress) addRoute(newRoute *httpRoute) error {
+	service, path := newRoute.Service, newRoute.Path
+	if !ingress.enabled() || path == "" {
+		if newRoute.Security != nil || newRoute.CDN != nil {
+			return fmt.Errorf("service %s: security and cdn settings need the service to be routed through the http ingress, with a http resource in the environment and a spec.http.path", service)
+		}
+		return nil
+	}
+	if newRoute.Ingress == "internal" {
+		return fmt.Errorf("service %s: http ingress internal does not accept requests from the http load balancer, use internal-and-lb", service)
+	}
+	if !routePath.MatchString(path) {
+		return fmt.Errorf("service %s: invalid http path '%s', paths must start with '/' and must not end with '/' or contain wildcards", service, path)
+	}
+	for _, route := range ingress.Routes {
+		if route.Path == path && route.Service != service {
+			return fmt.Errorf("services %s and %s both use the http path %s", route.Service, service, path)
+		}
+		if route.Service == service {
+			*route = *newRoute
+			return nil
+		}
+	}
+	ingress.Routes = append(ingress.Routes, newRoute)
+	sort.Slice(ingress.Routes, func(i, j int) bool {
+		return ingress.Routes[i].Service < ingress.Routes[j].Service
+	})
+	return nil
+}
+
+// defaultService is the service that receives requests no other service's path matches.
+func (ingress *httpIngress) defaultService() (string, error) {
+	if ingress.DefaultService != "" {
+		found := false
+		for _, route := range ingress.Routes {
+			if route.Service == ingress.DefaultService {
+				found = true
+			} else if route.Path == "/" {
+				// only the default service can match every path
+				return "", fmt.Errorf("service %s: the http path '/' can only be used by the http default_service %s", route.Service, ingress.DefaultService)
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("http default_service %s is not a service with a http path", ingress.DefaultService)
+		}
+		return ingress.DefaultService, nil
+	}
+	for _, route := range ingress.Routes {
+		if route.Path == "/" {
+			return route.Service, nil
+		}
+	}
+	return "", fmt.Errorf("http ingress needs a service with the http path '/', or a default_service")
+}
+
+// Configure writes the load balancer to its own file, which is rewritten as services are added,
+// rather than appended to main.tf.
+func (ingress *httpIngress) Configure() error {
+	file := filepath.Join(ingress.baseDir, httpIngressFile)
+	if !ingress.enabled() || len(ingress.Routes) == 0 {
+		err := os.Remove(file)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	defaultService, err := ingress.defaultService()
+	if err != nil {
+		return err
+	}
+	data := *ingress
+	data.DefaultService = defaultService
+
+	tmpl, err := template.New(httpIngressFile).Parse(httpIngressMain)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, buf.Bytes(), 0600)
+}
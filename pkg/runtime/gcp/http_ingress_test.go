@@ -0,0 +1,267 @@
+package gcp
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlrte/core/pkg/api"
+	"gopkg.in/yaml.v2"
+)
+
+func envWithResources(t *testing.T, path string) api.EnvContext {
+	data, err := ioutil.ReadFile(filepath.Clean(path))
+	assert.NoError(t, err)
+	resources := map[string]interface{}{}
+	assert.NoError(t, yaml.Unmarshal(data, &resources))
+	return api.EnvContext{
+		EnvName: "prod",
+		Context: "theproject",
+		Config: func(name string, readInto interface{}) error {
+			if resources[name] == nil {
+				return nil
+			}
+			return remarshal(resources[name], readInto)
+		},
+	}
+}
+
+func Test_Ingress_Reads_Environment(t *testing.T) {
+	ingress := newHttpIngress("")
+	err := ingress.init(envWithResources(t, filepath.Join("testdata", "http", "resources.yaml")))
+	assert.NoError(t, err)
+	assert.True(t, ingress.enabled())
+	assert.Equal(t, []string{"example.com", "www.example.com"}, ingress.Domains)
+	assert.Equal(t, "example-zone", ingress.DNSZone)
+
+	disabled := newHttpIngress("")
+	assert.NoError(t, disabled.init(api.EnvContext{}))
+	assert.False(t, disabled.enabled())
+	assert.NoError(t, disabled.addRoute(&httpRoute{Service: "srv", Path: "/api"}))
+	assert.Len(t, disabled.Routes, 0)
+}
+
+func Test_Ingress_Routes(t *testing.T) {
+	ingress := newHttpIngress("")
+	ingress.Domains = []string{"example.com"}
+
+	assert.NoError(t, ingress.addRoute(&httpRoute{Service: "web", Path: "/"}))
+	assert.NoError(t, ingress.addRoute(&httpRoute{Service: "api", Path: "/api/v1"}))
+	assert.NoError(t, ingress.addRoute(&httpRoute{Service: "no-path", Path: ""}))
+	assert.Error(t, ingress.addRoute(&httpRoute{Service: "other", Path: "/api/v1"}))
+	assert.Error(t, ingress.addRoute(&httpRoute{Service: "other", Path: "/api/"}))
+	assert.Error(t, ingress.addRoute(&httpRoute{Service: "other", Path: "/api/*"}))
+	assert.Error(t, ingress.addRoute(&httpRoute{Service: "other", Path: "api"}))
+	assert.Equal(t, []*httpRoute{{Service: "api", Path: "/api/v1"}, {Service: "web", Path: "/"}}, ingress.Routes)
+
+	service, err := ingress.defaultService()
+	assert.NoError(t, err)
+	assert.Equal(t, "web", service)
+
+	ingress.DefaultService = "web"
+	service, err = ingress.defaultService()
+	assert.NoError(t, err)
+	assert.Equal(t, "web", service)
+
+	ingress.DefaultService = "api"
+	_, err = ingress.defaultService()
+	assert.EqualError(t, err, "service web: the http path '/' can only be used by the http default_service api")
+
+	assert.NoError(t, ingress.addRoute(&httpRoute{Service: "web", Path: "/web"}))
+	service, err = ingress.defaultService()
+	assert.NoError(t, err)
+	assert.Equal(t, "api", service)
+
+	ingress.DefaultService = "unknown"
+	_, err = ingress.defaultService()
+	assert.Error(t, err)
+}
+
+func Test_Ingress_Template(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tf_temp")
+	if err != nil {
+		log.Fatalf("error creating temp dir: %s", err)
+	}
+	defer func() {
+		e := os.RemoveAll(tmpDir)
+		assert.NoError(t, e)
+	}()
+
+	ingress := newHttpIngress(tmpDir)
+	ingress.Domains = []string{"example.com"}
+	ingress.DNSZone = "example-zone"
+	assert.NoError(t, ingress.addRoute(&httpRoute{Service: "web", Path: "/"}))
+	assert.NoError(t, ingress.addRoute(&httpRoute{Service: "api", Path: "/api"}))
+
+	err = ingress.Configure()
+	assert.NoError(t, err)
+	file := filepath.Join(tmpDir, httpIngressFile)
+	assertInFile(t, file, `domains = ["example.com",]`)
+	assertInFile(t, file, `managed_zone = "example-zone"`)
+	assertInFile(t, file, `default_service = "web"`)
+	assertInFile(t, file, `"api" = {
+      path = "/api"
+      security = null
+      cdn = null
+    }`)
+	assertInFile(t, file, `depends_on = [module.cloudrun-api,module.cloudrun-web,]`)
+
+	ingress.Routes = []*httpRoute{}
+	err = ingress.Configure()
+	assert.NoError(t, err)
+	_, err = os.Stat(file)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_Ingress_Without_Default_Service(t *testing.T) {
+	ingress := newHttpIngress("")
+	ingress.Domains = []string{"example.com"}
+	assert.NoError(t, ingress.addRoute(&httpRoute{Service: "api", Path: "/api"}))
+	assert.Error(t, ingress.Configure())
+}
+
+func Test_CloudRun_Registers_Route(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tf_temp")
+	if err != nil {
+		log.Fatalf("error creating temp dir: %s", err)
+	}
+	defer func() {
+		e := os.RemoveAll(tmpDir)
+		assert.NoError(t, e)
+	}()
+	ingress := newHttpIngress(tmpDir)
+	ingress.Domains = []string{"example.com"}
+	loader := &cloudRunLoader{baseDir: tmpDir, ingress: ingress}
+
+	env := api.EnvContext{
+		Context: "theproject",
+		EnvName: "prod",
+		Version: func(s string) (string, error) { return "v1", nil },
+	}
+	resource, err := loader.Load(env, &api.Service{
+		SVCName: "web-srv",
+		Runtime: "cloudrun",
+		Spec: cloudRunSpec{
+			BaseName: "foo",
+			Http:     http{Public: true, Path: "/"},
+		},
+	}, api.DeploymentContext{Env: api.EnvVars{}})
+	assert.NoError(t, err)
+	assert.Equal(t, []*httpRoute{{Service: "web-srv", Path: "/", Ingress: "all"}}, ingress.Routes)
+
+	assert.NoError(t, resource.Configure())
+	assertInFile(t, filepath.Join(tmpDir, httpIngressFile), `"web-srv" = {`)
+}
+
+func Test_Parse_Security_And_CDN(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Clean(filepath.Join("testdata", "cloudrun", "cloudrun-security.yaml")))
+	assert.NoError(t, err)
+	var theMap map[string][]interface{}
+	err = yaml.Unmarshal(data, &theMap)
+	assert.NoError(t, err)
+
+	bytes, err := yaml.Marshal(theMap["cloudrun"][:1])
+	assert.NoError(t, err)
+	configs, err := parseCloudRunRTEConfig(&bytes)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8"}, configs[0].Security.Allow)
+	assert.Equal(t, []string{"sqli", "xss"}, configs[0].Security.OWASP)
+	assert.Equal(t, 600, configs[0].Security.RateLimit.BanDurationSec)
+	assert.Equal(t, "USE_ORIGIN_HEADERS", configs[0].CDN.CacheMode)
+	assert.Equal(t, 3600, configs[0].CDN.ClientTTL)
+
+	bytes, err = yaml.Marshal(theMap["cloudrun"][1:])
+	assert.NoError(t, err)
+	_, err = parseCloudRunRTEConfig(&bytes)
+	assert.Error(t, err)
+}
+
+func Test_Ingress_Security_Template(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tf_temp")
+	if err != nil {
+		log.Fatalf("error creating temp dir: %s", err)
+	}
+	defer func() {
+		e := os.RemoveAll(tmpDir)
+		assert.NoError(t, e)
+	}()
+
+	ingress := newHttpIngress(tmpDir)
+	ingress.Domains = []string{"example.com"}
+	cdnSettings := &cdn{}
+	cdnSettings.setDefaults()
+	assert.NoError(t, ingress.addRoute(&httpRoute{
+		Service:  "web",
+		Path:     "/",
+		Security: &security{Deny: []string{"192.168.1.0/24"}, OWASP: []string{"sqli"}, RateLimit: &rateLimit{Requests: 100, IntervalSec: 60}},
+		CDN:      cdnSettings,
+	}))
+
+	err = ingress.Configure()
+	assert.NoError(t, err)
+	file := filepath.Join(tmpDir, httpIngressFile)
+	assertInFile(t, file, `src_ip_ranges = ["192.168.1.0/24",]`)
+	assertInFile(t, file, `owasp = "sqli"`)
+	assertInFile(t, file, `requests = 100`)
+	assertInFile(t, file, `cache_mode = "CACHE_ALL_STATIC"`)
+	assertInFile(t, file, `max_ttl = 86400`)
+}
+
+func Test_Rate_Limit_Keeps_Allow_List(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tf_temp")
+	if err != nil {
+		log.Fatalf("error creating temp dir: %s", err)
+	}
+	defer func() {
+		e := os.RemoveAll(tmpDir)
+		assert.NoError(t, e)
+	}()
+
+	theSecurity := &security{Allow: []string{"10.0.0.0/8"}, RateLimit: &rateLimit{Requests: 100, IntervalSec: 60}}
+	rules := theSecurity.Rules()
+	assert.Len(t, rules, 3)
+	assert.Equal(t, "throttle", rules[0].Action)
+	assert.Equal(t, []string{"10.0.0.0/8"}, rules[0].SrcIPRanges, "only allowed ranges are throttled")
+	assert.Equal(t, "allow", rules[1].Action)
+	assert.Equal(t, securityRule{Priority: 2147483647, Action: "deny(403)", Description: "default rule", SrcIPRanges: []string{"*"}}, rules[2])
+	for i := 1; i < len(rules); i++ {
+		assert.Less(t, rules[i-1].Priority, rules[i].Priority)
+	}
+
+	ingress := newHttpIngress(tmpDir)
+	ingress.Domains = []string{"example.com"}
+	assert.NoError(t, ingress.addRoute(&httpRoute{Service: "web", Path: "/", Security: theSecurity}))
+	assert.NoError(t, ingress.Configure())
+	data, err := ioutil.ReadFile(filepath.Join(tmpDir, httpIngressFile))
+	assert.NoError(t, err)
+	rendered := string(data)
+	throttle := strings.Index(rendered, `action = "throttle"`)
+	allow := strings.Index(rendered, `action = "allow"`)
+	deny := strings.Index(rendered, `action = "deny(403)"`)
+	assert.True(t, throttle > 0 && throttle < allow && allow < deny, rendered)
+	assert.NotContains(t, rendered, `src_ip_ranges = ["*",]
+          owasp = ""
+          rate_limit = {`, "the rate limit does not match all traffic")
+}
+
+func Test_Security_Needs_Route(t *testing.T) {
+	ingress := newHttpIngress("")
+	err := ingress.addRoute(&httpRoute{Service: "web", Path: "/", CDN: &cdn{}})
+	assert.Error(t, err)
+
+	ingress.Domains = []string{"example.com"}
+	err = ingress.addRoute(&httpRoute{Service: "web", Security: &security{}})
+	assert.Error(t, err)
+	assert.NoError(t, ingress.addRoute(&httpRoute{Service: "web", Path: "/", Security: &security{}}))
+}
+
+func Test_Internal_Service_Cannot_Be_Routed(t *testing.T) {
+	ingress := newHttpIngress("")
+	ingress.Domains = []string{"example.com"}
+	assert.Error(t, ingress.addRoute(&httpRoute{Service: "api", Path: "/api", Ingress: "internal"}))
+	assert.NoError(t, ingress.addRoute(&httpRoute{Service: "api", Path: "/api", Ingress: "internal-and-cloud-load-balancing"}))
+}
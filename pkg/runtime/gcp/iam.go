@@ -0,0 +1,95 @@
+package gcp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const iamReportFile = "iam-report.yaml"
+
+// iamGrant is a role granted to a service account on a resource.
+type iamGrant struct {
+	Resource string `yaml:"resource"`
+	Role     string `yaml:"role"`
+}
+
+type iamReportEntry struct {
+	ServiceAccount string     `yaml:"service_account"`
+	Grants         []iamGrant `yaml:"grants"`
+}
+
+// serviceAccountID is the account ID of a service's dedicated service account, which GCP limits to 6-30 characters.
+func serviceAccountID(service, env string) (string, error) {
+	id := fmt.Sprintf("%s-%s", service, env)
+	if len(id) < 6 || len(id) > 30 {
+		return "", fmt.Errorf("service account id '%s' of service %s must be between 6 and 30 characters, please use a shorter or longer service name", id, service)
+	}
+	return id, nil
+}
+
+// iamGrants lists the roles the service account of a Cloud Run service is granted by modules/cloudrun.
+func (config *cloudRunConfig) iamGrants() []iamGrant {
+	grants := []iamGrant{}
+	secrets := []string{}
+	for _, secret := range config.Env.Secrets {
+		secrets = append(secrets, strings.TrimSuffix(strings.TrimPrefix(secret, "module."), ".secret_id"))
+	}
+	sort.Strings(secrets)
+	for _, secret := range secrets {
+		grants = append(grants, iamGrant{secret, "roles/secretmanager.secretAccessor"})
+	}
+	for _, topic := range config.PublishTopics {
+		grants = append(grants, iamGrant{"pubsub-" + topic, "roles/pubsub.publisher"})
+	}
+	for _, sub := range config.SubscribeTopics {
+		grants = append(grants, iamGrant{fmt.Sprintf("pubsub-%s/%s_%s", sub.TopicName, sub.TopicName, config.ServiceName), "roles/pubsub.subscriber"})
+	}
+	for _, bucket := range config.CloudStorage {
+		for _, role := range bucket.Roles {
+			grants = append(grants, iamGrant{"cloudstorage-" + bucket.Bucket, role})
+		}
+	}
+	for _, queue := range config.TaskQueues {
+		grants = append(grants, iamGrant{"cloudtasks-" + queue, "roles/cloudtasks.enqueuer"})
+	}
+	if len(config.TaskQueues) > 0 {
+		grants = append(grants, iamGrant{"service-account", "roles/iam.serviceAccountUser"})
+	}
+	for _, service := range config.InvokeServices {
+		grants = append(grants, iamGrant{"cloudrun-" + service, "roles/run.invoker"})
+	}
+	for _, role := range config.ProjectRoles {
+		grants = append(grants, iamGrant{"project", role})
+	}
+	return grants
+}
+
+// writeIAMReport adds the grants of a service to the IAM report of the environment.
+func writeIAMReport(baseDir string, config *cloudRunConfig) error {
+	reportFile := filepath.Join(baseDir, iamReportFile)
+	report := make(map[string]iamReportEntry)
+	data, err := ioutil.ReadFile(filepath.Clean(reportFile))
+	if err == nil {
+		err = yaml.Unmarshal(data, &report)
+		if err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	report[config.ServiceName] = iamReportEntry{
+		ServiceAccount: config.ServiceAccount,
+		Grants:         config.iamGrants(),
+	}
+	data, err = yaml.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(reportFile, data, 0600)
+}
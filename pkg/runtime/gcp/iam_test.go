@@ -0,0 +1,117 @@
+package gcp
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/xlrte/core/pkg/api"
+	"gopkg.in/yaml.v2"
+)
+
+func Test_Service_Account_ID_Length(t *testing.T) {
+	id, err := serviceAccountID("my-service", "prod")
+	assert.NoError(t, err)
+	assert.Equal(t, "my-service-prod", id)
+
+	_, err = serviceAccountID("a-very-long-service-name-indeed", "prod")
+	assert.Error(t, err)
+	_, err = serviceAccountID("a", "b")
+	assert.Error(t, err)
+}
+
+func Test_IAM_Grants(t *testing.T) {
+	config := &cloudRunConfig{
+		ServiceName: "srv",
+		Env: api.EnvVars{Secrets: map[string]string{
+			"B": "module.secret-b.secret_id",
+			"A": "module.secret-a.secret_id",
+		}},
+		PublishTopics:   []string{"out"},
+		SubscribeTopics: []*subscription{{TopicName: "in"}},
+		CloudStorage:    []*gcsIAM{{"bucket", []string{"roles/storage.objectCreator", "roles/storage.objectViewer"}}},
+		TaskQueues:      []string{"emails"},
+		InvokeServices:  []string{"backend"},
+		ProjectRoles:    []string{"roles/datastore.viewer"},
+	}
+
+	assert.Equal(t, []iamGrant{
+		{"secret-a", "roles/secretmanager.secretAccessor"},
+		{"secret-b", "roles/secretmanager.secretAccessor"},
+		{"pubsub-out", "roles/pubsub.publisher"},
+		{"pubsub-in/in_srv", "roles/pubsub.subscriber"},
+		{"cloudstorage-bucket", "roles/storage.objectCreator"},
+		{"cloudstorage-bucket", "roles/storage.objectViewer"},
+		{"cloudtasks-emails", "roles/cloudtasks.enqueuer"},
+		{"service-account", "roles/iam.serviceAccountUser"},
+		{"cloudrun-backend", "roles/run.invoker"},
+		{"project", "roles/datastore.viewer"},
+	}, config.iamGrants())
+}
+
+func Test_IAM_Report(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tf_temp")
+	if err != nil {
+		log.Fatalf("error creating temp dir: %s", err)
+	}
+	defer func() {
+		e := os.RemoveAll(tmpDir)
+		assert.NoError(t, e)
+	}()
+
+	err = configureCloudRun(tmpDir, cloudRunConfig{
+		ServiceName:    "srv",
+		ServiceAccount: "srv-prod@project.iam.gserviceaccount.com",
+		PublishTopics:  []string{"out"},
+	})
+	assert.NoError(t, err)
+	err = configureCloudRun(tmpDir, cloudRunConfig{
+		ServiceName:    "other",
+		ServiceAccount: "other-prod@project.iam.gserviceaccount.com",
+		CloudStorage:   []*gcsIAM{{"bucket", []string{"roles/storage.objectViewer"}}},
+	})
+	assert.NoError(t, err)
+
+	data, err := ioutil.ReadFile(filepath.Join(tmpDir, iamReportFile))
+	assert.NoError(t, err)
+	var report map[string]iamReportEntry
+	assert.NoError(t, yaml.Unmarshal(data, &report))
+	assert.Equal(t, map[string]iamReportEntry{
+		"srv": {
+			ServiceAccount: "srv-prod@project.iam.gserviceaccount.com",
+			Grants:         []iamGrant{{"pubsub-out", "roles/pubsub.publisher"}},
+		},
+		"other": {
+			ServiceAccount: "other-prod@project.iam.gserviceaccount.com",
+			Grants:         []iamGrant{{"cloudstorage-bucket", "roles/storage.objectViewer"}},
+		},
+	}, report)
+	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `role = "roles/storage.objectViewer"`)
+}
+
+func Test_Legacy_Bindings_Are_Forgotten(t *testing.T) {
+	state := &tfjson.State{Values: &tfjson.StateValues{RootModule: &tfjson.StateModule{ChildModules: []*tfjson.StateModule{
+		{Address: "module.cloudrun-srv", Resources: []*tfjson.StateResource{
+			{Address: `module.cloudrun-srv.google_secret_manager_secret_iam_binding.binding["API_KEY"]`, Type: "google_secret_manager_secret_iam_binding"},
+			{Address: `module.cloudrun-srv.google_pubsub_topic_iam_binding.pubsub_binding["orders"]`, Type: "google_pubsub_topic_iam_binding"},
+			{Address: `module.cloudrun-srv.google_storage_bucket_iam_binding.binding["0"]`, Type: "google_storage_bucket_iam_binding"},
+			{Address: `module.cloudrun-srv.google_secret_manager_secret_iam_member.secret_accessor["API_KEY"]`, Type: "google_secret_manager_secret_iam_member"},
+			{Address: "module.cloudrun-srv.google_cloud_run_service.default", Type: "google_cloud_run_service"},
+			{Address: "module.cloudrun-srv.google_cloud_run_service_iam_policy.noauth[0]", Type: "google_cloud_run_service_iam_policy"},
+		}},
+		{Address: "module.cloudstorage-bucket", Resources: []*tfjson.StateResource{
+			{Address: "module.cloudstorage-bucket.google_storage_bucket_iam_binding.admins", Type: "google_storage_bucket_iam_binding"},
+		}},
+	}}}}
+	assert.Equal(t, []string{
+		"module.cloudrun-srv.google_cloud_run_service_iam_policy.noauth[0]",
+		`module.cloudrun-srv.google_pubsub_topic_iam_binding.pubsub_binding["orders"]`,
+		`module.cloudrun-srv.google_secret_manager_secret_iam_binding.binding["API_KEY"]`,
+		`module.cloudrun-srv.google_storage_bucket_iam_binding.binding["0"]`,
+	}, legacyBindings(state), "bindings are removed from the state instead of revoking roles of running services")
+	assert.Len(t, legacyBindings(nil), 0)
+}
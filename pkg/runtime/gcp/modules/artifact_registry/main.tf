@@ -0,0 +1,16 @@
+resource "google_project_service" "artifactregistry" {
+  project            = var.project
+  service            = "artifactregistry.googleapis.com"
+  disable_on_destroy = false
+}
+
+resource "google_artifact_registry_repository" "repository" {
+  provider      = google-beta
+  project       = var.project
+  location      = var.region
+  repository_id = var.environment
+  description   = "Docker images of the ${var.environment} environment"
+  format        = "DOCKER"
+
+  depends_on = [google_project_service.artifactregistry]
+}
@@ -0,0 +1,4 @@
+output "repository" {
+  description = "The Docker repository images are pushed to"
+  value       = "${var.region}-docker.pkg.dev/${var.project}/${google_artifact_registry_repository.repository.repository_id}"
+}
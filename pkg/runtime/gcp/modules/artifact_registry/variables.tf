@@ -0,0 +1,14 @@
+variable "project" {
+  description = "The project ID to host the repository in."
+  type        = string
+}
+
+variable "region" {
+  description = "The location of the repository, images are pulled from <region>-docker.pkg.dev."
+  type        = string
+}
+
+variable "environment" {
+  description = "The environment, which is also the ID of the repository."
+  type        = string
+}
@@ -1,6 +1,8 @@
+# Every service runs as its own service account, which is only granted the roles its dependencies need.
 resource "google_service_account" "service_account" {
   account_id   = "${var.service_name}-${var.environment}"
   display_name = "${var.service_name}-${var.environment}-account"
+  description  = "Runtime identity of the ${var.service_name} Cloud Run service in ${var.environment}"
 }
 
 resource "google_cloud_run_service" "default" {
@@ -10,7 +12,7 @@ resource "google_cloud_run_service" "default" {
   project  = var.project
 
   depends_on = [
-    google_service_account.service_account, google_secret_manager_secret_iam_binding.binding
+    google_service_account.service_account, google_secret_manager_secret_iam_member.secret_accessor
   ]
 
   template {
@@ -60,7 +62,7 @@ resource "google_cloud_run_service" "default" {
             value_from {
               secret_key_ref {
                 name = env.value
-                key = "latest"
+                key = lookup(var.secret_versions, env.key, "latest")
               }
             }
           }
@@ -74,7 +76,7 @@ resource "google_cloud_run_service" "default" {
       annotations = {
         "autoscaling.knative.dev/minScale" = var.min_instances
         "autoscaling.knative.dev/maxScale" = var.max_instances
-        "run.googleapis.com/vpc-access-egress" = var.has_serverless_network ? "private-ranges-only": null
+        "run.googleapis.com/vpc-access-egress" = var.has_serverless_network ? (var.egress_all_traffic ? "all-traffic" : "private-ranges-only") : null
         "run.googleapis.com/vpc-access-connector" = var.has_serverless_network ? "${var.serverless_network}": null
       }
     }
@@ -84,6 +86,7 @@ resource "google_cloud_run_service" "default" {
   metadata {
     annotations = {
       "run.googleapis.com/launch-stage" = "BETA"
+      "run.googleapis.com/ingress"      = var.ingress
     }
   }
 
@@ -93,36 +96,29 @@ resource "google_cloud_run_service" "default" {
   }
 }
 
-data "google_iam_policy" "noauth" {
-  binding {
-    role = "roles/run.invoker"
-    members = [
-      "allUsers",
-    ]
-  }
-}
-
-resource "google_cloud_run_service_iam_policy" "noauth" {
+# Public services may be invoked by anyone. This is a member rather than an authoritative policy,
+# which would remove the invoker grants of the services calling this one.
+resource "google_cloud_run_service_iam_member" "noauth" {
   count    = var.is_public == true ? 1 : 0
   location = google_cloud_run_service.default.location
   project  = google_cloud_run_service.default.project
   service  = google_cloud_run_service.default.name
-
-  policy_data = data.google_iam_policy.noauth.policy_data
+  role     = "roles/run.invoker"
+  member   = "allUsers"
 }
 
-resource "google_secret_manager_secret_iam_binding" "binding" {
+# IAM grants are non-authoritative members, so services sharing a secret, topic or bucket
+# do not remove each other's access. xlrte removes the authoritative bindings that previously made
+# these grants from the state before applying, so that running services keep their roles.
+resource "google_secret_manager_secret_iam_member" "secret_accessor" {
   for_each = var.secrets
   project = var.project
   secret_id = each.value
   role = "roles/secretmanager.secretAccessor"
-  members = [
-    "serviceAccount:${google_service_account.service_account.email}",
-  ]
+  member = "serviceAccount:${google_service_account.service_account.email}"
 }
 
-
-resource "google_pubsub_topic_iam_binding" "pubsub_binding" {
+resource "google_pubsub_topic_iam_member" "publisher" {
   for_each = var.publish_topics
   depends_on = [
     google_service_account.service_account,
@@ -130,25 +126,64 @@ resource "google_pubsub_topic_iam_binding" "pubsub_binding" {
   project = var.project
   topic = "${each.value}-${var.environment}"
   role = "roles/pubsub.publisher"
-  members = [
-    "serviceAccount:${google_service_account.service_account.email}",
-  ]
+  member = "serviceAccount:${google_service_account.service_account.email}"
 }
 
-resource "google_storage_bucket_iam_binding" "binding" {
+resource "google_storage_bucket_iam_member" "bucket_role" {
   for_each = {
-    for index, bucket in var.gcs_buckets:
-    index => bucket
+    for bucket in var.gcs_buckets:
+    "${bucket.bucket_name}/${bucket.role}" => bucket
   }
   depends_on = [
     google_service_account.service_account,
   ]
   bucket = "${each.value.bucket_name}-${var.environment}"
-  role = each.value.role #"roles/storage.admin" # roles/storage.objectAdmin, roles/storage.objectViewer
-  members = [
-    "serviceAccount:${google_service_account.service_account.email}",
+  role = each.value.role # roles/storage.objectViewer, roles/storage.objectCreator, roles/storage.objectAdmin
+  member = "serviceAccount:${google_service_account.service_account.email}"
+}
+resource "google_cloud_tasks_queue_iam_member" "enqueuer" {
+  for_each = var.task_queues
+  depends_on = [
+    google_service_account.service_account,
+  ]
+  project = var.project
+  location = var.region
+  name = "${each.value}-${var.environment}"
+  role = "roles/cloudtasks.enqueuer"
+  member = "serviceAccount:${google_service_account.service_account.email}"
+}
+
+# Tasks carry OIDC tokens of the service's own account, which Cloud Tasks may only mint for accounts the enqueuer can act as.
+resource "google_service_account_iam_member" "task_token_user" {
+  count = length(var.task_queues) > 0 ? 1 : 0
+  service_account_id = google_service_account.service_account.name
+  role = "roles/iam.serviceAccountUser"
+  member = "serviceAccount:${google_service_account.service_account.email}"
+}
+
+# Services this service depends on may be invoked with its identity, so they don't need to be public.
+resource "google_cloud_run_service_iam_member" "invoker" {
+  for_each = var.invoke_services
+  depends_on = [
+    google_service_account.service_account,
+  ]
+  project = var.project
+  location = var.region
+  service = "${each.value}-${var.environment}"
+  role = "roles/run.invoker"
+  member = "serviceAccount:${google_service_account.service_account.email}"
+}
+
+resource "google_project_iam_member" "project_roles" {
+  for_each = var.project_roles
+  depends_on = [
+    google_service_account.service_account,
   ]
+  project = var.project
+  role = each.value
+  member = "serviceAccount:${google_service_account.service_account.email}"
 }
+
 resource "google_pubsub_subscription" "push_subscription" {
   for_each = {
     for index, sub in var.subscription_topics:
@@ -165,4 +200,12 @@ resource "google_pubsub_subscription" "push_subscription" {
   push_config {
     push_endpoint = google_cloud_run_service.default.status[0].url
   }
-}
\ No newline at end of file
+}
+
+resource "google_pubsub_subscription_iam_member" "subscriber" {
+  for_each = google_pubsub_subscription.push_subscription
+  project = var.project
+  subscription = each.value.name
+  role = "roles/pubsub.subscriber"
+  member = "serviceAccount:${google_service_account.service_account.email}"
+}
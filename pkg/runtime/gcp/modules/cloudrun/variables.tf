@@ -40,6 +40,10 @@ variable "is_public"{
 variable "http2"{
   type = bool
 }
+variable "ingress"{
+  type = string
+  default = "all" # all, internal or internal-and-cloud-load-balancing
+}
 variable "serverless_network"{
   type = string
   default = ""
@@ -49,6 +53,11 @@ variable "has_serverless_network"{
   type = bool
   default = false
 }
+# route all egress through the serverless network, so that services with internal ingress can be invoked
+variable "egress_all_traffic"{
+  type = bool
+  default = false
+}
 variable "env"{
   type = map
 }
@@ -60,6 +69,11 @@ variable "secrets"{
   type = map
 }
 
+variable "secret_versions"{
+  type = map
+  default = {}
+}
+
 variable "subscription_topics"{
   type = list(object({
     topic_name=string,
@@ -78,3 +92,15 @@ variable "gcs_buckets"{
     role=string,
   }))
 }
+variable "project_roles"{
+  type = set(string)
+  default = []
+}
+variable "invoke_services"{
+  type = set(string)
+  default = []
+}
+variable "task_queues"{
+  type = set(string)
+  default = []
+}
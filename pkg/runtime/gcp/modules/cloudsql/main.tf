@@ -0,0 +1,196 @@
+provider "google-beta" {
+  project = var.project
+  region  = var.region
+}
+
+terraform {
+  required_version = ">= 1.1.0"
+
+  required_providers {
+    google-beta = {
+      source  = "hashicorp/google-beta"
+      version = ">= 3.57.0"
+    }
+  }
+}
+
+# ------------------------------------------------------------------------------
+# CREATE A RANDOM SUFFIX AND PREPARE RESOURCE NAMES
+# ------------------------------------------------------------------------------
+
+resource "random_id" "name" {
+  byte_length = 2
+}
+
+locals {
+  instance_name   = "${var.instance_name}-${var.environment}-${random_id.name.hex}"
+  private_ip_name = var.network_name == "network" ? "private-ip-${var.environment}" : "private-ip-${var.network_name}-${var.environment}"
+  is_postgres     = replace(var.database_version, "POSTGRES", "") != var.database_version
+  # MySQL replicas (and regional MySQL instances) require binary logging on the primary.
+  binary_log_enabled = !local.is_postgres && var.backup_enabled && (var.high_availability || var.read_replicas > 0)
+}
+
+# Existing (shared) networks are expected to already have private services access set up.
+moved {
+  from = google_compute_global_address.private_ip_address
+  to   = google_compute_global_address.private_ip_address[0]
+}
+
+moved {
+  from = google_service_networking_connection.private_vpc_connection
+  to   = google_service_networking_connection.private_vpc_connection[0]
+}
+
+resource "google_compute_global_address" "private_ip_address" {
+  provider      = google-beta
+  count         = var.create_private_service_access ? 1 : 0
+  name          = local.private_ip_name
+  purpose       = "VPC_PEERING"
+  address_type  = "INTERNAL"
+  address       = var.peering_address == "" ? null : var.peering_address
+  prefix_length = var.peering_prefix_length
+  network       = var.network_self_link
+}
+
+# Establish VPC network peering connection using the reserved address range
+resource "google_service_networking_connection" "private_vpc_connection" {
+  provider                = google-beta
+  count                   = var.create_private_service_access ? 1 : 0
+  network                 = var.network_self_link
+  service                 = "servicenetworking.googleapis.com"
+  reserved_peering_ranges = [google_compute_global_address.private_ip_address[0].name]
+}
+
+# ------------------------------------------------------------------------------
+# CREATE DATABASE INSTANCE WITH PRIVATE IP
+# ------------------------------------------------------------------------------
+
+# Instances created before MySQL support lived in a nested "postgres" module.
+moved {
+  from = module.postgres.google_sql_database_instance.master
+  to   = google_sql_database_instance.master
+}
+
+moved {
+  from = module.postgres.google_sql_database.default
+  to   = google_sql_database.default
+}
+
+moved {
+  from = module.postgres.google_sql_user.default
+  to   = google_sql_user.default
+}
+
+resource "google_sql_database_instance" "master" {
+  provider            = google-beta
+  project             = var.project
+  region              = var.region
+  name                = local.instance_name
+  database_version    = var.database_version
+  deletion_protection = var.deletion_protection
+
+  settings {
+    tier              = var.machine_type
+    disk_size         = var.disk_size
+    availability_type = var.high_availability ? "REGIONAL" : "ZONAL"
+
+    ip_configuration {
+      ipv4_enabled    = false
+      private_network = var.network_self_link
+    }
+
+    backup_configuration {
+      enabled                        = var.backup_enabled
+      start_time                     = var.backup_start_time
+      binary_log_enabled             = local.binary_log_enabled
+      point_in_time_recovery_enabled = local.is_postgres ? var.postgres_point_in_time_recovery_enabled : null
+    }
+
+    maintenance_window {
+      day  = var.maintenance_window_day
+      hour = var.maintenance_window_hour
+    }
+
+    dynamic "database_flags" {
+      for_each = var.database_flags
+      content {
+        name  = database_flags.key
+        value = database_flags.value
+      }
+    }
+  }
+
+  depends_on = [google_service_networking_connection.private_vpc_connection]
+}
+
+resource "google_sql_database" "default" {
+  provider = google-beta
+  project  = var.project
+  name     = "${var.db_name}-${var.environment}"
+  instance = google_sql_database_instance.master.name
+}
+
+resource "google_sql_database" "additional" {
+  provider = google-beta
+  for_each = toset(var.databases)
+  project  = var.project
+  name     = each.value
+  instance = google_sql_database_instance.master.name
+}
+
+resource "google_sql_user" "default" {
+  provider = google-beta
+  project  = var.project
+  instance = google_sql_database_instance.master.name
+  name     = var.master_user_name
+  password = var.master_user_password
+  host     = local.is_postgres ? null : "%"
+}
+
+resource "google_sql_user" "additional" {
+  provider = google-beta
+  for_each = toset(var.users)
+  project  = var.project
+  instance = google_sql_database_instance.master.name
+  name     = each.value
+  password = var.user_passwords[each.value]
+  host     = local.is_postgres ? null : "%"
+}
+
+# ------------------------------------------------------------------------------
+# CREATE READ REPLICAS
+# ------------------------------------------------------------------------------
+
+resource "google_sql_database_instance" "read_replica" {
+  provider             = google-beta
+  count                = var.read_replicas
+  project              = var.project
+  region               = var.region
+  name                 = "${local.instance_name}-replica-${count.index}"
+  database_version     = var.database_version
+  master_instance_name = google_sql_database_instance.master.name
+  deletion_protection  = var.deletion_protection
+
+  replica_configuration {
+    failover_target = false
+  }
+
+  settings {
+    tier              = var.machine_type
+    disk_size         = var.disk_size
+    availability_type = "ZONAL"
+
+    ip_configuration {
+      ipv4_enabled    = false
+      private_network = var.network_self_link
+    }
+
+    dynamic "database_flags" {
+      for_each = var.database_flags
+      content {
+        name  = database_flags.key
+        value = database_flags.value
+      }
+    }
+  }
+}
@@ -4,27 +4,36 @@
 
 output "master_instance_name" {
   description = "The name of the database instance"
-  value       = module.postgres.master_instance_name
+  value       = google_sql_database_instance.master.name
 }
 
 output "master_ip_addresses" {
   description = "All IP addresses of the instance as list of maps, see https://www.terraform.io/docs/providers/google/r/sql_database_instance.html#ip_address-0-ip_address"
-  value       = module.postgres.master_ip_addresses
+  value       = google_sql_database_instance.master.ip_address
 }
 
 output "master_private_ip" {
   description = "The private IPv4 address of the master instance"
-  value       = module.postgres.master_private_ip_address
+  value       = google_sql_database_instance.master.private_ip_address
 }
 
 output "master_instance" {
   description = "Self link to the master instance"
-  value       = module.postgres.master_instance
+  value       = google_sql_database_instance.master.self_link
 }
 
 output "master_proxy_connection" {
   description = "Instance path for connecting with Cloud SQL Proxy. Read more at https://cloud.google.com/sql/docs/mysql/sql-proxy"
-  value       = module.postgres.master_proxy_connection
+  value       = google_sql_database_instance.master.connection_name
+}
+
+# ------------------------------------------------------------------------------
+# READ REPLICA OUTPUTS
+# ------------------------------------------------------------------------------
+
+output "read_replica_private_ips" {
+  description = "The private IPv4 addresses of the read replicas"
+  value       = google_sql_database_instance.read_replica[*].private_ip_address
 }
 
 # ------------------------------------------------------------------------------
@@ -33,10 +42,10 @@ output "master_proxy_connection" {
 
 output "db_name" {
   description = "Name of the default database"
-  value       = module.postgres.db_name
+  value       = google_sql_database.default.name
 }
 
 output "db" {
   description = "Self link to the default database"
-  value       = module.postgres.db
-}
\ No newline at end of file
+  value       = google_sql_database.default.self_link
+}
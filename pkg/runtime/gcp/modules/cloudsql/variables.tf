@@ -21,6 +21,27 @@ variable "network_self_link"{
   type = string
 }
 
+variable "network_name"{
+  type = string
+  default = "network"
+}
+
+variable "peering_address"{
+  description = "The first address of the range reserved for private services, empty to let GCP allocate it."
+  type = string
+  default = ""
+}
+
+variable "peering_prefix_length"{
+  type = number
+  default = 16
+}
+
+variable "create_private_service_access"{
+  type = bool
+  default = true
+}
+
 variable "master_user_name" {
   description = "The username part for the default user credentials, i.e. 'master_user_name'@'master_user_host' IDENTIFIED BY 'master_user_password'. This should typically be set as the environment variable TF_VAR_master_user_name so you don't check it into source control."
   type        = string
@@ -61,12 +82,49 @@ variable "disk_size"{
 # Generally, these values won't need to be changed.
 # ---------------------------------------------------------------------------------------------------------------------
 
-variable "postgres_version" {
-  description = "The engine version of the database, e.g. `POSTGRES_13`. See https://cloud.google.com/sql/docs/db-versions for supported versions."
+variable "database_version" {
+  description = "The engine version of the database, e.g. `POSTGRES_13` or `MYSQL_8_0`. See https://cloud.google.com/sql/docs/db-versions for supported versions."
   type        = string
   default     = "POSTGRES_13"
 }
 
+variable "high_availability" {
+  description = "Set to true to run the instance as a regional (high availability) instance with a standby in another zone."
+  type        = bool
+  default     = false
+}
+
+variable "read_replicas" {
+  description = "The number of read replicas to create for the instance."
+  type        = number
+  default     = 0
+}
+
+variable "database_flags" {
+  description = "Database flags to set on the instance and its replicas, see https://cloud.google.com/sql/docs/postgres/flags and https://cloud.google.com/sql/docs/mysql/flags"
+  type        = map(string)
+  default     = {}
+}
+
+variable "databases" {
+  description = "Additional logical databases to create on the instance."
+  type        = list(string)
+  default     = []
+}
+
+variable "users" {
+  description = "Additional users to create on the instance."
+  type        = list(string)
+  default     = []
+}
+
+variable "user_passwords" {
+  description = "The passwords of the additional users, keyed by user name."
+  type        = map(string)
+  default     = {}
+  sensitive   = true
+}
+
 variable "backup_enabled" {
   description = "Set to false if you want to disable backup."
   type        = bool
@@ -2,15 +2,80 @@ resource "google_storage_bucket" "bucket" {
   name          = "${var.bucket_name}-${var.environment}"
   location      = var.location
   storage_class = var.storage_class
+  uniform_bucket_level_access = var.uniform_access
   versioning {
       enabled = var.versioning_enabled
   }
+
+  dynamic "lifecycle_rule" {
+    for_each = var.lifecycle_rules
+    content {
+      action {
+        type          = lifecycle_rule.value.action
+        storage_class = lifecycle_rule.value.storage_class
+      }
+      condition {
+        age                = lifecycle_rule.value.age
+        num_newer_versions = lifecycle_rule.value.num_newer_versions
+        # keep_versions only applies to noncurrent versions
+        with_state         = lifecycle_rule.value.num_newer_versions == null ? null : "ARCHIVED"
+      }
+    }
+  }
+
+  dynamic "cors" {
+    for_each = var.cors
+    content {
+      origin          = cors.value.origins
+      method          = cors.value.methods
+      response_header = cors.value.response_headers
+      max_age_seconds = cors.value.max_age_seconds
+    }
+  }
+
+  dynamic "retention_policy" {
+    for_each = var.retention == null ? [] : [var.retention]
+    content {
+      retention_period = retention_policy.value.period_days * 86400
+      is_locked        = retention_policy.value.locked
+    }
+  }
 }
 
 
+# Legacy ACLs are disabled with uniform bucket-level access, which makes the bucket public through IAM instead.
 resource "google_storage_bucket_access_control" "public_rule" {
-  count    = var.public == true ? 1 : 0
+  count    = var.public == true && var.uniform_access == false ? 1 : 0
   bucket = google_storage_bucket.bucket.name
   role   = "READER"
   entity = "allUsers"
-}
\ No newline at end of file
+}
+
+resource "google_storage_bucket_iam_member" "public_viewer" {
+  count  = var.public == true && var.uniform_access == true ? 1 : 0
+  bucket = google_storage_bucket.bucket.name
+  role   = "roles/storage.objectViewer"
+  member = "allUsers"
+}
+
+# Cloud Storage publishes notifications with the project's storage service agent.
+data "google_storage_project_service_account" "gcs_account" {
+}
+
+resource "google_pubsub_topic_iam_member" "notification_publisher" {
+  for_each = toset([for notification in var.notifications : notification.topic])
+  topic    = "${each.value}-${var.environment}"
+  role     = "roles/pubsub.publisher"
+  member   = "serviceAccount:${data.google_storage_project_service_account.gcs_account.email_address}"
+}
+
+resource "google_storage_notification" "notification" {
+  count              = length(var.notifications)
+  bucket             = google_storage_bucket.bucket.name
+  topic              = "${var.notifications[count.index].topic}-${var.environment}"
+  payload_format     = "JSON_API_V1"
+  event_types        = length(var.notifications[count.index].events) > 0 ? var.notifications[count.index].events : null
+  object_name_prefix = var.notifications[count.index].prefix
+
+  depends_on = [google_pubsub_topic_iam_member.notification_publisher]
+}
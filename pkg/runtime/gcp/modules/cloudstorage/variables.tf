@@ -16,3 +16,40 @@ variable "environment"{
 variable "public"{
   type = bool
 }
+variable "uniform_access"{
+  type = bool
+  default = false
+}
+variable "lifecycle_rules"{
+  type = list(object({
+    action=string,
+    storage_class=string,
+    age=number,
+    num_newer_versions=number,
+  }))
+  default = []
+}
+variable "cors"{
+  type = list(object({
+    origins=list(string),
+    methods=list(string),
+    response_headers=list(string),
+    max_age_seconds=number,
+  }))
+  default = []
+}
+variable "retention"{
+  type = object({
+    period_days=number,
+    locked=bool,
+  })
+  default = null
+}
+variable "notifications"{
+  type = list(object({
+    topic=string,
+    events=list(string),
+    prefix=string,
+  }))
+  default = []
+}
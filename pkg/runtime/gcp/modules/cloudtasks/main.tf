@@ -0,0 +1,25 @@
+resource "google_project_service" "cloudtasks" {
+  project            = var.project
+  service            = "cloudtasks.googleapis.com"
+  disable_on_destroy = false
+}
+
+resource "google_cloud_tasks_queue" "queue" {
+  name     = "${var.name}-${var.environment}"
+  project  = var.project
+  location = var.region
+
+  rate_limits {
+    max_dispatches_per_second = var.max_dispatches_per_second
+    max_concurrent_dispatches = var.max_concurrent_dispatches
+  }
+
+  retry_config {
+    max_attempts       = var.max_attempts
+    min_backoff        = "${var.min_backoff_seconds}s"
+    max_backoff        = "${var.max_backoff_seconds}s"
+    max_retry_duration = var.max_retry_duration_seconds == 0 ? null : "${var.max_retry_duration_seconds}s"
+  }
+
+  depends_on = [google_project_service.cloudtasks]
+}
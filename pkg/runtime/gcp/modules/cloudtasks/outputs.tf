@@ -0,0 +1,8 @@
+output "id" {
+  description = "The full name of the queue, projects/<project>/locations/<region>/queues/<name>"
+  value       = google_cloud_tasks_queue.queue.id
+}
+
+output "name" {
+  value = google_cloud_tasks_queue.queue.name
+}
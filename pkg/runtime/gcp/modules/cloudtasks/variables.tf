@@ -0,0 +1,32 @@
+variable "name" {
+  type = string
+}
+variable "project" {
+  type = string
+}
+variable "region" {
+  type = string
+}
+variable "environment" {
+  type = string
+}
+variable "max_dispatches_per_second" {
+  type = number
+}
+variable "max_concurrent_dispatches" {
+  type = number
+}
+variable "max_attempts" {
+  description = "The maximum number of attempts per task, -1 retries indefinitely."
+  type        = number
+}
+variable "min_backoff_seconds" {
+  type = number
+}
+variable "max_backoff_seconds" {
+  type = number
+}
+variable "max_retry_duration_seconds" {
+  description = "How long a task is retried for, 0 is unlimited."
+  type        = number
+}
@@ -0,0 +1,74 @@
+resource "google_project_service" "eventarc" {
+  project            = var.project
+  service            = "eventarc.googleapis.com"
+  disable_on_destroy = false
+}
+
+# Every trigger invokes its service with its own service account.
+resource "google_service_account" "trigger" {
+  project      = var.project
+  account_id   = "evt-${var.name}-${var.environment}"
+  display_name = "evt-${var.name}-${var.environment}-account"
+  description  = "Identity of the ${var.name} eventarc trigger of ${var.service} in ${var.environment}"
+}
+
+resource "google_project_iam_member" "event_receiver" {
+  project = var.project
+  role    = "roles/eventarc.eventReceiver"
+  member  = "serviceAccount:${google_service_account.trigger.email}"
+}
+
+resource "google_cloud_run_service_iam_member" "invoker" {
+  project  = var.project
+  location = var.region
+  service  = "${var.service}-${var.environment}"
+  role     = "roles/run.invoker"
+  member   = "serviceAccount:${google_service_account.trigger.email}"
+}
+
+# Direct Cloud Storage events are published by the project's storage service agent.
+data "google_storage_project_service_account" "gcs_account" {
+  count = var.storage_event ? 1 : 0
+}
+
+resource "google_project_iam_member" "gcs_publisher" {
+  count   = var.storage_event ? 1 : 0
+  project = var.project
+  role    = "roles/pubsub.publisher"
+  member  = "serviceAccount:${data.google_storage_project_service_account.gcs_account[0].email_address}"
+}
+
+resource "google_eventarc_trigger" "trigger" {
+  name     = "${var.name}-${var.environment}"
+  project  = var.project
+  location = var.location
+
+  matching_criteria {
+    attribute = "type"
+    value     = var.event_type
+  }
+
+  dynamic "matching_criteria" {
+    for_each = var.filters
+    content {
+      attribute = matching_criteria.key
+      value     = matching_criteria.value
+    }
+  }
+
+  destination {
+    cloud_run_service {
+      service = "${var.service}-${var.environment}"
+      region  = var.region
+      path    = var.path
+    }
+  }
+
+  service_account = google_service_account.trigger.email
+
+  depends_on = [
+    google_project_service.eventarc,
+    google_project_iam_member.event_receiver,
+    google_project_iam_member.gcs_publisher,
+  ]
+}
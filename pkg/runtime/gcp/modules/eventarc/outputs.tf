@@ -0,0 +1,3 @@
+output "trigger" {
+  value = google_eventarc_trigger.trigger
+}
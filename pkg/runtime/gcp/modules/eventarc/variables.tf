@@ -0,0 +1,36 @@
+variable "name" {
+  type = string
+}
+variable "project" {
+  type = string
+}
+variable "region" {
+  description = "The region of the Cloud Run service."
+  type        = string
+}
+variable "location" {
+  description = "The location of the trigger, which must match the location of the event source."
+  type        = string
+}
+variable "environment" {
+  type = string
+}
+variable "service" {
+  description = "The Cloud Run service the trigger delivers events to."
+  type        = string
+}
+variable "event_type" {
+  type = string
+}
+variable "storage_event" {
+  type    = bool
+  default = false
+}
+variable "filters" {
+  type    = map(string)
+  default = {}
+}
+variable "path" {
+  type    = string
+  default = null
+}
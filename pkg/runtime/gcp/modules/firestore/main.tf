@@ -0,0 +1,14 @@
+resource "google_project_service" "firestore" {
+  project            = var.project
+  service            = "firestore.googleapis.com"
+  disable_on_destroy = false
+}
+
+resource "google_firestore_database" "database" {
+  project     = var.project
+  name        = "(default)"
+  location_id = var.location
+  type        = var.database_type
+
+  depends_on = [google_project_service.firestore]
+}
@@ -0,0 +1,14 @@
+variable "project" {
+  description = "The project ID to host the database in."
+  type        = string
+}
+
+variable "location" {
+  description = "The location of the database, such as nam5 or eur3."
+  type        = string
+}
+
+variable "database_type" {
+  description = "FIRESTORE_NATIVE or DATASTORE_MODE."
+  type        = string
+}
@@ -0,0 +1,189 @@
+locals {
+  routed_services  = { for name, service in var.services : name => service if name != var.default_service }
+  secured_services = { for name, service in var.services : name => service.security if service.security != null }
+}
+
+resource "google_compute_region_network_endpoint_group" "neg" {
+  for_each              = var.services
+  name                  = "${each.key}-neg-${var.environment}"
+  network_endpoint_type = "SERVERLESS"
+  project               = var.project
+  region                = var.region
+  cloud_run {
+    service = "${each.key}-${var.environment}"
+  }
+}
+
+resource "google_compute_security_policy" "policy" {
+  for_each = local.secured_services
+  name     = "${each.key}-policy-${var.environment}"
+  project  = var.project
+
+  dynamic "rule" {
+    for_each = each.value
+    content {
+      action      = rule.value.action
+      priority    = rule.value.priority
+      description = rule.value.description
+      match {
+        versioned_expr = rule.value.owasp == "" ? "SRC_IPS_V1" : null
+        dynamic "config" {
+          for_each = rule.value.owasp == "" ? [rule.value.src_ip_ranges] : []
+          content {
+            src_ip_ranges = config.value
+          }
+        }
+        dynamic "expr" {
+          for_each = rule.value.owasp == "" ? [] : [rule.value.owasp]
+          content {
+            expression = "evaluatePreconfiguredExpr('${expr.value}-v33-stable')"
+          }
+        }
+      }
+      dynamic "rate_limit_options" {
+        for_each = rule.value.rate_limit == null ? [] : [rule.value.rate_limit]
+        content {
+          conform_action   = "allow"
+          exceed_action    = "deny(429)"
+          enforce_on_key   = "IP"
+          ban_duration_sec = rate_limit_options.value.ban_duration_sec > 0 ? rate_limit_options.value.ban_duration_sec : null
+          rate_limit_threshold {
+            count        = rate_limit_options.value.requests
+            interval_sec = rate_limit_options.value.interval_sec
+          }
+        }
+      }
+    }
+  }
+}
+
+resource "google_compute_backend_service" "backend" {
+  for_each        = var.services
+  name            = "${each.key}-backend-${var.environment}"
+  project         = var.project
+  security_policy = each.value.security == null ? null : google_compute_security_policy.policy[each.key].id
+  enable_cdn      = each.value.cdn != null
+
+  backend {
+    group = google_compute_region_network_endpoint_group.neg[each.key].id
+  }
+
+  dynamic "cdn_policy" {
+    for_each = each.value.cdn == null ? [] : [each.value.cdn]
+    content {
+      cache_mode       = cdn_policy.value.cache_mode
+      # origin headers decide the TTLs with USE_ORIGIN_HEADERS, and FORCE_CACHE_ALL has no max TTL
+      default_ttl      = cdn_policy.value.cache_mode == "USE_ORIGIN_HEADERS" ? null : cdn_policy.value.default_ttl
+      client_ttl       = cdn_policy.value.cache_mode == "USE_ORIGIN_HEADERS" ? null : cdn_policy.value.client_ttl
+      max_ttl          = cdn_policy.value.cache_mode == "CACHE_ALL_STATIC" ? cdn_policy.value.max_ttl : null
+      negative_caching = cdn_policy.value.negative_caching
+    }
+  }
+
+  log_config {
+    enable      = true
+    sample_rate = 1.0
+  }
+}
+
+resource "google_compute_url_map" "https" {
+  name            = "ingress-${var.environment}"
+  project         = var.project
+  default_service = google_compute_backend_service.backend[var.default_service].id
+
+  host_rule {
+    hosts        = ["*"]
+    path_matcher = "services"
+  }
+
+  path_matcher {
+    name            = "services"
+    default_service = google_compute_backend_service.backend[var.default_service].id
+
+    dynamic "path_rule" {
+      for_each = local.routed_services
+      content {
+        paths   = [path_rule.value.path, "${path_rule.value.path}/*"]
+        service = google_compute_backend_service.backend[path_rule.key].id
+      }
+    }
+  }
+}
+
+# Certificates can not be changed in place, so a new one is created whenever the domains change.
+resource "random_id" "certificate" {
+  byte_length = 4
+  keepers = {
+    domains = join(",", var.domains)
+  }
+}
+
+resource "google_compute_managed_ssl_certificate" "certificate" {
+  name    = "ingress-${var.environment}-${random_id.certificate.hex}"
+  project = var.project
+
+  managed {
+    domains = var.domains
+  }
+
+  lifecycle {
+    create_before_destroy = true
+  }
+}
+
+resource "google_compute_target_https_proxy" "https" {
+  name             = "ingress-https-${var.environment}"
+  project          = var.project
+  url_map          = google_compute_url_map.https.id
+  ssl_certificates = [google_compute_managed_ssl_certificate.certificate.id]
+}
+
+resource "google_compute_global_address" "ingress" {
+  name    = "ingress-${var.environment}"
+  project = var.project
+}
+
+resource "google_compute_global_forwarding_rule" "https" {
+  name       = "ingress-https-${var.environment}"
+  project    = var.project
+  target     = google_compute_target_https_proxy.https.id
+  ip_address = google_compute_global_address.ingress.address
+  port_range = "443"
+}
+
+# Plain HTTP only redirects to HTTPS.
+resource "google_compute_url_map" "http_redirect" {
+  name    = "ingress-redirect-${var.environment}"
+  project = var.project
+
+  default_url_redirect {
+    https_redirect         = true
+    redirect_response_code = "MOVED_PERMANENTLY_DEFAULT"
+    strip_query            = false
+  }
+}
+
+resource "google_compute_target_http_proxy" "http" {
+  name    = "ingress-http-${var.environment}"
+  project = var.project
+  url_map = google_compute_url_map.http_redirect.id
+}
+
+resource "google_compute_global_forwarding_rule" "http" {
+  name       = "ingress-http-${var.environment}"
+  project    = var.project
+  target     = google_compute_target_http_proxy.http.id
+  ip_address = google_compute_global_address.ingress.address
+  port_range = "80"
+}
+
+resource "google_dns_record_set" "domain" {
+  for_each     = var.managed_zone == "" ? toset([]) : toset(var.domains)
+  provider     = google-beta
+  managed_zone = var.managed_zone
+  name         = "${each.value}."
+  project      = var.project
+  type         = "A"
+  rrdatas      = [google_compute_global_address.ingress.address]
+  ttl          = 300
+}
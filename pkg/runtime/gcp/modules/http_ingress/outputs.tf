@@ -0,0 +1,4 @@
+output "external_ip" {
+  description = "The IP address of the load balancer"
+  value       = google_compute_global_address.ingress.address
+}
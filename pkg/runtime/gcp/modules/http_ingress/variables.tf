@@ -0,0 +1,56 @@
+variable "project" {
+  type = string
+}
+
+variable "region" {
+  type = string
+}
+
+variable "environment" {
+  type = string
+}
+
+variable "domains" {
+  description = "The domains of the managed certificate, which must all resolve to the load balancer."
+  type        = list(string)
+}
+
+variable "managed_zone" {
+  description = "The Cloud DNS zone to create A records for the domains in, empty to manage DNS elsewhere."
+  type        = string
+  default     = ""
+}
+
+variable "default_service" {
+  description = "The service that receives requests that don't match the path of any other service."
+  type        = string
+}
+
+variable "services" {
+  description = "The routed Cloud Run services, keyed by service name."
+  type = map(object({
+    path = string
+    # Cloud Armor rules in the order they are evaluated, or null. A rule matches its source ranges,
+    # or the preconfigured OWASP rule set if owasp is set, and rate limits if rate_limit is set.
+    security = list(object({
+      priority      = number
+      action        = string
+      description   = string
+      src_ip_ranges = list(string)
+      owasp         = string
+      rate_limit = object({
+        requests         = number
+        interval_sec     = number
+        ban_duration_sec = number
+      })
+    }))
+    # Cloud CDN settings, or null
+    cdn = object({
+      cache_mode       = string
+      default_ttl      = number
+      max_ttl          = number
+      client_ttl       = number
+      negative_caching = bool
+    })
+  }))
+}
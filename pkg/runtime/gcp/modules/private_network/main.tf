@@ -1,9 +1,22 @@
-
-
 locals {
-  private_network_name = "private-network-${var.environment}"
+  is_default_network   = var.network_name == "network"
+  create_network       = var.existing_network == ""
+  network_project      = var.host_project == "" ? var.project : var.host_project
+  private_network_name = local.is_default_network ? "private-network-${var.environment}" : "${var.network_name}-${var.environment}"
+  subnet_name          = local.is_default_network ? "serverless-subnet-${var.environment}" : "${var.network_name}-subnet-${var.environment}"
+  connector_name       = local.is_default_network ? "serverless-${var.environment}" : "${var.network_name}-${var.environment}"
+  network_self_link    = local.create_network ? module.vpc-module[0].network_self_link : data.google_compute_network.existing[0].self_link
+  subnet               = local.create_network ? module.vpc-module[0].subnets["${var.region}/${local.subnet_name}"].name : google_compute_subnetwork.serverless[0].name
 }
+
+# The network was created unconditionally before existing networks could be used.
+moved {
+  from = module.vpc-module
+  to   = module.vpc-module[0]
+}
+
 module "vpc-module" {
+  count        = local.create_network ? 1 : 0
   source       = "terraform-google-modules/network/google"
   version      = ">= 3.4"
   project_id   = var.project # Replace this with your project ID in quotes
@@ -12,21 +25,37 @@ module "vpc-module" {
 
   subnets = [
     {
-      subnet_name   = "serverless-subnet-${var.environment}"
-      subnet_ip     = "10.10.10.0/28"
+      subnet_name   = local.subnet_name
+      subnet_ip     = var.subnet_cidr
       subnet_region = var.region
     }
   ]
 } #module.vpc-module.network_self_link
 
+data "google_compute_network" "existing" {
+  count   = local.create_network ? 0 : 1
+  name    = var.existing_network
+  project = local.network_project
+}
+
+resource "google_compute_subnetwork" "serverless" {
+  count         = local.create_network ? 0 : 1
+  name          = local.subnet_name
+  project       = local.network_project
+  region        = var.region
+  network       = data.google_compute_network.existing[0].self_link
+  ip_cidr_range = var.subnet_cidr
+}
+
 # https://github.com/terraform-google-modules/terraform-google-network/blob/master/variables.tf
 module "serverless-connector" {
   source     = "terraform-google-modules/network/google//modules/vpc-serverless-connector-beta"
   project_id = var.project
   vpc_connectors = [{
-      name        = "serverless-${var.environment}"
-      region      = var.region
-      subnet_name = module.vpc-module.subnets["${var.region}/serverless-subnet-${var.environment}"].name
+      name            = local.connector_name
+      region          = var.region
+      subnet_name     = local.subnet
+      host_project_id = local.network_project
 
       machine_type  = var.instance_type # #f1-micro, e2-standard-4
       min_instances = var.min_instances
@@ -34,6 +63,7 @@ module "serverless-connector" {
     }
   ]
   depends_on = [
-    module.vpc-module
+    module.vpc-module,
+    google_compute_subnetwork.serverless,
   ]
 }
@@ -1,10 +1,10 @@
 output "network_self_link" {
-  value       = module.vpc-module.network_self_link
-  description = "The URI of the VPC being created"
+  value       = local.network_self_link
+  description = "The URI of the VPC being created, or of the existing VPC"
 }
 
 
 output "serverless_connector" {
   value       = tolist(module.serverless-connector.connector_ids)[0]
   description = "The URI of the VPC being created"
-}
\ No newline at end of file
+}
@@ -23,4 +23,26 @@ variable "max_instances"{
 
 variable "instance_type"{
   type = string
-}
\ No newline at end of file
+}
+variable "network_name"{
+  type = string
+  default = "network"
+}
+
+variable "subnet_cidr"{
+  description = "The /28 range of the serverless connector subnet."
+  type = string
+  default = "10.10.10.0/28"
+}
+
+variable "existing_network"{
+  description = "The name of an existing VPC to attach to, instead of creating one."
+  type = string
+  default = ""
+}
+
+variable "host_project"{
+  description = "The project of existing_network, if it is a shared VPC of another project."
+  type = string
+  default = ""
+}
@@ -0,0 +1,10 @@
+resource "google_redis_instance" "cache" {
+  name               = "${var.name}-${var.environment}"
+  project            = var.project
+  region             = var.region
+  tier               = var.tier
+  memory_size_gb     = var.memory_size_gb
+  redis_version      = var.redis_version
+  authorized_network = var.network_self_link
+  connect_mode       = "DIRECT_PEERING"
+}
@@ -0,0 +1,9 @@
+output "host" {
+  description = "The private IP address of the instance"
+  value       = google_redis_instance.cache.host
+}
+
+output "port" {
+  description = "The port the instance listens on"
+  value       = google_redis_instance.cache.port
+}
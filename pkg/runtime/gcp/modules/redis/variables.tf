@@ -0,0 +1,36 @@
+variable "name" {
+  type = string
+}
+
+variable "project" {
+  description = "The project ID to host the instance in."
+  type        = string
+}
+
+variable "region" {
+  description = "The region to host the instance in."
+  type        = string
+}
+
+variable "environment" {
+  type = string
+}
+
+variable "tier" {
+  description = "The service tier of the instance, BASIC or STANDARD_HA."
+  type        = string
+}
+
+variable "memory_size_gb" {
+  description = "Redis memory size in GiB."
+  type        = number
+}
+
+variable "redis_version" {
+  type = string
+}
+
+variable "network_self_link" {
+  description = "The network the instance is reachable from."
+  type        = string
+}
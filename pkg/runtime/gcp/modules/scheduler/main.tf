@@ -0,0 +1,54 @@
+resource "google_project_service" "scheduler" {
+  project            = var.project
+  service            = "cloudscheduler.googleapis.com"
+  disable_on_destroy = false
+}
+
+# HTTP jobs call their service with an OIDC token of their own service account.
+resource "google_service_account" "job" {
+  count        = var.service == null ? 0 : 1
+  project      = var.project
+  account_id   = "sch-${var.name}-${var.environment}"
+  display_name = "sch-${var.name}-${var.environment}-account"
+  description  = "Identity of the ${var.name} scheduler job in ${var.environment}"
+}
+
+resource "google_cloud_run_service_iam_member" "invoker" {
+  count    = var.service == null ? 0 : 1
+  project  = var.project
+  location = var.region
+  service  = "${var.service}-${var.environment}"
+  role     = "roles/run.invoker"
+  member   = "serviceAccount:${google_service_account.job[0].email}"
+}
+
+resource "google_cloud_scheduler_job" "job" {
+  name      = "${var.name}-${var.environment}"
+  project   = var.project
+  region    = var.region
+  schedule  = var.schedule
+  time_zone = var.time_zone
+
+  dynamic "http_target" {
+    for_each = var.service == null ? [] : [var.service_url]
+    content {
+      http_method = "POST"
+      uri         = http_target.value
+      body        = var.body == "" ? null : var.body
+      oidc_token {
+        service_account_email = google_service_account.job[0].email
+      }
+    }
+  }
+
+  dynamic "pubsub_target" {
+    for_each = var.topic == null ? [] : [var.topic]
+    content {
+      topic_name = "projects/${var.project}/topics/${pubsub_target.value}-${var.environment}"
+      # Pub/Sub messages need a payload
+      data       = var.body == "" ? base64encode("{}") : var.body
+    }
+  }
+
+  depends_on = [google_project_service.scheduler, google_cloud_run_service_iam_member.invoker]
+}
@@ -0,0 +1,39 @@
+variable "name" {
+  type = string
+}
+variable "project" {
+  type = string
+}
+variable "region" {
+  type = string
+}
+variable "environment" {
+  type = string
+}
+variable "schedule" {
+  description = "The unix-cron schedule of the job."
+  type        = string
+}
+variable "time_zone" {
+  type = string
+}
+variable "body" {
+  description = "The base64 encoded request body or message data."
+  type        = string
+  default     = ""
+}
+variable "service" {
+  description = "The Cloud Run service of http jobs."
+  type        = string
+  default     = null
+}
+variable "service_url" {
+  description = "The URL http jobs call."
+  type        = string
+  default     = null
+}
+variable "topic" {
+  description = "The topic pubsub jobs publish to."
+  type        = string
+  default     = null
+}
@@ -8,9 +8,22 @@ resource "google_secret_manager_secret" "secret" {
   }
 }
 
+# when switching to the api mode, xlrte removes existing versions from the state before applying,
+# so that the versions running revisions are pinned to are kept
 resource "google_secret_manager_secret_version" "secret-version" {
+  count = var.manage_version ? 1 : 0
   provider = google-beta
   secret = google_secret_manager_secret.secret.id
 
   secret_data = var.secret_data
+
+  # a rotated value is added before the previous version is destroyed
+  lifecycle {
+    create_before_destroy = true
+  }
+}
+
+moved {
+  from = google_secret_manager_secret_version.secret-version
+  to   = google_secret_manager_secret_version.secret-version[0]
 }
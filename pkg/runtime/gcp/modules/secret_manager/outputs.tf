@@ -1,3 +1,8 @@
 output "secret_id" {
    value       = google_secret_manager_secret.secret.secret_id
-}
\ No newline at end of file
+}
+
+# the version number, services pinned to it roll a new revision when the secret changes
+output "version" {
+   value       = var.manage_version ? element(split("/", join("", google_secret_manager_secret_version.secret-version[*].name)), 5) : var.secret_version
+}
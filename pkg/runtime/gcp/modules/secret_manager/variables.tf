@@ -4,6 +4,7 @@ variable "secret_id" {
 variable "secret_data"{
   type = string
   sensitive   = true
+  default = null
 }
 variable "environment"{
   type = string
@@ -11,4 +12,15 @@ variable "environment"{
 
 variable "project"{
   type = string
-}
\ No newline at end of file
+}
+
+# when false, versions are added through the Secret Manager API and their values never reach the state
+variable "manage_version"{
+  type = bool
+  default = true
+}
+
+variable "secret_version"{
+  type = string
+  default = "latest"
+}
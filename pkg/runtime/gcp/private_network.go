@@ -2,20 +2,33 @@ package gcp
 
 import (
 	"fmt"
+	"net"
+	"regexp"
 
 	_ "embed"
 
 	"github.com/xlrte/core/pkg/api"
+	"gopkg.in/yaml.v2"
 )
 
 //go:embed templates/private_network.tf
 var privateNetworkTF string
 
+const defaultNetwork = "network"
+
+var networkName = regexp.MustCompile(`^[a-z][a-z0-9-]{0,14}$`)
+
 type privateNetwork struct {
-	baseDir      string
-	MinInstances int    `yaml:"min_instances"` // min 2
-	MaxInstances int    `yaml:"max_instances"` // min 3, max 10
-	InstanceType string `yaml:"instance_type"` // f1-micro, e2-standard-4
+	baseDir         string
+	NetworkName     string `yaml:"name"`
+	SubnetCIDR      string `yaml:"subnet_cidr"`      // the /28 range of the serverless connector
+	PeeringCIDR     string `yaml:"peering_cidr"`     // the range reserved for private services, such as cloudsql
+	ExistingNetwork string `yaml:"existing_network"` // attach to an existing (shared) VPC instead of creating one
+	HostProject     string `yaml:"host_project"`     // the project of existing_network, if it is not the environment's project
+	MinInstances    int    `yaml:"min_instances"`    // min 2
+	MaxInstances    int    `yaml:"max_instances"`    // min 3, max 10
+	InstanceType    string `yaml:"instance_type"`    // f1-micro, e2-standard-4
+	defaultSubnet   bool
 }
 
 type privateNetworkBinding struct {
@@ -29,21 +42,212 @@ func (r *privateNetwork) Configure() error {
 }
 
 func (r *privateNetwork) Identity() api.ResourceIdentity {
-	return api.ResourceIdentity{Type: "private_network", ID: "network"}
+	return api.ResourceIdentity{Type: "private_network", ID: r.NetworkName}
 }
 
 func (r *privateNetwork) configurator() api.DependencyVisitor {
 	return &privateNetworkBinding{r.Identity()}
 }
 
+// peering returns the address and prefix length of the range reserved for private services,
+// an empty address means GCP allocates the range.
+func (r *privateNetwork) peering() (string, int) {
+	if r.PeeringCIDR == "" {
+		return "", 16
+	}
+	ip, ipNet, _ := net.ParseCIDR(r.PeeringCIDR)
+	prefix, _ := ipNet.Mask.Size()
+	return ip.String(), prefix
+}
+
+// loadNetworks reads the networks of an environment from the `network` and `vpc_access_connector` resources.
+func loadNetworks(d *api.ResourceDefinition, baseDir string) (map[string]*privateNetwork, error) {
+	var connector, networks interface{}
+	err := d.GetConfig("vpc_access_connector", &connector)
+	if err != nil {
+		return nil, err
+	}
+	err = d.GetConfig("network", &networks)
+	if err != nil {
+		return nil, err
+	}
+	result, err := parseNetworks(connector, networks)
+	if err != nil {
+		return nil, err
+	}
+	for _, network := range result {
+		network.baseDir = baseDir
+	}
+	return result, nil
+}
+
+// parseNetworks applies the vpc_access_connector settings to every network and validates them.
+// The default network always exists, so resources that don't name a network keep working.
+func parseNetworks(connector, networks interface{}) (map[string]*privateNetwork, error) {
+	defaults := privateNetwork{MinInstances: 2, MaxInstances: 3, InstanceType: "f1-micro"}
+	if connector != nil {
+		err := remarshal(connector, &defaults)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var configured []interface{}
+	if networks != nil {
+		err := remarshal(networks, &configured)
+		if err != nil {
+			return nil, fmt.Errorf("network must be a list of networks: %w", err)
+		}
+	}
+	result := make(map[string]*privateNetwork)
+	for _, conf := range configured {
+		network := defaults
+		network.NetworkName = ""
+		err := remarshal(conf, &network)
+		if err != nil {
+			return nil, err
+		}
+		if network.NetworkName == "" {
+			network.NetworkName = defaultNetwork
+		}
+		if _, found := result[network.NetworkName]; found {
+			return nil, fmt.Errorf("network %s is defined more than once", network.NetworkName)
+		}
+		result[network.NetworkName] = &network
+	}
+	if result[defaultNetwork] == nil {
+		network := defaults
+		network.NetworkName = defaultNetwork
+		result[defaultNetwork] = &network
+	}
+	for _, network := range result {
+		err := network.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (r *privateNetwork) validate() error {
+	if !networkName.MatchString(r.NetworkName) {
+		return fmt.Errorf("invalid network name '%s', network names must start with a letter, only contain lower case letters, digits and '-' and be at most 15 characters", r.NetworkName)
+	}
+	if r.SubnetCIDR == "" {
+		if r.NetworkName != defaultNetwork {
+			return fmt.Errorf("network %s: subnet_cidr is required", r.NetworkName)
+		}
+		r.SubnetCIDR = "10.10.10.0/28"
+		r.defaultSubnet = true
+	}
+	_, subnet, err := net.ParseCIDR(r.SubnetCIDR)
+	if err != nil {
+		return fmt.Errorf("network %s: invalid subnet_cidr: %w", r.NetworkName, err)
+	}
+	if prefix, _ := subnet.Mask.Size(); prefix != 28 {
+		return fmt.Errorf("network %s: subnet_cidr must be a /28 range, was %s", r.NetworkName, r.SubnetCIDR)
+	}
+	if r.PeeringCIDR != "" {
+		_, peering, e := net.ParseCIDR(r.PeeringCIDR)
+		if e != nil {
+			return fmt.Errorf("network %s: invalid peering_cidr: %w", r.NetworkName, e)
+		}
+		if prefix, _ := peering.Mask.Size(); prefix > 24 {
+			return fmt.Errorf("network %s: peering_cidr must be a /24 range or larger, was %s", r.NetworkName, r.PeeringCIDR)
+		}
+		if cidrsOverlap(r.SubnetCIDR, r.PeeringCIDR) {
+			return fmt.Errorf("network %s: subnet_cidr %s and peering_cidr %s overlap", r.NetworkName, r.SubnetCIDR, r.PeeringCIDR)
+		}
+	}
+	if r.HostProject != "" && r.ExistingNetwork == "" {
+		return fmt.Errorf("network %s: host_project can only be used with existing_network", r.NetworkName)
+	}
+	return nil
+}
+
+type networkRange struct {
+	env      string
+	network  string
+	cidr     string
+	explicit bool
+}
+
+// validateNetworkRanges checks that the ranges of environments sharing a project do not overlap.
+// Environments that only use the default subnet live in separate VPCs, so two defaults are not an overlap.
+func validateNetworkRanges(envs []api.Environment) error {
+	projects := make(map[string][]networkRange)
+	for _, env := range envs {
+		networks, err := parseNetworks(env.Resources["vpc_access_connector"], env.Resources["network"])
+		if err != nil {
+			return fmt.Errorf("environment %s: %w", env.EnvName, err)
+		}
+		for _, network := range networks {
+			// subnets in an existing VPC share its address space, even when they use the default range
+			explicit := !network.defaultSubnet || network.ExistingNetwork != ""
+			ranges := []networkRange{{env.EnvName, network.NetworkName, network.SubnetCIDR, explicit}}
+			if network.PeeringCIDR != "" {
+				ranges = append(ranges, networkRange{env.EnvName, network.NetworkName, network.PeeringCIDR, true})
+			}
+			for _, r := range ranges {
+				for _, other := range projects[env.Context] {
+					if (r.explicit || other.explicit) && cidrsOverlap(r.cidr, other.cidr) && (r.env != other.env || r.network != other.network) {
+						return fmt.Errorf("network %s in environment %s uses %s, which overlaps with %s of network %s in environment %s in the same project %s",
+							r.network, r.env, r.cidr, other.cidr, other.network, other.env, env.Context)
+					}
+				}
+			}
+			projects[env.Context] = append(projects[env.Context], ranges...)
+		}
+	}
+	return nil
+}
+
+func networkFor(networks map[string]*privateNetwork, name string) (*privateNetwork, error) {
+	if name == "" {
+		name = defaultNetwork
+	}
+	network, found := networks[name]
+	if !found {
+		return nil, fmt.Errorf("network %s is not defined in the network resources of the environment", name)
+	}
+	return network, nil
+}
+
+func cidrsOverlap(first, second string) bool {
+	_, a, errA := net.ParseCIDR(first)
+	_, b, errB := net.ParseCIDR(second)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+func remarshal(in interface{}, out interface{}) error {
+	bytes, err := yaml.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(bytes, out)
+}
+
 func (r *privateNetworkBinding) ConfigureResource(resource api.Resource) error {
+	networkLink := fmt.Sprintf("module.%s-%s.network_self_link", r.identity.Type, r.identity.ID)
 	cloudsql, ok := resource.(*cloudSql)
 	if ok {
-		cloudsql.NetworkLink = fmt.Sprintf("module.%s-%s.network_self_link", r.identity.Type, r.identity.ID)
+		cloudsql.NetworkLink = networkLink
+	}
+	redis, ok := resource.(*redisConfig)
+	if ok {
+		redis.NetworkLink = networkLink
 	}
 	cloudrun, ok := resource.(*cloudRunConfig)
 	if ok {
 		serverlessConnector := fmt.Sprintf("module.%s-%s.serverless_connector", r.identity.Type, r.identity.ID)
+		if cloudrun.HasServerlessNetwork {
+			if cloudrun.ServerlessNetworkLink != serverlessConnector {
+				return fmt.Errorf("service %s depends on resources in more than one network, a service can only be connected to one network", cloudrun.ServiceName)
+			}
+			return nil
+		}
 		cloudrun.ServerlessNetworkLink = serverlessConnector
 		cloudrun.HasServerlessNetwork = true
 		cloudrun.DependsOn = append(cloudrun.DependsOn, serverlessConnector)
@@ -1,10 +1,15 @@
 package gcp
 
 import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/xlrte/core/pkg/api"
+	"gopkg.in/yaml.v2"
 )
 
 func Test_Configures_CloudRun_With_Network(t *testing.T) {
@@ -31,3 +36,133 @@ func Test_Configures_CloudSql_With_Network(t *testing.T) {
 	assert.Equal(t, sql.NetworkLink, "module.private_network-network.network_self_link")
 
 }
+
+func Test_Configures_Redis_With_Network(t *testing.T) {
+	binding := privateNetworkBinding{
+		identity: api.ResourceIdentity{ID: "network", Type: "private_network"},
+	}
+	redis := redisConfig{}
+
+	err := binding.ConfigureResource(&redis)
+	assert.NoError(t, err)
+	assert.Equal(t, redis.NetworkLink, "module.private_network-network.network_self_link")
+}
+
+func Test_Parse_Networks(t *testing.T) {
+	resources := map[string]interface{}{}
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "private_network", "resources.yaml"))
+	assert.NoError(t, err)
+	assert.NoError(t, yaml.Unmarshal(data, &resources))
+
+	networks, err := parseNetworks(resources["vpc_access_connector"], resources["network"])
+	assert.NoError(t, err)
+	assert.Len(t, networks, 2)
+
+	assert.Equal(t, "10.20.0.0/28", networks["network"].SubnetCIDR)
+	assert.Equal(t, 3, networks["network"].MinInstances)
+	assert.Equal(t, 5, networks["network"].MaxInstances)
+	assert.Equal(t, "f1-micro", networks["network"].InstanceType)
+	address, prefix := networks["network"].peering()
+	assert.Equal(t, "10.30.0.0", address)
+	assert.Equal(t, 16, prefix)
+
+	assert.Equal(t, api.ResourceIdentity{Type: "private_network", ID: "shared"}, networks["shared"].Identity())
+	assert.Equal(t, "shared-vpc", networks["shared"].ExistingNetwork)
+	assert.Equal(t, "host-project", networks["shared"].HostProject)
+	assert.Equal(t, 3, networks["shared"].MinInstances)
+}
+
+func Test_Default_Network(t *testing.T) {
+	networks, err := parseNetworks(nil, nil)
+	assert.NoError(t, err)
+	assert.Len(t, networks, 1)
+	assert.Equal(t, "10.10.10.0/28", networks["network"].SubnetCIDR)
+	assert.Equal(t, api.ResourceIdentity{Type: "private_network", ID: "network"}, networks["network"].Identity())
+	address, prefix := networks["network"].peering()
+	assert.Equal(t, "", address)
+	assert.Equal(t, 16, prefix)
+}
+
+func Test_Invalid_Networks(t *testing.T) {
+	for _, config := range []string{
+		"- name: other",                // missing subnet
+		"- subnet_cidr: 10.20.0.0/24",  // not a /28
+		"- subnet_cidr: not-a-range",   // invalid
+		"- peering_cidr: 10.30.0.0/28", // too small
+		"- peering_cidr: 10.10.0.0/16", // overlaps the default subnet
+		"- host_project: foo",          // host project without existing network
+		"- name: Not_Valid\n  subnet_cidr: 10.20.0.0/28",
+		"- name: a\n  subnet_cidr: 10.20.0.0/28\n- name: a\n  subnet_cidr: 10.20.1.0/28",
+	} {
+		var networks interface{}
+		assert.NoError(t, yaml.Unmarshal([]byte(config), &networks))
+		_, err := parseNetworks(nil, networks)
+		assert.Error(t, err, config)
+	}
+}
+
+func Test_Network_Ranges_Across_Environments(t *testing.T) {
+	env := func(name, project, networks string) api.Environment {
+		var resources map[string]interface{}
+		assert.NoError(t, yaml.Unmarshal([]byte("network:\n"+networks), &resources))
+		return api.Environment{EnvName: name, Context: project, Resources: resources}
+	}
+	defaults := api.Environment{EnvName: "dev", Context: "project"}
+	otherDefaults := api.Environment{EnvName: "test", Context: "project"}
+
+	assert.NoError(t, validateNetworkRanges([]api.Environment{defaults, otherDefaults}))
+	assert.NoError(t, validateNetworkRanges([]api.Environment{
+		env("dev", "project", "- subnet_cidr: 10.20.0.0/28\n  peering_cidr: 10.30.0.0/16"),
+		env("prod", "project", "- subnet_cidr: 10.20.0.16/28\n  peering_cidr: 10.31.0.0/16"),
+	}))
+	assert.NoError(t, validateNetworkRanges([]api.Environment{
+		env("dev", "project", "- subnet_cidr: 10.20.0.0/28"),
+		env("prod", "other-project", "- subnet_cidr: 10.20.0.0/28"),
+	}))
+
+	err := validateNetworkRanges([]api.Environment{
+		env("dev", "project", "- subnet_cidr: 10.20.0.0/28\n  peering_cidr: 10.30.0.0/16"),
+		env("prod", "project", "- subnet_cidr: 10.20.0.16/28\n  peering_cidr: 10.30.128.0/20"),
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "overlaps")
+
+	err = validateNetworkRanges([]api.Environment{
+		defaults,
+		env("prod", "project", "- subnet_cidr: 10.10.10.0/28"),
+	})
+	assert.Error(t, err)
+}
+
+func Test_CloudRun_Can_Only_Use_One_Network(t *testing.T) {
+	cloudRun := cloudRunConfig{ServiceName: "srv"}
+	first := privateNetworkBinding{identity: api.ResourceIdentity{ID: "network", Type: "private_network"}}
+	second := privateNetworkBinding{identity: api.ResourceIdentity{ID: "shared", Type: "private_network"}}
+
+	assert.NoError(t, first.ConfigureResource(&cloudRun))
+	assert.NoError(t, first.ConfigureResource(&cloudRun))
+	assert.Equal(t, []string{"module.private_network-network.serverless_connector"}, cloudRun.DependsOn)
+	assert.Error(t, second.ConfigureResource(&cloudRun))
+}
+
+func Test_Network_Template(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tf_temp")
+	if err != nil {
+		log.Fatalf("error creating temp dir: %s", err)
+	}
+	defer func() {
+		e := os.RemoveAll(tmpDir)
+		assert.NoError(t, e)
+	}()
+	var networks interface{}
+	assert.NoError(t, yaml.Unmarshal([]byte("- name: shared\n  existing_network: shared-vpc\n  subnet_cidr: 10.40.0.0/28"), &networks))
+	parsed, err := parseNetworks(nil, networks)
+	assert.NoError(t, err)
+	network := parsed["shared"]
+	network.baseDir = tmpDir
+
+	assert.NoError(t, network.Configure())
+	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `module "private_network-shared"`)
+	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `subnet_cidr = "10.40.0.0/28"`)
+	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `existing_network = "shared-vpc"`)
+}
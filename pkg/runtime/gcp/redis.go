@@ -0,0 +1,142 @@
+package gcp
+
+import (
+	_ "embed"
+	"fmt"
+
+	"github.com/xlrte/core/pkg/api"
+	"gopkg.in/yaml.v2"
+)
+
+//go:embed templates/redis.tf
+var redisMain string
+
+var redisTiers = map[string]bool{
+	"BASIC":       true,
+	"STANDARD_HA": true,
+}
+
+type redisConfig struct {
+	baseDir     string
+	CacheName   string `yaml:"name"`
+	Tier        string `yaml:"tier"`
+	MemorySize  int    `yaml:"memory_size_gb"`
+	Version     string `yaml:"version"`
+	NetworkName string `yaml:"network"`
+	NetworkLink string `yaml:"-"`
+}
+
+func (rt *redisConfig) Load(d *api.ResourceDefinition) ([]api.Resource, []api.DependencyBinding, error) {
+	var caches []*redisConfig
+	var resources []redisConfig
+	var rs []api.Resource
+	var bindings []api.DependencyBinding
+
+	err := yaml.Unmarshal(d.ServiceConfig, &caches)
+	if err != nil {
+		return nil, nil, err
+	}
+	if d.ResourceConfig != nil {
+		err = yaml.Unmarshal(*d.ResourceConfig, &resources)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	networks, err := loadNetworks(d, rt.baseDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	usedNetworks := make(map[string]bool)
+
+	for _, cache := range caches {
+		for _, r := range resources {
+			if cache.CacheName == r.CacheName {
+				cache.Tier = r.Tier
+				cache.MemorySize = r.MemorySize
+				cache.Version = r.Version
+				if r.NetworkName != "" {
+					cache.NetworkName = r.NetworkName
+				}
+				break
+			}
+		}
+		network, err := networkFor(networks, cache.NetworkName)
+		if err != nil {
+			return nil, nil, fmt.Errorf("redis %s: %w", cache.CacheName, err)
+		}
+		if !usedNetworks[network.NetworkName] {
+			usedNetworks[network.NetworkName] = true
+			rs = append(rs, network)
+			bindings = append(bindings, api.DependencyBinding{
+				DependedOnBy: d.DependedOnBy,
+				Privileges:   api.Owner,
+				Identity:     network.Identity(),
+				Config:       network.configurator(),
+			})
+		}
+		cache.NetworkName = network.NetworkName
+		if cache.Tier == "" {
+			cache.Tier = "BASIC"
+		}
+		if !redisTiers[cache.Tier] {
+			return nil, nil, fmt.Errorf("redis %s: invalid tier '%s', valid tiers are BASIC and STANDARD_HA", cache.CacheName, cache.Tier)
+		}
+		if cache.MemorySize == 0 {
+			cache.MemorySize = 1
+		}
+		if cache.MemorySize < 0 || cache.MemorySize > 300 {
+			return nil, nil, fmt.Errorf("redis %s: memory_size_gb must be between 1 and 300", cache.CacheName)
+		}
+		if cache.Version == "" {
+			cache.Version = "REDIS_6_X"
+		}
+		cache.baseDir = rt.baseDir
+
+		// Redis dependency of Service
+		bindings = append(bindings, api.DependencyBinding{
+			DependedOnBy: d.DependedOnBy,
+			Privileges:   api.Owner,
+			Identity:     cache.Identity(),
+			Config:       cache,
+		})
+
+		// Network dependency of Redis
+		bindings = append(bindings, api.DependencyBinding{
+			DependedOnBy: cache.Identity(),
+			Privileges:   api.Owner,
+			Identity:     network.Identity(),
+			Config:       network.configurator(),
+		})
+		rs = append(rs, cache)
+	}
+	return rs, bindings, nil
+}
+
+func (r *redisConfig) Configure() error {
+	return applyTerraformTemplates(r.baseDir, []crFile{
+		{"redis.tf", redisMain},
+	}, r)
+}
+
+func (r *redisConfig) Name() string {
+	return "redis"
+}
+
+func (r *redisConfig) Identity() api.ResourceIdentity {
+	return api.ResourceIdentity{Type: "redis", ID: r.CacheName}
+}
+
+func (r *redisConfig) ConfigureResource(resource api.Resource) error {
+	cloudrun, ok := resource.(*cloudRunConfig)
+	if ok {
+		dependency := toDependency(r.Identity().String())
+		if cloudrun.Env.Refs == nil {
+			cloudrun.Env.Refs = make(map[string]string)
+		}
+		cloudrun.Env.Refs[fmt.Sprintf("REDIS_%s_HOST", r.CacheName)] = dependency + ".host"
+		cloudrun.Env.Refs[fmt.Sprintf("REDIS_%s_PORT", r.CacheName)] = dependency + ".port"
+		cloudrun.DependsOn = append(cloudrun.DependsOn, dependency)
+	}
+	return nil
+}
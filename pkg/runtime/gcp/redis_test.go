@@ -0,0 +1,104 @@
+package gcp
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlrte/core/pkg/api"
+)
+
+func Test_Can_Read_Redis_Resources(t *testing.T) {
+	serviceData := getCloudRunBytes(t, filepath.Join("testdata", "redis", "service.yaml"), "redis")
+	confData := getCloudRunBytes(t, filepath.Join("testdata", "redis", "resources.yaml"), "redis")
+
+	resource := &redisConfig{}
+	resources, bindings, err := resource.Load(&api.ResourceDefinition{
+		Name:           "redis",
+		DependedOnBy:   api.ResourceIdentity{ID: "the-service", Type: "cloudrun"},
+		ServiceConfig:  serviceData,
+		ResourceConfig: &confData,
+	})
+
+	assert.NoError(t, err)
+	assert.Len(t, resources, 3)
+	assert.Len(t, bindings, 5)
+
+	assert.Equal(t, api.ResourceIdentity{Type: "private_network", ID: "network"}, resources[0].Identity())
+	assert.Equal(t, api.ResourceIdentity{ID: "the-service", Type: "cloudrun"}, bindings[1].DependedOnBy)
+	assert.Equal(t, api.ResourceIdentity{Type: "redis", ID: "my-cache"}, bindings[1].Identity)
+	assert.Equal(t, api.ResourceIdentity{Type: "redis", ID: "my-cache"}, bindings[2].DependedOnBy)
+	assert.Equal(t, api.ResourceIdentity{Type: "private_network", ID: "network"}, bindings[2].Identity)
+
+	cache := resources[1].(*redisConfig)
+	assert.Equal(t, "my-cache", cache.CacheName)
+	assert.Equal(t, "STANDARD_HA", cache.Tier)
+	assert.Equal(t, 5, cache.MemorySize)
+	assert.Equal(t, "REDIS_6_X", cache.Version)
+
+	defaults := resources[2].(*redisConfig)
+	assert.Equal(t, "sessions", defaults.CacheName)
+	assert.Equal(t, "BASIC", defaults.Tier)
+	assert.Equal(t, 1, defaults.MemorySize)
+}
+
+func Test_Redis_Invalid_Tier(t *testing.T) {
+	serviceData := getCloudRunBytes(t, filepath.Join("testdata", "redis", "service.yaml"), "redis")
+	confData := []byte(`
+- name: my-cache
+  tier: PREMIUM
+`)
+
+	resource := &redisConfig{}
+	_, _, err := resource.Load(&api.ResourceDefinition{
+		Name:           "redis",
+		DependedOnBy:   api.ResourceIdentity{ID: "the-service", Type: "cloudrun"},
+		ServiceConfig:  serviceData,
+		ResourceConfig: &confData,
+	})
+	assert.Error(t, err)
+}
+
+func Test_RedisTemplate(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tf_temp")
+	if err != nil {
+		log.Fatalf("error creating temp dir: %s", err)
+	}
+	defer func() {
+		e := os.RemoveAll(tmpDir)
+		assert.NoError(t, e)
+	}()
+
+	cache := &redisConfig{
+		baseDir:     tmpDir,
+		CacheName:   "my-cache",
+		Tier:        "STANDARD_HA",
+		MemorySize:  5,
+		Version:     "REDIS_6_X",
+		NetworkLink: "module.private_network-network.network_self_link",
+	}
+	err = cache.Configure()
+	assert.NoError(t, err)
+
+	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `module "redis-my-cache"`)
+	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `tier = "STANDARD_HA"`)
+	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `memory_size_gb = 5`)
+	assertInFile(t, filepath.Join(tmpDir, "main.tf"), `network_self_link = module.private_network-network.network_self_link`)
+}
+
+func Test_Redis_ConfigureResource(t *testing.T) {
+	cache := &redisConfig{CacheName: "my-cache"}
+	cloudRun := cloudRunConfig{}
+
+	err := cache.ConfigureResource(&cloudRun)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"module.redis-my-cache"}, cloudRun.DependsOn)
+	assert.Equal(t, map[string]string{
+		"REDIS_my-cache_HOST": "module.redis-my-cache.host",
+		"REDIS_my-cache_PORT": "module.redis-my-cache.port",
+	}, cloudRun.Env.Refs)
+}
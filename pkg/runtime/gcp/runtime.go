@@ -5,13 +5,18 @@ import (
 	"bytes"
 	"context"
 	"embed"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"io/fs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
+	"github.com/hashicorp/terraform-exec/tfexec"
+	tfjson "github.com/hashicorp/terraform-json"
 	"github.com/xlrte/core/pkg/api"
 	"github.com/xlrte/core/pkg/api/secrets"
 	"github.com/xlrte/core/pkg/terraform"
@@ -36,14 +41,25 @@ type gcpRuntime struct {
 	StateStore  string
 	Project     string
 	Environment string
-	resetVars   []string
+	secrets     map[string]string
+	secretDeps  map[string]string // module a secret is applied after, such as the database whose password it holds
+	versions    map[string]string // secret versions added through the Secret Manager API, by secret name
+	shells      map[string]bool   // secrets that are kept without their values
+	secretMgr   *secretManager
+	outputs     map[string]string
+	ingress     *httpIngress
+	registry    *artifactRegistry
+	services    map[string]*cloudRunConfig // Cloud Run services of the deployments, by name
 }
 
 func NewRuntime(modulesDir string, baseDir string) api.Runtime {
 	mainFile := filepath.Join(baseDir, "main.tf")
-	os.Remove(mainFile) //nolint
+	os.Remove(mainFile)                                     //nolint
+	os.Remove(filepath.Join(baseDir, iamReportFile))        //nolint
+	os.Remove(filepath.Join(baseDir, httpIngressFile))      //nolint
+	os.Remove(filepath.Join(baseDir, artifactRegistryFile)) //nolint
 
-	return &gcpRuntime{modulesDir: modulesDir, baseDir: baseDir, resetVars: []string{}}
+	return &gcpRuntime{modulesDir: modulesDir, baseDir: baseDir, secrets: map[string]string{}, secretDeps: map[string]string{}, versions: map[string]string{}, shells: map[string]bool{}, secretMgr: newSecretManager(), services: map[string]*cloudRunConfig{}}
 }
 
 func (rt *gcpRuntime) InitEnvironment(ctx context.Context, env, project, region string) error {
@@ -108,28 +124,36 @@ func (rt *gcpRuntime) Name() string {
 	return "gcp"
 }
 
-func (rt *gcpRuntime) resetEnv() error {
-	for _, varName := range rt.resetVars {
-		err := os.Setenv(varName, "")
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+type secretModule struct {
+	Name      string
+	DependsOn string
+	API       bool
+	Shell     bool // the secret is kept without its value, as nothing in the deployment references it
 }
 
-func (rt *gcpRuntime) InitSecrets(env api.EnvContext, secrets []*secrets.Secret) error {
-
+// InitSecrets keeps the secrets of the deployment for terraform, which receives them through a var file
+// rather than the environment of this process. Stored secrets nothing references are not decrypted, but still
+// get a module, so that secrets added ahead of a rollout or used outside of xlrte are not destroyed.
+func (rt *gcpRuntime) InitSecrets(env api.EnvContext, secrets []*secrets.Secret, stored []string) error {
+	rt.secrets = make(map[string]string)
+	rt.shells = make(map[string]bool)
 	for _, secret := range secrets {
-		key := fmt.Sprintf("TF_VAR_secret_%s", secret.Name)
-		err := os.Setenv(key, secret.Value)
-		rt.resetVars = append(rt.resetVars, key)
+		rt.secrets[secret.Name] = secret.Value
+		err := applyTerraformTemplates(rt.baseDir, []crFile{
+			{"secret.tf", secretMain},
+		}, &secretModule{Name: secret.Name, DependsOn: rt.secretDeps[secret.Name], API: rt.secretMgr.api()})
 		if err != nil {
 			return err
 		}
-		err = applyTerraformTemplates(rt.baseDir, []crFile{
+	}
+	for _, name := range stored {
+		if _, found := rt.secrets[name]; found {
+			continue
+		}
+		rt.shells[name] = true
+		err := applyTerraformTemplates(rt.baseDir, []crFile{
 			{"secret.tf", secretMain},
-		}, secret)
+		}, &secretModule{Name: name, Shell: true})
 		if err != nil {
 			return err
 		}
@@ -140,73 +164,297 @@ func (rt *gcpRuntime) InitSecrets(env api.EnvContext, secrets []*secrets.Secret)
 
 func (rt *gcpRuntime) Services() []api.ServiceLoader {
 	return []api.ServiceLoader{
-		&cloudRunLoader{baseDir: rt.baseDir, service: nil},
+		&cloudRunLoader{baseDir: rt.baseDir, service: nil, ingress: rt.ingress, registry: rt.registry, services: rt.services},
 	}
 }
 func (rt *gcpRuntime) Resources() []api.ResourceLoader {
 	return []api.ResourceLoader{
-		&cloudSql{baseDir: rt.baseDir},
+		&cloudSql{baseDir: rt.baseDir, runtime: rt, secretDeps: rt.secretDeps},
 		&pubSubConfig{baseDir: rt.baseDir},
 		&gcsConfig{baseDir: rt.baseDir},
-		&cloudRunDependency{baseDir: rt.baseDir},
+		&redisConfig{baseDir: rt.baseDir},
+		&firestoreConfig{baseDir: rt.baseDir},
+		&cloudRunDependency{baseDir: rt.baseDir, services: rt.services},
+		&eventarcTrigger{baseDir: rt.baseDir},
+		&schedulerJob{baseDir: rt.baseDir},
+		&cloudTasksQueue{baseDir: rt.baseDir},
 	}
 }
 
+// ValidateEnvironments checks settings that must be consistent across all environments, such as network ranges.
+func (rt *gcpRuntime) ValidateEnvironments(envs []api.Environment) error {
+	return validateNetworkRanges(envs)
+}
+
 func (rt *gcpRuntime) Init(ctx api.EnvContext) error {
 	rt.Project = ctx.Context
 	rt.Region = ctx.Region
 	rt.Environment = ctx.EnvName
 	rt.StateStore = ctx.StateStore
-	err := rt.setProvider()
-	return err
+	rt.ingress = newHttpIngress(rt.baseDir)
+	err := rt.ingress.init(ctx)
+	if err != nil {
+		return err
+	}
+	err = rt.secretMgr.init(ctx)
+	if err != nil {
+		return err
+	}
+	rt.registry = newArtifactRegistry(rt.baseDir)
+	err = rt.registry.init(ctx)
+	if err != nil {
+		return err
+	}
+	err = rt.registry.Configure()
+	if err != nil {
+		return err
+	}
+	return rt.setProvider()
 }
 
 func (rt *gcpRuntime) Apply(ctx context.Context) error {
-	defer func() {
-		_ = rt.resetEnv()
-	}()
+	if rt.secretMgr.api() {
+		err := rt.addSecretVersions(ctx)
+		if err != nil {
+			return err
+		}
+	}
 	return rt.execCommand(ctx, api.Apply)
 }
 func (rt *gcpRuntime) Plan(ctx context.Context) error {
-	defer func() {
-		_ = rt.resetEnv()
-	}()
+	if rt.secretMgr.api() {
+		// pin the versions that are current already, changed secrets are only added on apply
+		for name, value := range rt.secrets {
+			version, err := rt.secretMgr.latestVersion(ctx, name, value)
+			if err != nil {
+				return err
+			}
+			if version != "" {
+				rt.versions[name] = version
+			}
+		}
+	}
 	return rt.execCommand(ctx, api.Plan)
 }
 
+// addSecretVersions creates the secrets with terraform, along with the databases whose passwords they hold,
+// then adds changed values through the Secret Manager API and pins services to the versions holding them.
+// Versions terraform created before the environment switched to the api mode are removed from the state first,
+// so that they are kept for the revisions pinned to them instead of being destroyed by the apply.
+func (rt *gcpRuntime) addSecretVersions(ctx context.Context) error {
+	if len(rt.secrets) == 0 {
+		return nil
+	}
+	names := []string{}
+	for name := range rt.secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	tf, err := terraform.Init(ctx, rt.baseDir, os.Stdout, os.Stderr)
+	if err != nil {
+		return err
+	}
+	err = forgetResources(ctx, tf, managedVersions)
+	if err != nil {
+		return err
+	}
+	err = rt.withSecretVars(func(varFile string) error {
+		options := []tfexec.ApplyOption{tfexec.VarFile(varFile)}
+		for _, name := range names {
+			options = append(options, tfexec.Target(toDependency("secret-"+name)))
+		}
+		return tf.Apply(ctx, options...)
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		version, err := rt.secretMgr.addVersion(ctx, name, rt.secrets[name])
+		if err != nil {
+			return err
+		}
+		rt.versions[name] = version
+	}
+	return nil
+}
+
+// forgetResources removes the resources find returns from the state without destroying them, as terraform 1.1
+// can neither move resources to another type nor keep resources that are removed from the configuration.
+func forgetResources(ctx context.Context, tf *tfexec.Terraform, find func(state *tfjson.State) []string) error {
+	state, err := tf.Show(ctx)
+	if err != nil {
+		return err
+	}
+	for _, address := range find(state) {
+		err = tf.StateRm(ctx, address)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// managedVersions are the addresses of the secret versions terraform keeps in its state.
+func managedVersions(state *tfjson.State) []string {
+	return stateResources(state, "module.secret-", "google_secret_manager_secret_version")
+}
+
+// shellVersions are the secret versions terraform created for secrets that are now kept without their values.
+// Without a value, terraform would destroy them, so they are forgotten instead.
+func (rt *gcpRuntime) shellVersions(state *tfjson.State) []string {
+	addresses := []string{}
+	for _, address := range managedVersions(state) {
+		for name := range rt.shells {
+			if strings.HasPrefix(address, "module.secret-"+name+".") {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	return addresses
+}
+
+// legacyBindings are the authoritative IAM bindings and policies of services that were replaced by members.
+// Destroying them would revoke the roles from running services until the members are created, so they are
+// forgotten instead and the members take over the grants they made.
+func legacyBindings(state *tfjson.State) []string {
+	return stateResources(state, "module.cloudrun-",
+		"google_secret_manager_secret_iam_binding", "google_pubsub_topic_iam_binding", "google_storage_bucket_iam_binding",
+		"google_cloud_run_service_iam_policy")
+}
+
+// stateResources are the addresses of the resources of the given types in modules whose address starts with prefix.
+func stateResources(state *tfjson.State, prefix string, types ...string) []string {
+	addresses := []string{}
+	if state == nil || state.Values == nil || state.Values.RootModule == nil {
+		return addresses
+	}
+	for _, module := range state.Values.RootModule.ChildModules {
+		if !strings.HasPrefix(module.Address, prefix) {
+			continue
+		}
+		for _, resource := range module.Resources {
+			for _, t := range types {
+				if resource.Type == t {
+					addresses = append(addresses, resource.Address)
+				}
+			}
+		}
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
 func (rt *gcpRuntime) Delete(ctx context.Context) error {
-	defer func() {
-		_ = rt.resetEnv()
-	}()
 	return rt.execCommand(ctx, api.Delete)
 }
 
 func (rt *gcpRuntime) Export(ctx context.Context) error {
-	defer func() {
-		_ = rt.resetEnv()
-	}()
 	return rt.execCommand(ctx, api.Export)
 }
 
-func (rt *gcpRuntime) execCommand(ctx context.Context, cmd api.Command) error {
+// ApplyTargets applies the given resources and the resources they depend on, but nothing else.
+func (rt *gcpRuntime) ApplyTargets(ctx context.Context, targets []api.ResourceIdentity) error {
 	tf, err := terraform.Init(ctx, rt.baseDir, os.Stdout, os.Stderr)
 	if err != nil {
 		return err
 	}
+	return rt.withSecretVars(func(varFile string) error {
+		options := []tfexec.ApplyOption{tfexec.VarFile(varFile)}
+		for _, target := range targets {
+			options = append(options, tfexec.Target(toDependency(target.String())))
+		}
+		rt.outputs = nil
+		return tf.Apply(ctx, options...)
+	})
+}
 
-	switch cmd {
-	case api.Plan:
-		_, err = tf.Plan(ctx)
+// withSecretVars runs fn with a var file holding the secrets of the deployment. Secrets are not passed as TF_VAR_
+// variables, as they would have to be set in the environment of this process: terraform-exec rejects TF_VAR_
+// variables in SetEnv, so they cannot be scoped to the terraform command. The file is only readable by the
+// current user and removed afterwards.
+func (rt *gcpRuntime) withSecretVars(fn func(varFile string) error) error {
+	vars := make(map[string]string)
+	for name, value := range rt.secrets {
+		vars["secret_"+name] = value
+	}
+	for name, version := range rt.versions {
+		vars["secret_version_"+name] = version
+	}
+	data, err := json.Marshal(vars)
+	if err != nil {
+		return err
+	}
+	dir, err := ioutil.TempDir("", "xlrte-secrets")
+	if err != nil {
 		return err
-	case api.Export:
-		return nil
-	case api.Apply:
-		return tf.Apply(ctx)
-	case api.Delete:
-		return tf.Destroy(ctx)
 	}
+	defer os.RemoveAll(dir) //nolint
+	file := filepath.Join(dir, "secrets.tfvars.json")
+	err = ioutil.WriteFile(file, data, 0600)
+	if err != nil {
+		return err
+	}
+	return fn(file)
+}
 
-	return nil
+// output returns the value of a terraform output, or an empty string if the output does not exist yet.
+func (rt *gcpRuntime) output(ctx context.Context, name string) (string, error) {
+	if rt.outputs == nil {
+		tf, err := terraform.Init(ctx, rt.baseDir, os.Stdout, os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		outputs, err := tf.Output(ctx)
+		if err != nil {
+			return "", err
+		}
+		rt.outputs = make(map[string]string)
+		for k, v := range outputs {
+			var value string
+			if json.Unmarshal(v.Value, &value) == nil {
+				rt.outputs[k] = value
+			}
+		}
+	}
+	return rt.outputs[name], nil
+}
+
+func (rt *gcpRuntime) secret(name string) (string, error) {
+	value, found := rt.secrets[name]
+	if !found {
+		return "", fmt.Errorf("secret %s has not been initialised", name)
+	}
+	return value, nil
+}
+
+func (rt *gcpRuntime) execCommand(ctx context.Context, cmd api.Command) error {
+	tf, err := terraform.Init(ctx, rt.baseDir, os.Stdout, os.Stderr)
+	if err != nil {
+		return err
+	}
+	if cmd == api.Apply {
+		err = forgetResources(ctx, tf, func(state *tfjson.State) []string {
+			return append(legacyBindings(state), rt.shellVersions(state)...)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return rt.withSecretVars(func(varFile string) error {
+		switch cmd {
+		case api.Plan:
+			_, err = tf.Plan(ctx, tfexec.VarFile(varFile))
+			return err
+		case api.Export:
+			return nil
+		case api.Apply:
+			return tf.Apply(ctx, tfexec.VarFile(varFile))
+		case api.Delete:
+			return tf.Destroy(ctx, tfexec.VarFile(varFile))
+		}
+		return nil
+	})
 }
 
 func copyModules(entries []fs.DirEntry, fsPath string, targetDir string) error {
@@ -7,8 +7,10 @@ import (
 	"path/filepath"
 	"testing"
 
+	tfjson "github.com/hashicorp/terraform-json"
 	"github.com/stretchr/testify/assert"
 	"github.com/xlrte/core/pkg/api"
+	"github.com/xlrte/core/pkg/api/secrets"
 	"gopkg.in/yaml.v2"
 )
 
@@ -36,6 +38,7 @@ func Test_toCloudRunSettings(t *testing.T) {
 		}, api.DeploymentContext{Env: api.EnvVars{}, Resources: &bytes})
 	assert.NoError(t, err)
 	assert.Equal(t, "cloudrun-srv", conf.ServiceName)
+	assert.Equal(t, "cloudrun-srv-prod@theproject.iam.gserviceaccount.com", conf.ServiceAccount)
 	assert.Equal(t, "gcr.io/theproject/foo:v1", conf.ImageID)
 	assert.Equal(t, 100, conf.Traffic)
 	assert.False(t, conf.IsPublic)
@@ -128,7 +131,7 @@ func Test_CreateParent(t *testing.T) {
 		Env:       api.EnvVars{},
 	}
 
-	rt := &cloudRunLoader{tmpDir, service}
+	rt := &cloudRunLoader{baseDir: tmpDir, service: service}
 
 	resource, err := rt.Load(env, service, api.DeploymentContext{Env: api.EnvVars{}})
 
@@ -231,3 +234,58 @@ func Test_Network_Configuration(t *testing.T) {
 	assert.Equal(t, conf.NetworkConfig.Domain.Name, "xlrte.org")
 
 }
+
+func Test_Rotated_Secrets_Follow_Database(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tf_temp")
+	if err != nil {
+		log.Fatalf("error creating temp dir: %s", err)
+	}
+	defer func() {
+		e := os.RemoveAll(tmpDir)
+		assert.NoError(t, e)
+	}()
+	rte := NewRuntime(tmpDir, tmpDir).(*gcpRuntime)
+	rte.secretDeps["cloudsql-db_PASSWORD"] = "module.cloudsql-db"
+	err = rte.InitSecrets(api.EnvContext{EnvName: "prod"}, []*secrets.Secret{
+		{Name: "cloudsql-db_PASSWORD", Value: "new"},
+		{Name: "API_KEY", Value: "key"},
+	}, []string{"API_KEY", "UNUSED", "cloudsql-db_PASSWORD"})
+	assert.NoError(t, err)
+	file := filepath.Join(tmpDir, "main.tf")
+	assertInFile(t, file, "depends_on = [module.cloudsql-db]")
+	assertInFile(t, file, `module "secret-API_KEY"`)
+	assertInFile(t, file, `module "secret-UNUSED"`)
+	data, err := ioutil.ReadFile(filepath.Clean(file))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), `variable "secret_UNUSED"`, "unreferenced secrets are kept without their values")
+
+	state := &tfjson.State{Values: &tfjson.StateValues{RootModule: &tfjson.StateModule{ChildModules: []*tfjson.StateModule{
+		{Address: "module.secret-API_KEY", Resources: []*tfjson.StateResource{
+			{Address: "module.secret-API_KEY.google_secret_manager_secret_version.secret-version[0]", Type: "google_secret_manager_secret_version"},
+		}},
+		{Address: "module.secret-UNUSED", Resources: []*tfjson.StateResource{
+			{Address: "module.secret-UNUSED.google_secret_manager_secret_version.secret-version[0]", Type: "google_secret_manager_secret_version"},
+		}},
+	}}}}
+	assert.Equal(t, []string{"module.secret-UNUSED.google_secret_manager_secret_version.secret-version[0]"}, rte.shellVersions(state),
+		"versions of unreferenced secrets are forgotten instead of destroyed")
+	assert.Equal(t, "", os.Getenv("TF_VAR_secret_API_KEY"))
+
+	varFile := ""
+	err = rte.withSecretVars(func(file string) error {
+		varFile = file
+		info, e := os.Stat(file)
+		assert.NoError(t, e)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		assertInFile(t, file, `"secret_API_KEY":"key"`)
+		return nil
+	})
+	assert.NoError(t, err)
+	_, err = os.Stat(varFile)
+	assert.True(t, os.IsNotExist(err), "the var file is removed once terraform has run")
+
+	config := &cloudRunConfig{Env: api.EnvVars{Secrets: map[string]string{
+		"DB_db_PASSWORD": "module.secret-cloudsql-db_PASSWORD.secret_id",
+	}}}
+	assert.Equal(t, map[string]string{"DB_db_PASSWORD": "module.secret-cloudsql-db_PASSWORD.version"}, config.SecretVersions())
+}
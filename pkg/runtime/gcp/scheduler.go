@@ -0,0 +1,118 @@
+package gcp
+
+import (
+	_ "embed"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	_ "time/tzdata" // timezones are validated without relying on the zoneinfo of the machine
+
+	"github.com/xlrte/core/pkg/api"
+	"gopkg.in/yaml.v2"
+)
+
+//go:embed templates/scheduler.tf
+var schedulerMain string
+
+var jobName = regexp.MustCompile(`^[a-z][a-z0-9-]{0,14}$`)
+
+// schedulerJob calls the Cloud Run service that depends on it with OIDC auth, or publishes to a topic,
+// on a cron schedule.
+type schedulerJob struct {
+	baseDir  string
+	JobName  string `yaml:"name"`
+	Schedule string `yaml:"schedule"` // unix-cron, such as "0 2 * * *"
+	TimeZone string `yaml:"timezone"` // defaults to Etc/UTC
+	Target   string `yaml:"target"`   // http or pubsub
+	Path     string `yaml:"path"`
+	Topic    string `yaml:"topic"`
+	Body     string `yaml:"body"`
+	Service  string `yaml:"-"`
+}
+
+func (rt *schedulerJob) Load(d *api.ResourceDefinition) ([]api.Resource, []api.DependencyBinding, error) {
+	var jobs []*schedulerJob
+	var rs []api.Resource
+	var bindings []api.DependencyBinding
+
+	err := yaml.Unmarshal(d.ServiceConfig, &jobs)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, job := range jobs {
+		if job.TimeZone == "" {
+			job.TimeZone = "Etc/UTC"
+		}
+		err = job.validate()
+		if err != nil {
+			return nil, nil, err
+		}
+		job.baseDir = rt.baseDir
+		job.Service = d.DependedOnBy.ID
+		rs = append(rs, job)
+		bindings = append(bindings, api.DependencyBinding{
+			DependedOnBy: d.DependedOnBy,
+			Privileges:   api.Owner,
+			Identity:     job.Identity(),
+		})
+		if job.Target == "pubsub" {
+			// the topic must be created by a service that publishes to or consumes it
+			bindings = append(bindings, api.DependencyBinding{
+				DependedOnBy: job.Identity(),
+				Privileges:   api.ReadOnly,
+				Identity:     api.ResourceIdentity{Type: "pubsub", ID: job.Topic},
+				Declared:     true,
+			})
+		}
+	}
+	return rs, bindings, nil
+}
+
+func (r *schedulerJob) validate() error {
+	if !jobName.MatchString(r.JobName) {
+		return fmt.Errorf("invalid scheduler name '%s', names must start with a letter, only contain lower case letters, digits and '-' and be at most 15 characters", r.JobName)
+	}
+	if len(strings.Fields(r.Schedule)) != 5 {
+		return fmt.Errorf("scheduler %s: invalid schedule '%s', schedules use the unix-cron format such as '0 2 * * *'", r.JobName, r.Schedule)
+	}
+	if _, err := time.LoadLocation(r.TimeZone); err != nil {
+		return fmt.Errorf("scheduler %s: invalid timezone '%s'", r.JobName, r.TimeZone)
+	}
+	switch r.Target {
+	case "http":
+		if r.Topic != "" || !strings.HasPrefix(r.Path, "/") {
+			return fmt.Errorf("scheduler %s: http targets need a path starting with '/' and no topic", r.JobName)
+		}
+		if strings.ContainsAny(r.Path, `<>&'" `) {
+			return fmt.Errorf("scheduler %s: path must not contain spaces, quotes or any of <>&", r.JobName)
+		}
+	case "pubsub":
+		if r.Topic == "" || r.Path != "" {
+			return fmt.Errorf("scheduler %s: pubsub targets need a topic and no path", r.JobName)
+		}
+	default:
+		return fmt.Errorf("scheduler %s: invalid target '%s', valid targets are http and pubsub", r.JobName, r.Target)
+	}
+	return nil
+}
+
+// EncodedBody is the base64 encoded body, as both target types expect it, which also keeps it out of the reach of template escaping.
+func (r *schedulerJob) EncodedBody() string {
+	return base64.StdEncoding.EncodeToString([]byte(r.Body))
+}
+
+func (r *schedulerJob) Configure() error {
+	return applyTerraformTemplates(r.baseDir, []crFile{
+		{"scheduler.tf", schedulerMain},
+	}, r)
+}
+
+func (r *schedulerJob) Name() string {
+	return "scheduler"
+}
+
+func (r *schedulerJob) Identity() api.ResourceIdentity {
+	return api.ResourceIdentity{Type: r.Name(), ID: r.JobName}
+}
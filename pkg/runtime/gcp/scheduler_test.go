@@ -0,0 +1,69 @@
+package gcp
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xlrte/core/pkg/api"
+)
+
+func Test_Scheduler_Loads_Jobs(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tf_temp")
+	if err != nil {
+		log.Fatalf("error creating temp dir: %s", err)
+	}
+	defer func() {
+		e := os.RemoveAll(tmpDir)
+		assert.NoError(t, e)
+	}()
+	serviceData := getCloudRunBytes(t, filepath.Join("testdata", "scheduler", "service.yaml"), "scheduler")
+
+	loader := &schedulerJob{baseDir: tmpDir}
+	resources, bindings, err := loader.Load(&api.ResourceDefinition{
+		Name:          "scheduler",
+		DependedOnBy:  api.ResourceIdentity{ID: "the-service", Type: "cloudrun"},
+		ServiceConfig: serviceData,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, resources, 2)
+	assert.Len(t, bindings, 3)
+	assert.Equal(t, api.DependencyBinding{
+		DependedOnBy: api.ResourceIdentity{Type: "scheduler", ID: "tick"},
+		Privileges:   api.ReadOnly,
+		Identity:     api.ResourceIdentity{Type: "pubsub", ID: "ticks"},
+		Declared:     true,
+	}, bindings[2])
+
+	nightly := resources[0].(*schedulerJob)
+	assert.NoError(t, nightly.Configure())
+	file := filepath.Join(tmpDir, "main.tf")
+	assertInFile(t, file, `schedule = "0 2 * * *"`)
+	assertInFile(t, file, `time_zone = "Europe/Zurich"`)
+	assertInFile(t, file, `body = "eyJmdWxsIjogdHJ1ZX0="`)
+	assertInFile(t, file, `service_url = "${module.cloudrun-the-service.cloud_run_endpoint}/jobs/nightly"`)
+
+	tick := resources[1].(*schedulerJob)
+	assert.Equal(t, "Etc/UTC", tick.TimeZone)
+	assert.NoError(t, tick.Configure())
+	assertInFile(t, file, `topic = "ticks"`)
+	assertInFile(t, file, `depends_on = [module.pubsub-ticks]`)
+}
+
+func Test_Scheduler_Validation(t *testing.T) {
+	invalid := []*schedulerJob{
+		{JobName: "Nightly", Schedule: "0 2 * * *", TimeZone: "Etc/UTC", Target: "http", Path: "/"},
+		{JobName: "nightly", Schedule: "daily", TimeZone: "Etc/UTC", Target: "http", Path: "/"},
+		{JobName: "nightly", Schedule: "0 2 * * *", TimeZone: "Mars/Olympus", Target: "http", Path: "/"},
+		{JobName: "nightly", Schedule: "0 2 * * *", TimeZone: "Etc/UTC", Target: "http"},
+		{JobName: "nightly", Schedule: "0 2 * * *", TimeZone: "Etc/UTC", Target: "http", Path: "/run?a=1&b=2"},
+		{JobName: "nightly", Schedule: "0 2 * * *", TimeZone: "Etc/UTC", Target: "pubsub", Path: "/"},
+		{JobName: "nightly", Schedule: "0 2 * * *", TimeZone: "Etc/UTC", Target: "tasks", Path: "/"},
+	}
+	for _, job := range invalid {
+		assert.Error(t, job.validate())
+	}
+}
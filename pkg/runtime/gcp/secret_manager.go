@@ -0,0 +1,151 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	nethttp "net/http"
+	"path"
+	"strings"
+
+	"github.com/xlrte/core/pkg/api"
+)
+
+// secretManager decides how secret values reach Secret Manager, configured by the `secret_manager` resource of an
+// environment. With `mode: terraform`, the default, terraform creates the secret versions and keeps their values in
+// its state. With `mode: api` terraform only manages the secrets and their IAM, and xlrte adds the versions
+// through the Secret Manager API. Database passwords are still kept in state by the database users.
+type secretManager struct {
+	Mode        string `yaml:"mode"`
+	project     string
+	environment string
+	endpoint    string
+	client      *nethttp.Client
+	token       func() (string, error)
+}
+
+func newSecretManager() *secretManager {
+	return &secretManager{Mode: "terraform", endpoint: "https://secretmanager.googleapis.com", token: accessToken}
+}
+
+func (sm *secretManager) init(ctx api.EnvContext) error {
+	if ctx.Config != nil {
+		err := ctx.Config("secret_manager", sm)
+		if err != nil {
+			return err
+		}
+	}
+	if sm.Mode == "" {
+		sm.Mode = "terraform"
+	}
+	if sm.Mode != "terraform" && sm.Mode != "api" {
+		return fmt.Errorf("secret_manager: unsupported mode '%s', supported modes are 'terraform' and 'api'", sm.Mode)
+	}
+	sm.project = ctx.Context
+	sm.environment = ctx.EnvName
+	return nil
+}
+
+func (sm *secretManager) api() bool {
+	return sm.Mode == "api"
+}
+
+type secretPayload struct {
+	Data string `json:"data"`
+}
+
+type secretVersion struct {
+	Name    string         `json:"name"`
+	Payload *secretPayload `json:"payload,omitempty"`
+}
+
+// latestVersion returns the number of the latest version of a secret if it holds value, or an empty string if the
+// secret has no version yet, its latest version holds another value or has been disabled or destroyed.
+func (sm *secretManager) latestVersion(ctx context.Context, name, value string) (string, error) {
+	latest := &secretVersion{}
+	status, err := sm.do(ctx, nethttp.MethodGet, sm.secretName(name)+"/versions/latest:access", nil, latest)
+	if status == nethttp.StatusNotFound {
+		return "", nil
+	}
+	// disabled and destroyed versions cannot be accessed
+	if status == nethttp.StatusBadRequest && strings.Contains(err.Error(), "FAILED_PRECONDITION") {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if latest.Payload == nil {
+		return "", fmt.Errorf("secret %s: no payload in the latest version", name)
+	}
+	data, err := base64.StdEncoding.DecodeString(latest.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("secret %s: %w", name, err)
+	}
+	if string(data) != value {
+		return "", nil
+	}
+	return path.Base(latest.Name), nil
+}
+
+// addVersion adds value as the new version of a secret unless it is the latest version already,
+// and returns the number of the version holding it.
+func (sm *secretManager) addVersion(ctx context.Context, name, value string) (string, error) {
+	version, err := sm.latestVersion(ctx, name, value)
+	if err != nil || version != "" {
+		return version, err
+	}
+	added := &secretVersion{}
+	request := &secretVersion{Payload: &secretPayload{Data: base64.StdEncoding.EncodeToString([]byte(value))}}
+	_, err = sm.do(ctx, nethttp.MethodPost, sm.secretName(name)+":addVersion", request, added)
+	if err != nil {
+		return "", err
+	}
+	return path.Base(added.Name), nil
+}
+
+// secretName is the resource name of a secret, suffixed with the environment like in modules/secret_manager.
+func (sm *secretManager) secretName(name string) string {
+	return fmt.Sprintf("projects/%s/secrets/%s-%s", sm.project, name, sm.environment)
+}
+
+func (sm *secretManager) do(ctx context.Context, method, resource string, body interface{}, out interface{}) (int, error) {
+	payload := []byte{}
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		payload = data
+	}
+	req, err := nethttp.NewRequestWithContext(ctx, method, fmt.Sprintf("%s/v1/%s", sm.endpoint, resource), bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	token, err := sm.token()
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	client := sm.client
+	if client == nil {
+		client = nethttp.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close() //nolint
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode != nethttp.StatusOK {
+		// the response names the secret, but never holds its value
+		return resp.StatusCode, fmt.Errorf("secret manager returned %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	return resp.StatusCode, json.Unmarshal(data, out)
+}
@@ -0,0 +1,203 @@
+package gcp
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/xlrte/core/pkg/api"
+	"github.com/xlrte/core/pkg/api/secrets"
+)
+
+// fakeSecretManager keeps the versions of secrets like the Secret Manager API, for secrets it knows.
+type fakeSecretManager struct {
+	sync.Mutex
+	versions  map[string][]string
+	destroyed map[string]bool // secrets whose latest version has been destroyed
+	requests  []string
+}
+
+func (f *fakeSecretManager) ServeHTTP(w nethttp.ResponseWriter, r *nethttp.Request) {
+	f.Lock()
+	defer f.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(nethttp.StatusUnauthorized)
+		return
+	}
+	resource := strings.TrimPrefix(r.URL.Path, "/v1/")
+	switch {
+	case r.Method == nethttp.MethodGet && strings.HasSuffix(resource, "/versions/latest:access"):
+		secret := strings.TrimSuffix(resource, "/versions/latest:access")
+		versions, found := f.versions[secret]
+		if !found || len(versions) == 0 {
+			w.WriteHeader(nethttp.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error": {"code": 404, "status": "NOT_FOUND"}}`))
+			return
+		}
+		if f.destroyed[secret] {
+			w.WriteHeader(nethttp.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": {"code": 400, "status": "FAILED_PRECONDITION"}}`))
+			return
+		}
+		f.write(w, secret, len(versions), versions[len(versions)-1])
+	case r.Method == nethttp.MethodPost && strings.HasSuffix(resource, ":addVersion"):
+		secret := strings.TrimSuffix(resource, ":addVersion")
+		if _, found := f.versions[secret]; !found {
+			w.WriteHeader(nethttp.StatusNotFound)
+			return
+		}
+		var request secretVersion
+		data, _ := ioutil.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &request)
+		value, _ := base64.StdEncoding.DecodeString(request.Payload.Data)
+		f.versions[secret] = append(f.versions[secret], string(value))
+		delete(f.destroyed, secret)
+		f.write(w, secret, len(f.versions[secret]), "")
+	default:
+		w.WriteHeader(nethttp.StatusBadRequest)
+	}
+}
+
+func (f *fakeSecretManager) write(w nethttp.ResponseWriter, secret string, version int, value string) {
+	response := secretVersion{Name: fmt.Sprintf("%s/versions/%d", secret, version)}
+	if value != "" {
+		response.Payload = &secretPayload{Data: base64.StdEncoding.EncodeToString([]byte(value))}
+	}
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func Test_Secret_Manager_Mode(t *testing.T) {
+	sm := newSecretManager()
+	assert.NoError(t, sm.init(api.EnvContext{Context: "theproject", EnvName: "prod"}))
+	assert.False(t, sm.api())
+
+	sm = newSecretManager()
+	assert.NoError(t, sm.init(envWithResources(t, filepath.Join("testdata", "secret_manager", "resources.yaml"))))
+	assert.True(t, sm.api())
+	assert.Equal(t, "projects/theproject/secrets/API_KEY-prod", sm.secretName("API_KEY"))
+
+	sm = newSecretManager()
+	sm.Mode = "vault"
+	assert.Error(t, sm.init(api.EnvContext{}))
+}
+
+func Test_Secret_Manager_Adds_Changed_Versions(t *testing.T) {
+	fake := &fakeSecretManager{versions: map[string][]string{"projects/theproject/secrets/API_KEY-prod": {}}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	sm := &secretManager{Mode: "api", project: "theproject", environment: "prod", endpoint: server.URL, client: server.Client(),
+		token: func() (string, error) { return "token", nil }}
+	ctx := context.Background()
+
+	version, err := sm.latestVersion(ctx, "API_KEY", "first")
+	assert.NoError(t, err)
+	assert.Equal(t, "", version)
+
+	version, err = sm.addVersion(ctx, "API_KEY", "first")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", version)
+	version, err = sm.addVersion(ctx, "API_KEY", "first")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", version, "unchanged values are not added again")
+	version, err = sm.addVersion(ctx, "API_KEY", "second")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", version)
+	assert.Equal(t, []string{"first", "second"}, fake.versions["projects/theproject/secrets/API_KEY-prod"])
+
+	version, err = sm.latestVersion(ctx, "API_KEY", "second")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", version)
+
+	_, err = sm.addVersion(ctx, "OTHER", "value")
+	assert.Error(t, err)
+	sm.token = func() (string, error) { return "expired", nil }
+	_, err = sm.latestVersion(ctx, "API_KEY", "second")
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "second")
+}
+
+func Test_Secret_Manager_Switch_From_Terraform(t *testing.T) {
+	state := &tfjson.State{Values: &tfjson.StateValues{RootModule: &tfjson.StateModule{ChildModules: []*tfjson.StateModule{
+		{Address: "module.secret-API_KEY", Resources: []*tfjson.StateResource{
+			{Address: "module.secret-API_KEY.google_secret_manager_secret.secret", Type: "google_secret_manager_secret"},
+			{Address: "module.secret-API_KEY.google_secret_manager_secret_version.secret-version[0]", Type: "google_secret_manager_secret_version"},
+		}},
+		{Address: "module.secret-OLD", Resources: []*tfjson.StateResource{
+			{Address: "module.secret-OLD.google_secret_manager_secret_version.secret-version", Type: "google_secret_manager_secret_version"},
+		}},
+		{Address: "module.cloudsql-db", Resources: []*tfjson.StateResource{
+			{Address: "module.cloudsql-db.google_sql_user.user", Type: "google_sql_user"},
+		}},
+	}}}}
+	assert.Equal(t, []string{
+		"module.secret-API_KEY.google_secret_manager_secret_version.secret-version[0]",
+		"module.secret-OLD.google_secret_manager_secret_version.secret-version",
+	}, managedVersions(state), "versions terraform created are removed from the state, not destroyed")
+	assert.Len(t, managedVersions(&tfjson.State{}), 0)
+
+	// versions terraform created stay in Secret Manager, the latest of OLD has been destroyed since
+	fake := &fakeSecretManager{
+		versions: map[string][]string{
+			"projects/theproject/secrets/API_KEY-prod": {"key"},
+			"projects/theproject/secrets/OLD-prod":     {"old"},
+		},
+		destroyed: map[string]bool{"projects/theproject/secrets/OLD-prod": true},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	sm := &secretManager{Mode: "api", project: "theproject", environment: "prod", endpoint: server.URL, client: server.Client(),
+		token: func() (string, error) { return "token", nil }}
+	ctx := context.Background()
+
+	version, err := sm.addVersion(ctx, "API_KEY", "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", version, "services stay pinned to the version terraform created")
+	version, err = sm.latestVersion(ctx, "OLD", "old")
+	assert.NoError(t, err)
+	assert.Equal(t, "", version)
+	version, err = sm.addVersion(ctx, "OLD", "old")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", version)
+	assert.Equal(t, []string{"old", "old"}, fake.versions["projects/theproject/secrets/OLD-prod"])
+}
+
+func Test_Secret_Manager_Api_Mode_Keeps_Values_Out_Of_Terraform(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tf_temp")
+	if err != nil {
+		log.Fatalf("error creating temp dir: %s", err)
+	}
+	defer func() {
+		e := os.RemoveAll(tmpDir)
+		assert.NoError(t, e)
+	}()
+	rte := NewRuntime(tmpDir, tmpDir).(*gcpRuntime)
+	rte.secretMgr.Mode = "api"
+	err = rte.InitSecrets(api.EnvContext{EnvName: "prod"}, []*secrets.Secret{{Name: "API_KEY", Value: "key"}}, []string{"API_KEY"})
+	assert.NoError(t, err)
+	file := filepath.Join(tmpDir, "main.tf")
+	assertInFile(t, file, "manage_version = false")
+	assertInFile(t, file, "secret_version = var.secret_version_API_KEY")
+	data, err := ioutil.ReadFile(filepath.Clean(file))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "secret_data")
+
+	rte.versions["API_KEY"] = "3"
+	err = rte.withSecretVars(func(file string) error {
+		assertInFile(t, file, `"secret_version_API_KEY":"3"`)
+		return nil
+	})
+	assert.NoError(t, err)
+}
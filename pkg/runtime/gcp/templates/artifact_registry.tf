@@ -0,0 +1,11 @@
+
+module "artifact_registry" {
+  source = "../modules/artifact_registry"
+  project = var.project
+  region = var.region
+  environment = var.environment
+}
+
+output "docker_repository" {
+  value = module.artifact_registry.repository
+}
@@ -7,6 +7,7 @@ module "cloudrun-{{.ServiceName}}" {
   {{ if .HasServerlessNetwork}}
   serverless_network = {{.ServerlessNetworkLink}}
   has_serverless_network = {{.HasServerlessNetwork}}
+  egress_all_traffic = {{.EgressAllTraffic}}
   {{ end }}
   image_id = "{{.ImageID}}"
   traffic = {{.Traffic}}
@@ -18,6 +19,7 @@ module "cloudrun-{{.ServiceName}}" {
   max_instances =  {{.RuntimeConfig.Scaling.MaxInstances}}
   is_public = {{.IsPublic}}
   http2 = {{.Http2}}
+  {{ if .Ingress }}ingress = "{{.Ingress}}"{{ end }}
   env = { {{ range $key, $value := .Env.Vars }}
     {{ $key }} = "{{ $value }}"
   {{ end }}}
@@ -27,6 +29,9 @@ module "cloudrun-{{.ServiceName}}" {
   secrets = { {{ range $key, $value := .Env.Secrets }}
     {{ $key }} = {{ $value }}
   {{ end }}}
+  secret_versions = { {{ range $key, $value := .SecretVersions }}
+    {{ $key }} = {{ $value }}
+  {{ end }}}
 
   publish_topics = [{{ range $key, $value := .PublishTopics }}"{{ $value }}",{{ end }}]
 
@@ -39,11 +44,17 @@ module "cloudrun-{{.ServiceName}}" {
       enable_message_ordering = {{$value.EnableMessageOrdering}}
     },{{ end }}]
 
-  gcs_buckets = [{{ range $key, $value := .CloudStorage }}
+  gcs_buckets = [{{ range $key, $value := .CloudStorage }}{{ range $role := $value.Roles }}
     {
       bucket_name = "{{$value.Bucket}}"
-      role = "{{$value.Role}}"
-    },{{ end }}]
+      role = "{{$role}}"
+    },{{ end }}{{ end }}]
+
+  task_queues = [{{ range $key, $value := .TaskQueues }}"{{ $value }}",{{ end }}]
+
+  invoke_services = [{{ range $key, $value := .InvokeServices }}"{{ $value }}",{{ end }}]
+
+  project_roles = [{{ range $key, $value := .ProjectRoles }}"{{ $value }}",{{ end }}]
 
   depends_on = [{{ range $key, $value := .DependsOn }}{{ $value }},{{ end }}]
 
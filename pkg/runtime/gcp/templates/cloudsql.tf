@@ -1,9 +1,10 @@
 module "cloudsql-{{.DbName}}" {
-  source = "../modules/cloudsql_postgres"
+  source = "../modules/cloudsql"
   db_name = "{{.DbName}}"
   project = var.project
   region = var.region
   environment = var.environment
+  database_version = "{{.DBType}}"
   master_user_name = var.secret_cloudsql-{{.DbName}}_USER
   master_user_password = var.secret_cloudsql-{{.DbName}}_PASSWORD
   instance_name = "{{.DbName}}-instance"
@@ -15,5 +16,19 @@ module "cloudsql-{{.DbName}}" {
   postgres_point_in_time_recovery_enabled = {{.PointInTimeRecoveryEnabled}}
   maintenance_window_day = {{.MaintenanceWindowDay}}
   maintenance_window_hour = {{.MaintenanceWindowHour}}
+  high_availability = {{.HighAvailability}}
+  read_replicas = {{.ReadReplicas}}
+  database_flags = { {{ range $key, $value := .Flags }}
+    "{{ $key }}" = "{{ $value }}"
+  {{ end }}}
+  databases = [{{ range $key, $value := .Databases }}"{{ $value }}",{{ end }}]
+  users = [{{ range $key, $value := .Users }}"{{ $value }}",{{ end }}]
+  user_passwords = { {{ range $key, $value := .Users }}
+    "{{ $value }}" = var.secret_cloudsql-{{$.DbName}}_{{ $value }}_PASSWORD
+  {{ end }}}
   network_self_link = {{.NetworkLink}}
+  network_name = "{{.NetworkName}}"
+  peering_address = "{{.PeeringAddress}}"
+  peering_prefix_length = {{.PeeringPrefix}}
+  create_private_service_access = {{.CreatePeering}}
 }
@@ -0,0 +1,7 @@
+output "cloudsql-{{.DbName}}-private_ip" {
+  value = module.cloudsql-{{.DbName}}.master_private_ip
+}
+
+output "cloudsql-{{.DbName}}-db_name" {
+  value = module.cloudsql-{{.DbName}}.db_name
+}
@@ -6,4 +6,35 @@ module "cloudstorage-{{.BucketName}}" {
   versioning_enabled = {{.VersioningEnabled}}
   environment = var.environment
   public = {{.IsPublic}}
+  uniform_access = {{.UniformAccess}}
+
+  lifecycle_rules = [{{ range $key, $value := .Lifecycle }}
+    {
+      action = "{{ if eq $value.Action "transition" }}SetStorageClass{{ else }}Delete{{ end }}"
+      storage_class = {{ if eq $value.Action "transition" }}"{{ $value.StorageClass }}"{{ else }}null{{ end }}
+      age = {{ if $value.Age }}{{ $value.Age }}{{ else }}null{{ end }}
+      num_newer_versions = {{ if $value.KeepVersions }}{{ $value.KeepVersions }}{{ else }}null{{ end }}
+    },{{ end }}]
+
+  cors = [{{ range $key, $value := .CORS }}
+    {
+      origins = [{{ range $value.Origins }}"{{ . }}",{{ end }}]
+      methods = [{{ range $value.Methods }}"{{ . }}",{{ end }}]
+      response_headers = [{{ range $value.ResponseHeaders }}"{{ . }}",{{ end }}]
+      max_age_seconds = {{ $value.MaxAge }}
+    },{{ end }}]
+
+  retention = {{ if .Retention }}{
+    period_days = {{ .Retention.PeriodDays }}
+    locked = {{ .Retention.Locked }}
+  }{{ else }}null{{ end }}
+
+  notifications = [{{ range $key, $value := .Notifications }}
+    {
+      topic = "{{ $value.Topic }}"
+      events = [{{ range $value.Events }}"{{ . }}",{{ end }}]
+      prefix = {{ if $value.Prefix }}"{{ $value.Prefix }}"{{ else }}null{{ end }}
+    },{{ end }}]
+
+  depends_on = [{{ range $key, $value := .Notifications }}module.pubsub-{{ $value.Topic }},{{ end }}]
 }
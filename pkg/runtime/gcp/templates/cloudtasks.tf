@@ -0,0 +1,14 @@
+
+module "cloudtasks-{{.QueueName}}" {
+  source = "../modules/cloudtasks"
+  name = "{{.QueueName}}"
+  project = var.project
+  region = var.region
+  environment = var.environment
+  max_dispatches_per_second = {{.MaxDispatchesPerSecond}}
+  max_concurrent_dispatches = {{.MaxConcurrentDispatches}}
+  max_attempts = {{.MaxAttempts}}
+  min_backoff_seconds = {{.MinBackoff}}
+  max_backoff_seconds = {{.MaxBackoff}}
+  max_retry_duration_seconds = {{.MaxRetryDuration}}
+}
@@ -0,0 +1,18 @@
+
+module "eventarc-{{.TriggerName}}" {
+  source = "../modules/eventarc"
+  name = "{{.TriggerName}}"
+  project = var.project
+  region = var.region
+  location = {{ if .Location }}"{{.Location}}"{{ else }}var.region{{ end }}
+  environment = var.environment
+  service = "{{.Service}}"
+  event_type = "{{.Event}}"
+  storage_event = {{.StorageEvent}}
+  filters = { {{ range $key, $value := .Filters }}
+    "{{ $key }}" = "{{ $value }}"
+  {{ end }}}
+  path = {{ if .Path }}"{{.Path}}"{{ else }}null{{ end }}
+
+  depends_on = [module.cloudrun-{{.Service}}{{ if .Bucket }}, module.cloudstorage-{{.Bucket}}{{ end }}]
+}
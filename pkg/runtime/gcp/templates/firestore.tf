@@ -0,0 +1,6 @@
+module "firestore-default" {
+  source = "../modules/firestore"
+  project = var.project
+  location = "{{.Location}}"
+  database_type = "{{.DatabaseType}}"
+}
@@ -0,0 +1,41 @@
+module "http_ingress" {
+  source = "../modules/http_ingress"
+  project = var.project
+  region = var.region
+  environment = var.environment
+  domains = [{{ range $key, $value := .Domains }}"{{ $value }}",{{ end }}]
+  managed_zone = "{{.DNSZone}}"
+  default_service = "{{.DefaultService}}"
+  services = { {{ range $key, $value := .Routes }}
+    "{{ $value.Service }}" = {
+      path = "{{ $value.Path }}"
+      security = {{ if $value.Security }}[{{ range $value.Security.Rules }}
+        {
+          priority = {{ .Priority }}
+          action = "{{ .Action }}"
+          description = "{{ .Description }}"
+          src_ip_ranges = [{{ range .SrcIPRanges }}"{{ . }}",{{ end }}]
+          owasp = "{{ .OWASP }}"
+          rate_limit = {{ if .RateLimit }}{
+            requests = {{ .RateLimit.Requests }}
+            interval_sec = {{ .RateLimit.IntervalSec }}
+            ban_duration_sec = {{ .RateLimit.BanDurationSec }}
+          }{{ else }}null{{ end }}
+        },{{ end }}
+      ]{{ else }}null{{ end }}
+      cdn = {{ if $value.CDN }}{
+        cache_mode = "{{ $value.CDN.CacheMode }}"
+        default_ttl = {{ $value.CDN.DefaultTTL }}
+        max_ttl = {{ $value.CDN.MaxTTL }}
+        client_ttl = {{ $value.CDN.ClientTTL }}
+        negative_caching = {{ $value.CDN.NegativeCaching }}
+      }{{ else }}null{{ end }}
+    }
+  {{ end }}}
+
+  depends_on = [{{ range $key, $value := .Routes }}module.cloudrun-{{ $value.Service }},{{ end }}]
+}
+
+output "http_ingress_ip" {
+  value = module.http_ingress.external_ip
+}
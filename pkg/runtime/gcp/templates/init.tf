@@ -18,9 +18,16 @@ variable "services"{
   default = [
     "servicenetworking.googleapis.com",
     "vpcaccess.googleapis.com",
+    "artifactregistry.googleapis.com",
+    "cloudscheduler.googleapis.com",
+    "cloudtasks.googleapis.com",
+    "compute.googleapis.com",
     "containerregistry.googleapis.com",
     "dns.googleapis.com",
+    "eventarc.googleapis.com",
+    "firestore.googleapis.com",
     "pubsub.googleapis.com",
+    "redis.googleapis.com",
     "run.googleapis.com",
     "secretmanager.googleapis.com",
     "sql-component.googleapis.com",
@@ -1,9 +1,14 @@
-module "private_network-network" {
+module "private_network-{{.NetworkName}}" {
   source = "../modules/private_network"
   project = var.project
   region = var.region
   environment = var.environment
+  network_name = "{{.NetworkName}}"
+  subnet_cidr = "{{.SubnetCIDR}}"
+  existing_network = "{{.ExistingNetwork}}"
+  host_project = "{{.HostProject}}"
   min_instances = {{.MinInstances}}
   max_instances = {{.MaxInstances}}
   instance_type = "{{.InstanceType}}"
 }
+
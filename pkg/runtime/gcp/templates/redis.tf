@@ -0,0 +1,11 @@
+module "redis-{{.CacheName}}" {
+  source = "../modules/redis"
+  name = "{{.CacheName}}"
+  project = var.project
+  region = var.region
+  environment = var.environment
+  tier = "{{.Tier}}"
+  memory_size_gb = {{.MemorySize}}
+  redis_version = "{{.Version}}"
+  network_self_link = {{.NetworkLink}}
+}
@@ -0,0 +1,15 @@
+
+module "scheduler-{{.JobName}}" {
+  source = "../modules/scheduler"
+  name = "{{.JobName}}"
+  project = var.project
+  region = var.region
+  environment = var.environment
+  schedule = "{{.Schedule}}"
+  time_zone = "{{.TimeZone}}"
+  body = "{{.EncodedBody}}"
+  {{ if eq .Target "http" }}service = "{{.Service}}"
+  service_url = "${module.cloudrun-{{.Service}}.cloud_run_endpoint}{{.Path}}"{{ else }}topic = "{{.Topic}}"{{ end }}
+
+  depends_on = [{{ if eq .Target "http" }}module.cloudrun-{{.Service}}{{ else }}module.pubsub-{{.Topic}}{{ end }}]
+}
@@ -1,12 +1,29 @@
+{{ if .Shell }}
+module "secret-{{.Name}}" {
+  source = "../modules/secret_manager"
+  secret_id = "{{.Name}}"
+  manage_version = false
+  environment = var.environment
+  project = var.project
+}
+{{ else }}
 variable "secret_{{.Name}}"{
   type = string
   sensitive = true
 }
-
+{{ if .API }}
+variable "secret_version_{{.Name}}"{
+  type = string
+  default = "latest"
+}
+{{ end }}
 module "secret-{{.Name}}" {
   source = "../modules/secret_manager"
   secret_id = "{{.Name}}"
-  secret_data = var.secret_{{.Name}}
+  {{ if .API }}manage_version = false
+  secret_version = var.secret_version_{{.Name}}{{ else }}secret_data = var.secret_{{.Name}}{{ end }}
   environment = var.environment
   project = var.project
+  {{ if .DependsOn }}depends_on = [{{.DependsOn}}]{{ end }}
 }
+{{ end }}